@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/my-deer/mydeer/internal/account"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/rbac"
+)
+
+const adminUsage = `usage: mydeer admin [flags] <command>
+
+commands:
+  bootstrap <email>  make the existing account with the email address the first admin;
+                     refused when an admin already exists unless -force is given.
+                     Further roles are assigned with PUT /admin/users/:id/roles.
+
+flags:
+`
+
+// runAdmin implements the "admin" subcommand and returns the process exit code
+func runAdmin(args []string) int {
+	fset := flag.NewFlagSet("admin", flag.ContinueOnError)
+	configPath := fset.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	force := fset.Bool("force", false, "grant the admin role even if an admin already exists")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), adminUsage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		return 1
+	}
+
+	mydb, err := db.Open(cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		return 1
+	}
+	defer mydb.Close()
+
+	ctx := context.Background()
+	switch command := fset.Arg(0); command {
+	case "bootstrap":
+		if fset.NArg() != 2 {
+			fset.Usage()
+			return 2
+		}
+		err = bootstrapAdmin(ctx, mydb, fset.Arg(1), *force)
+	default:
+		fmt.Fprintf(os.Stderr, "admin: unknown command %q\n", command)
+		fset.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		return 1
+	}
+	return 0
+}
+
+// bootstrapAdmin adds the admin role to the account with the email address.
+// The change is recorded in the audit log with the system as the actor.
+func bootstrapAdmin(ctx context.Context, mydb *db.DB, email string, force bool) error {
+	if !force {
+		admins, err := mydb.CountUsersWithRole(ctx, rbac.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if admins > 0 {
+			return fmt.Errorf("an admin already exists; ask an admin to assign the role or use -force")
+		}
+	}
+
+	user, err := mydb.GetUserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("no account with the email address %s: %w", email, err)
+	}
+	roles, err := mydb.ListUserRoles(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// ロールの変更だけなので2段階認証のサービスと猶予期間は使わない
+	accounts := account.NewService(accountDeps(mydb))
+	roles, err = accounts.SetRoles(ctx, user.ID, append(roles, rbac.RoleAdmin), account.Client{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (%s) now has the roles: %v\n", email, user.ID, roles)
+	return nil
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/my-deer/mydeer/internal/account"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/passhash"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/types"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// AccountHandler serves the deletion and the data export of the authenticated player's account
+type AccountHandler struct {
+	users     db.UserRepository
+	passwords *passhash.Hasher
+	mfa       *mfa.Service
+	accounts  *account.Service
+	lockout   *lockout.Tracker
+	auth      *AuthHandler
+}
+
+// NewAccountHandler creates an AccountHandler. tracker throttles wrong passwords and codes given to
+// confirm the deletion, and authHandler clears the session cookies once the deletion is scheduled.
+func NewAccountHandler(users db.UserRepository, passwords *passhash.Hasher, mfaService *mfa.Service, accounts *account.Service, tracker *lockout.Tracker, authHandler *AuthHandler) *AccountHandler {
+	return &AccountHandler{
+		users:     users,
+		passwords: passwords,
+		mfa:       mfaService,
+		accounts:  accounts,
+		lockout:   tracker,
+		auth:      authHandler,
+	}
+}
+
+// DeleteMeInput は退会の入力構造体です。パスワードと、2段階認証が有効な場合は認証コード
+// （またはリカバリーコード）で本人確認します。
+// SSOだけで登録したアカウントは、先にパスワード再設定でパスワードを設定してください。
+type DeleteMeInput struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"`
+}
+
+// auditClient returns the caller and client details recorded in the audit log
+func auditClient(c *gin.Context) account.Client {
+	client := account.Client{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		client.ActorID = principal.UserID
+	}
+	return client
+}
+
+// DeleteMe schedules the deletion of the authenticated player's account after re-authentication.
+// The player is logged out everywhere; logging in again within the grace period cancels the deletion.
+func (h *AccountHandler) DeleteMe(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	var input DeleteMeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	user, err := h.users.GetUserByID(c, principal.UserID)
+	if err != nil {
+		logger.Error("delete-me: user lookup failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to verify credentials", http.StatusInternalServerError))
+		return
+	}
+	if !checkReauthLockout(c, h.lockout, user, "delete-me") {
+		return
+	}
+	if ok, _ := h.passwords.Verify(user.Password, input.Password); !ok {
+		logger.Warn("delete-me: wrong password", "user_id", user.ID)
+		recordReauthFailure(c, h.lockout, user, "delete-me")
+		c.Error(apperrors.ErrWrongPassword)
+		return
+	}
+
+	mfaEnabled, err := h.mfa.Enabled(c, user.ID)
+	if err != nil {
+		logger.Error("delete-me: failed to check two-factor authentication", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to verify credentials", http.StatusInternalServerError))
+		return
+	}
+	if mfaEnabled {
+		if input.Code == "" {
+			c.Error(apperrors.ErrInvalidInput.WithDetails([]apperrors.FieldViolation{{Field: "code", Rule: "required"}}))
+			return
+		}
+		if err = h.mfa.Verify(c, user.ID, input.Code); err != nil {
+			logger.Warn("delete-me: second factor rejected", "user_id", user.ID, "error", err.Error())
+			if errors.Is(err, mfa.ErrInvalidCode) {
+				recordReauthFailure(c, h.lockout, user, "delete-me")
+			}
+			c.Error(mfaError(err))
+			return
+		}
+	}
+	if err = h.lockout.RecordSuccess(c, user.Email); err != nil {
+		logger.Error("delete-me: failed to reset lockout", "user_id", user.ID, "error", err.Error())
+	}
+
+	deleteAfter, err := h.accounts.RequestDeletion(c, user.ID, auditClient(c))
+	if err != nil {
+		logger.Error("delete-me: failed to schedule deletion", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to delete account", http.StatusInternalServerError))
+		return
+	}
+
+	h.auth.clearSessionCookies(c)
+	logger.Info("delete-me: deletion scheduled", "user_id", user.ID, "delete_after", deleteAfter)
+	c.JSON(http.StatusAccepted, gin.H{"message": "deletion_scheduled", "delete_after": deleteAfter})
+}
+
+// ExportMe returns everything stored about the authenticated player as a downloadable JSON file
+func (h *AccountHandler) ExportMe(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	data, err := h.accounts.Export(c, principal.UserID, auditClient(c))
+	if err != nil {
+		logger.Error("export-me: failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to export account data", http.StatusInternalServerError))
+		return
+	}
+
+	now := time.Now().UTC()
+	logger.Info("export-me: exported", "user_id", principal.UserID)
+	c.Header("Content-Disposition", `attachment; filename="mydeer-export-`+now.Format("20060102")+`.json"`)
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, types.NewExport(data, now))
+}
+
+// cancelDeletion restores the account of a player who logs in during the deletion grace period
+func (h *AuthHandler) cancelDeletion(c *gin.Context, user db.User) error {
+	if !user.DeleteAfter.Valid {
+		return nil
+	}
+	// ログイン前なので本人を操作者として記録する
+	client := auditClient(c)
+	client.ActorID = user.ID
+	cancelled, err := h.accounts.CancelDeletion(c, user.ID, client)
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel account deletion")
+	}
+	if cancelled {
+		utils.GetLogger(c).Info("login: account deletion cancelled", "user_id", user.ID)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/account"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/rbac"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/types"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// defaultAdminPageSize is the number of users listed when the limit is omitted
+const defaultAdminPageSize = 50
+
+// AdminHandler serves the admin API for moderators and admins: searching accounts, suspending them,
+// logging them out and changing their roles. The routes check permissions with middleware.RequirePermission.
+type AdminHandler struct {
+	users    db.UserRepository
+	profiles db.ProfileRepository
+	attempts db.LoginAttemptRepository
+	authz    *rbac.Authorizer
+	accounts *account.Service
+}
+
+// NewAdminHandler creates an AdminHandler. attempts is the store of the login lockout,
+// whose state is shown with the account.
+func NewAdminHandler(users db.UserRepository, profiles db.ProfileRepository, attempts db.LoginAttemptRepository, authz *rbac.Authorizer, accounts *account.Service) *AdminHandler {
+	return &AdminHandler{
+		users:    users,
+		profiles: profiles,
+		attempts: attempts,
+		authz:    authz,
+		accounts: accounts,
+	}
+}
+
+// ListUsersInput はアカウント検索のクエリパラメータです。
+// q はメールアドレス・名前・ハンドルの部分一致（大文字小文字を区別しない）で、省略時は全件です。
+type ListUsersInput struct {
+	Query  string `json:"q" form:"q" binding:"max=100"`
+	Limit  int    `json:"limit" form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int    `json:"offset" form:"offset" binding:"omitempty,min=0"`
+}
+
+// SetRolesInput はロール変更の入力構造体です。player 以外の割り当てるロールをすべて指定します（空配列で解除）。
+type SetRolesInput struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// ListUsers searches the accounts, oldest first
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	var input ListUsersInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+	if input.Limit == 0 {
+		input.Limit = defaultAdminPageSize
+	}
+
+	rows, total, err := h.users.SearchUsers(c, db.SearchUsersParams{Query: input.Query, Limit: input.Limit, Offset: input.Offset})
+	if err != nil {
+		utils.GetLogger(c).Error("admin: failed to search users", "query", input.Query, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to search users", http.StatusInternalServerError))
+		return
+	}
+
+	users := make([]types.AdminUser, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, types.NewAdminUser(row.User, row.Handle))
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total, "limit": input.Limit, "offset": input.Offset})
+}
+
+// GetUser returns an account with its roles and its login lockout state
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	user, ok := h.pathUser(c)
+	if !ok {
+		return
+	}
+	h.respondUser(c, user.ID)
+}
+
+// SuspendUser blocks an account from logging in and logs it out everywhere
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	user, client, ok := h.managedUser(c)
+	if !ok {
+		return
+	}
+	suspended, err := h.accounts.Suspend(c, user.ID, client)
+	if err != nil {
+		logger.Error("admin: failed to suspend user", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to suspend user", http.StatusInternalServerError))
+		return
+	}
+	if suspended {
+		logger.Info("admin: user suspended", "user_id", user.ID, "actor_id", client.ActorID)
+	}
+	h.respondUser(c, user.ID)
+}
+
+// UnsuspendUser lifts the suspension of an account
+func (h *AdminHandler) UnsuspendUser(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	user, client, ok := h.managedUser(c)
+	if !ok {
+		return
+	}
+	unsuspended, err := h.accounts.Unsuspend(c, user.ID, client)
+	if err != nil {
+		logger.Error("admin: failed to unsuspend user", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to unsuspend user", http.StatusInternalServerError))
+		return
+	}
+	if unsuspended {
+		logger.Info("admin: user unsuspended", "user_id", user.ID, "actor_id", client.ActorID)
+	}
+	h.respondUser(c, user.ID)
+}
+
+// LogoutUser revokes every session of an account
+func (h *AdminHandler) LogoutUser(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	user, client, ok := h.managedUser(c)
+	if !ok {
+		return
+	}
+	if err := h.accounts.RevokeSessions(c, user.ID, client); err != nil {
+		logger.Error("admin: failed to revoke sessions", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log out user", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("admin: user logged out", "user_id", user.ID, "actor_id", client.ActorID)
+	c.JSON(http.StatusOK, gin.H{"message": "sessions_revoked"})
+}
+
+// SetUserRoles replaces the roles assigned to an account
+func (h *AdminHandler) SetUserRoles(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	var input SetRolesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	user, client, ok := h.managedUser(c)
+	if !ok {
+		return
+	}
+	roles, err := h.accounts.SetRoles(c, user.ID, input.Roles, client)
+	if errors.Is(err, account.ErrUnknownRole) {
+		c.Error(h.unknownRoleError(c))
+		return
+	}
+	if err != nil {
+		logger.Error("admin: failed to set roles", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to change roles", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("admin: roles changed", "user_id", user.ID, "roles", roles, "actor_id", client.ActorID)
+	h.respondUser(c, user.ID)
+}
+
+// ListRoles returns every role with its permissions
+func (h *AdminHandler) ListRoles(c *gin.Context) {
+	roles, err := h.authz.Roles(c)
+	if err != nil {
+		utils.GetLogger(c).Error("admin: failed to list roles", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to list roles", http.StatusInternalServerError))
+		return
+	}
+
+	out := make([]types.AdminRole, 0, len(roles))
+	for _, r := range roles {
+		out = append(out, types.NewAdminRole(r))
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": out})
+}
+
+// pathUser loads the account named by the :id path parameter. A malformed id is reported as not found.
+func (h *AdminHandler) pathUser(c *gin.Context) (db.User, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Error(apperrors.ErrNotFound)
+		return db.User{}, false
+	}
+	user, err := h.users.GetUserByID(c, id)
+	if err != nil {
+		appErr := apperrors.FormatError(apperrors.WrapDBError(err))
+		if !apperrors.IsNotFound(appErr) {
+			utils.GetLogger(c).Error("admin: user lookup failed", "user_id", id, "error", err.Error())
+		}
+		c.Error(appErr)
+		return db.User{}, false
+	}
+	return user, true
+}
+
+// managedUser loads the account of the :id path parameter for an operation by the authenticated staff member.
+// Staff cannot operate on their own account, and a moderator cannot operate on an admin.
+func (h *AdminHandler) managedUser(c *gin.Context) (db.User, account.Client, bool) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return db.User{}, account.Client{}, false
+	}
+	user, ok := h.pathUser(c)
+	if !ok {
+		return db.User{}, account.Client{}, false
+	}
+	if user.ID == principal.UserID {
+		logger.Warn("admin: operation on own account", "user_id", user.ID)
+		c.Error(apperrors.ErrPermissionDenied)
+		return db.User{}, account.Client{}, false
+	}
+
+	allowed, err := h.mayManage(c, principal.UserID, user.ID)
+	if err != nil {
+		logger.Error("admin: failed to check permissions", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to check permissions", http.StatusInternalServerError))
+		return db.User{}, account.Client{}, false
+	}
+	if !allowed {
+		logger.Warn("admin: operation on a more privileged account", "user_id", user.ID, "actor_id", principal.UserID)
+		c.Error(apperrors.ErrPermissionDenied)
+		return db.User{}, account.Client{}, false
+	}
+
+	return user, auditClient(c), true
+}
+
+// mayManage reports whether the actor may operate on the target account.
+// Accounts that may assign roles can only be managed by staff who may assign roles too.
+func (h *AdminHandler) mayManage(c *gin.Context, actorID, targetID uuid.UUID) (bool, error) {
+	targetIsAdmin, err := h.authz.HasPermission(c, targetID, rbac.PermRolesAssign)
+	if err != nil || !targetIsAdmin {
+		return err == nil, err
+	}
+	return h.authz.HasPermission(c, actorID, rbac.PermRolesAssign)
+}
+
+// respondUser returns the current state of the account with its handle, roles and login lockout state
+func (h *AdminHandler) respondUser(c *gin.Context, userID uuid.UUID) {
+	user, err := h.users.GetUserByID(c, userID)
+	if err != nil {
+		utils.GetLogger(c).Error("admin: user lookup failed", "user_id", userID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to get user", http.StatusInternalServerError))
+		return
+	}
+	var handle string
+	p, err := h.profiles.GetPlayerProfile(c, userID)
+	switch {
+	case err == nil:
+		handle = p.Handle
+	case !errors.Is(err, sql.ErrNoRows):
+		utils.GetLogger(c).Error("admin: profile lookup failed", "user_id", userID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to get user", http.StatusInternalServerError))
+		return
+	}
+	roles, err := h.authz.UserRoles(c, userID)
+	if err != nil {
+		utils.GetLogger(c).Error("admin: failed to list roles", "user_id", userID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to get user", http.StatusInternalServerError))
+		return
+	}
+	// IP単位のロックは複数のアカウントにまたがるため、アカウント単位のカウンターだけを返す
+	var lockoutState *types.AdminLockout
+	attempt, err := h.attempts.GetLoginAttempt(c, lockout.AccountKey(user.Email))
+	switch {
+	case err == nil:
+		lockoutState = types.NewAdminLockout(attempt, time.Now())
+	case !errors.Is(err, sql.ErrNoRows):
+		utils.GetLogger(c).Error("admin: failed to get login lockout", "user_id", userID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to get user", http.StatusInternalServerError))
+		return
+	}
+
+	c.JSON(http.StatusOK, types.AdminUserDetail{AdminUser: types.NewAdminUser(user, handle), Roles: roles, Lockout: lockoutState})
+}
+
+// unknownRoleError is the validation error for a role that cannot be assigned, listing those that can
+func (h *AdminHandler) unknownRoleError(c *gin.Context) error {
+	roles, err := h.authz.Roles(c)
+	if err != nil {
+		utils.GetLogger(c).Error("admin: failed to list roles", "error", err.Error())
+		return apperrors.Wrap(err, apperrors.ErrInternal, "Failed to change roles", http.StatusInternalServerError)
+	}
+	var assignable []string
+	for _, r := range roles {
+		if r.Name != rbac.RolePlayer {
+			assignable = append(assignable, r.Name)
+		}
+	}
+	return apperrors.ErrInvalidInput.WithDetails([]apperrors.FieldViolation{{Field: "roles", Rule: "oneof", Param: strings.Join(assignable, " ")}})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/my-deer/mydeer/internal/account"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/i18n"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/metrics"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/passcheck"
+	"github.com/my-deer/mydeer/internal/passhash"
+	"github.com/my-deer/mydeer/internal/rbac"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// AuthHandler serves signup, login and session endpoints.
+// Dependencies are injected so tests can use in-memory repositories.
+type AuthHandler struct {
+	cfg          *config.Config
+	users        db.UserRepository
+	sessions     db.SessionRepository
+	profiles     db.ProfileRepository
+	tx           db.TxRunner
+	keys         *auth.KeySet
+	passwords    *passhash.Hasher
+	policy       *passcheck.Checker
+	verification *EmailVerificationHandler
+	lockout      *lockout.Tracker
+	mfa          *mfa.Service
+	accounts     *account.Service
+	authz        *rbac.Authorizer
+	metrics      *metrics.Metrics
+}
+
+// NewAuthHandler creates an AuthHandler. Signup creates the user and the profile in one transaction,
+// policy screens the password on signup, verification sends
+// the confirmation email and tracker throttles repeated failed logins. Players with 2FA enabled
+// log in through mfaService, and logging in cancels a pending deletion through accounts.
+// authz provides the roles carried by the access token. metrics counts signups and logins; nil records nothing.
+func NewAuthHandler(cfg *config.Config, users db.UserRepository, sessions db.SessionRepository, profiles db.ProfileRepository, tx db.TxRunner, keys *auth.KeySet, passwords *passhash.Hasher, policy *passcheck.Checker, verification *EmailVerificationHandler, tracker *lockout.Tracker, mfaService *mfa.Service, accounts *account.Service, authz *rbac.Authorizer, m *metrics.Metrics) *AuthHandler {
+	return &AuthHandler{
+		cfg:          cfg,
+		users:        users,
+		sessions:     sessions,
+		profiles:     profiles,
+		tx:           tx,
+		keys:         keys,
+		passwords:    passwords,
+		policy:       policy,
+		verification: verification,
+		lockout:      tracker,
+		mfa:          mfaService,
+		accounts:     accounts,
+		authz:        authz,
+		metrics:      m,
+	}
+}
+
+// publicLink builds an absolute link to path on the public URL, used in emails
+func publicLink(cfg *config.Config, path string, query url.Values) string {
+	return strings.TrimRight(cfg.Server.PublicURL, "/") + path + "?" + query.Encode()
+}
+
+// mailLocale returns the language of an email: the player's preference, else the request locale
+func mailLocale(c *gin.Context, preferred string) i18n.Locale {
+	if loc, ok := i18n.Parse(preferred); ok {
+		return loc
+	}
+	return middleware.GetLocale(c)
+}
+
+// setRetryAfter tells the client how many seconds to wait before retrying
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// checkReauthLockout rejects the re-authentication of an authenticated player with ErrLoginLocked
+// while the account or the client IP is locked out, so a stolen session cannot be used to guess the
+// password or the second factor. On rejection the error is set on the context and ok is false.
+func checkReauthLockout(c *gin.Context, tracker *lockout.Tracker, user db.User, op string) bool {
+	logger := utils.GetLogger(c)
+
+	wait, err := tracker.Check(c, user.Email, c.ClientIP())
+	if err != nil {
+		logger.Error(op+": failed to check lockout", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to verify credentials", http.StatusInternalServerError))
+		return false
+	}
+	if wait > 0 {
+		logger.Warn(op+": locked out", "user_id", user.ID, "ip", c.ClientIP(), "retry_after", wait)
+		setRetryAfter(c, wait)
+		c.Error(apperrors.ErrLoginLocked)
+		return false
+	}
+	return true
+}
+
+// recordReauthFailure counts a wrong password or code given to re-authenticate like a failed login
+func recordReauthFailure(c *gin.Context, tracker *lockout.Tracker, user db.User, op string) {
+	if err := tracker.RecordFailure(c, user.Email, c.ClientIP()); err != nil {
+		utils.GetLogger(c).Error(op+": failed to record failure", "user_id", user.ID, "error", err.Error())
+	}
+}
+
+// checkNewPassword screens a new password with policy. Breached and easily guessed passwords
+// are rejected as a validation error of field; userInputs (email, name) count as easy to guess.
+func checkNewPassword(policy *passcheck.Checker, field, password string, userInputs ...string) *apperrors.AppError {
+	result, err := policy.Check(password, userInputs...)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.ErrInternal, "Failed to check password", http.StatusInternalServerError)
+	}
+	if result.OK() {
+		return nil
+	}
+
+	violation := apperrors.FieldViolation{Field: field, Rule: string(result.Reason)}
+	if result.Reason == passcheck.ReasonWeak {
+		// 弱い箇所の種類（dictionary, sequence など）をクライアントのヒントに返す
+		violation.Param = result.Strength.Pattern
+	}
+	return apperrors.ErrInvalidInput.WithDetails([]apperrors.FieldViolation{violation})
+}
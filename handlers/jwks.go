@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS は、アクセストークン検証用の公開鍵をJWKS形式で返します。
+// ゲームAPIサーバーなど別サービスが秘密鍵を持たずにトークンを検証するために使用します。
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
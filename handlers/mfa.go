@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/metrics"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/passhash"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// MFAHandler serves the two-factor authentication settings of the authenticated player
+type MFAHandler struct {
+	users     db.UserRepository
+	passwords *passhash.Hasher
+	mfa       *mfa.Service
+	lockout   *lockout.Tracker
+}
+
+// NewMFAHandler creates an MFAHandler. tracker throttles wrong passwords and codes given to
+// re-authenticate, sharing the failure counters of login.
+func NewMFAHandler(users db.UserRepository, passwords *passhash.Hasher, service *mfa.Service, tracker *lockout.Tracker) *MFAHandler {
+	return &MFAHandler{
+		users:     users,
+		passwords: passwords,
+		mfa:       service,
+		lockout:   tracker,
+	}
+}
+
+// LoginMFAInput はログイン2段階目（認証コード入力）の入力構造体です。
+type LoginMFAInput struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code は認証アプリの6桁のコード、またはリカバリーコード
+	Code string `json:"code" binding:"required"`
+}
+
+// ConfirmMFAInput は2段階認証の設定確認の入力構造体です。
+type ConfirmMFAInput struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// ReauthMFAInput は2段階認証の無効化・リカバリーコード再発行の入力構造体です。
+// パスワードと認証コード（またはリカバリーコード）で本人確認します。
+type ReauthMFAInput struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// issueMFAChallenge returns the token the client exchanges, together with a code, at /login/mfa
+func (h *AuthHandler) issueMFAChallenge(user db.User) (string, error) {
+	return h.keys.Sign(auth.NewMFAChallengeClaims(user.ID, time.Now(), time.Duration(h.cfg.Auth.MFA.ChallengeTTL)))
+}
+
+// LoginMFA is the second login step of players with 2FA enabled: it checks the challenge from /login
+// and the TOTP or recovery code, then starts the session like Login does.
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	var input LoginMFAInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	claims := &auth.MFAChallengeClaims{}
+	if _, err := h.keys.Parse(input.MFAToken, auth.AudienceMFAChallenge, claims); err != nil {
+		logger.Warn("login-mfa: invalid challenge", "error", err.Error())
+		h.metrics.LoginFailed(metrics.MethodMFA, metrics.ReasonInvalidMFAToken)
+		c.Error(apperrors.ErrInvalidMFAToken)
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		logger.Warn("login-mfa: invalid challenge", "error", err.Error())
+		h.metrics.LoginFailed(metrics.MethodMFA, metrics.ReasonInvalidMFAToken)
+		c.Error(apperrors.ErrInvalidMFAToken)
+		return
+	}
+
+	user, err := h.users.GetUserByID(c, userID)
+	if err != nil {
+		logger.Warn("login-mfa: user lookup failed", "user_id", userID, "error", err)
+		h.metrics.LoginFailed(metrics.MethodMFA, metrics.ReasonInvalidMFAToken)
+		c.Error(apperrors.ErrInvalidMFAToken)
+		return
+	}
+	// チャレンジの発行後に利用停止された場合
+	if user.SuspendedAt.Valid {
+		logger.Warn("login-mfa: account suspended", "user_id", user.ID)
+		h.metrics.LoginFailed(metrics.MethodMFA, metrics.ReasonSuspended)
+		c.Error(apperrors.ErrAccountSuspended)
+		return
+	}
+
+	// コードの総当たりもパスワードと同じロックアウトで制限する
+	wait, err := h.lockout.Check(c, user.Email, c.ClientIP())
+	if err != nil {
+		logger.Error("login-mfa: failed to check lockout", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
+		return
+	}
+	if wait > 0 {
+		logger.Warn("login-mfa: locked out", "user_id", user.ID, "ip", c.ClientIP(), "retry_after", wait)
+		h.metrics.LoginFailed(metrics.MethodMFA, metrics.ReasonLocked)
+		setRetryAfter(c, wait)
+		c.Error(apperrors.ErrLoginLocked)
+		return
+	}
+
+	if err = h.mfa.Verify(c, user.ID, input.Code); err != nil {
+		if errors.Is(err, mfa.ErrInvalidCode) || errors.Is(err, mfa.ErrNotEnrolled) {
+			logger.Warn("login-mfa: invalid code", "user_id", user.ID)
+			h.recordLoginFailure(c, user.Email, metrics.MethodMFA, metrics.ReasonInvalidMFACode)
+			c.Error(apperrors.ErrInvalidMFACode)
+			return
+		}
+		logger.Error("login-mfa: failed to verify code", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
+		return
+	}
+
+	if err = h.lockout.RecordSuccess(c, user.Email); err != nil {
+		logger.Error("login-mfa: failed to reset lockout", "user_id", user.ID, "error", err.Error())
+	}
+
+	if err = h.startSession(c, user); err != nil {
+		logger.Error("login-mfa: failed to start session", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		return
+	}
+
+	h.metrics.LoginSucceeded(metrics.MethodMFA)
+	logger.Info("login-mfa: success", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "login_success"})
+}
+
+// Status returns whether 2FA is enabled and how many recovery codes are left
+func (h *MFAHandler) Status(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	status, err := h.mfa.Status(c, principal.UserID)
+	if err != nil {
+		utils.GetLogger(c).Error("mfa: failed to get status", "user_id", principal.UserID, "error", err.Error())
+		c.Error(mfaError(err))
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetupTOTP starts the enrollment and returns the secret and otpauth URI for the authenticator app.
+// Calling it again before confirming replaces the pending secret.
+func (h *MFAHandler) SetupTOTP(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	user, err := h.users.GetUserByID(c, principal.UserID)
+	if err != nil {
+		logger.Error("mfa-setup: user lookup failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to set up two-factor authentication", http.StatusInternalServerError))
+		return
+	}
+
+	enrollment, err := h.mfa.BeginEnrollment(c, user.ID, user.Email)
+	if err != nil {
+		logger.Warn("mfa-setup: failed", "user_id", user.ID, "error", err.Error())
+		c.Error(mfaError(err))
+		return
+	}
+
+	logger.Info("mfa-setup: enrollment started", "user_id", user.ID)
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP enables 2FA once the player proves the authenticator app works,
+// and returns the recovery codes. They are shown only once.
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	var input ConfirmMFAInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	codes, err := h.mfa.ConfirmEnrollment(c, principal.UserID, input.Code)
+	if err != nil {
+		logger.Warn("mfa-confirm: failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(mfaError(err))
+		return
+	}
+
+	logger.Info("mfa-confirm: two-factor authentication enabled", "user_id", principal.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "mfa_enabled", "recovery_codes": codes})
+}
+
+// Disable turns 2FA off after the player re-authenticates with the password and a code
+func (h *MFAHandler) Disable(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := h.reauthenticate(c)
+	if !ok {
+		return
+	}
+
+	if err := h.mfa.Disable(c, principal.UserID); err != nil {
+		logger.Error("mfa-disable: failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(mfaError(err))
+		return
+	}
+
+	logger.Info("mfa-disable: two-factor authentication disabled", "user_id", principal.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "mfa_disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after the player re-authenticates
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := h.reauthenticate(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(c, principal.UserID)
+	if err != nil {
+		logger.Error("mfa-recovery-codes: failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(mfaError(err))
+		return
+	}
+
+	logger.Info("mfa-recovery-codes: regenerated", "user_id", principal.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "recovery_codes_regenerated", "recovery_codes": codes})
+}
+
+// reauthenticate checks the password and a second factor of the authenticated player.
+// On failure the error is set on the context and ok is false.
+func (h *MFAHandler) reauthenticate(c *gin.Context) (auth.Principal, bool) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return auth.Principal{}, false
+	}
+
+	var input ReauthMFAInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return auth.Principal{}, false
+	}
+
+	user, err := h.users.GetUserByID(c, principal.UserID)
+	if err != nil {
+		logger.Error("mfa: user lookup failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to verify credentials", http.StatusInternalServerError))
+		return auth.Principal{}, false
+	}
+	if !checkReauthLockout(c, h.lockout, user, "mfa") {
+		return auth.Principal{}, false
+	}
+	if ok, _ := h.passwords.Verify(user.Password, input.Password); !ok {
+		logger.Warn("mfa: wrong password", "user_id", user.ID)
+		recordReauthFailure(c, h.lockout, user, "mfa")
+		c.Error(apperrors.ErrWrongPassword)
+		return auth.Principal{}, false
+	}
+	if err = h.mfa.Verify(c, user.ID, input.Code); err != nil {
+		logger.Warn("mfa: second factor rejected", "user_id", user.ID, "error", err.Error())
+		if errors.Is(err, mfa.ErrInvalidCode) {
+			recordReauthFailure(c, h.lockout, user, "mfa")
+		}
+		c.Error(mfaError(err))
+		return auth.Principal{}, false
+	}
+
+	if err = h.lockout.RecordSuccess(c, user.Email); err != nil {
+		logger.Error("mfa: failed to reset lockout", "user_id", user.ID, "error", err.Error())
+	}
+	return principal, true
+}
+
+// mfaError maps the errors of the mfa package to API errors
+func mfaError(err error) *apperrors.AppError {
+	switch {
+	case errors.Is(err, mfa.ErrAlreadyEnabled):
+		return apperrors.ErrMFAEnabled
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		return apperrors.ErrMFANotEnabled
+	case errors.Is(err, mfa.ErrInvalidCode):
+		return apperrors.ErrInvalidMFACode
+	default:
+		return apperrors.Wrap(err, apperrors.ErrInternal, "Two-factor authentication failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/metrics"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/oidc"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// oidcFlowCookiePath limits the flow cookie to the login and callback endpoints
+const oidcFlowCookiePath = "/auth/oidc/"
+
+// OIDCHandler serves login through OpenID Connect providers.
+// Sessions are started by the AuthHandler, so 2FA applies to SSO logins as well.
+type OIDCHandler struct {
+	cfg        *config.Config
+	users      db.UserRepository
+	identities db.IdentityRepository
+	tx         db.TxRunner
+	providers  *oidc.Registry
+	auth       *AuthHandler
+}
+
+// NewOIDCHandler creates an OIDCHandler
+func NewOIDCHandler(cfg *config.Config, users db.UserRepository, identities db.IdentityRepository, tx db.TxRunner, providers *oidc.Registry, authHandler *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{
+		cfg:        cfg,
+		users:      users,
+		identities: identities,
+		tx:         tx,
+		providers:  providers,
+		auth:       authHandler,
+	}
+}
+
+// OIDCCallbackURL returns the redirect URL to register at the provider named name
+func OIDCCallbackURL(cfg *config.Config, name string) string {
+	return strings.TrimRight(cfg.Server.PublicURL, "/") + oidcFlowCookiePath + name + "/callback"
+}
+
+// Login redirects the browser to the provider. state, nonce and the PKCE verifier
+// are kept in a signed cookie until the callback.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	provider, ok := h.providers.Get(c.Param("provider"))
+	if !ok {
+		c.Error(apperrors.ErrNotFound)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.NewRandomValue()
+		if err != nil {
+			c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to start single sign-on", http.StatusInternalServerError))
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := provider.AuthCodeURL(c, state, nonce, verifier)
+	if err != nil {
+		logger.Error("oidc-login: provider unavailable", "provider", provider.Name(), "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrSSOUnavailable, apperrors.ErrSSOProviderDown.Message, http.StatusBadGateway))
+		return
+	}
+
+	flow, err := h.auth.keys.Sign(auth.NewOIDCFlowClaims(provider.Name(), state, nonce, verifier, time.Now(), auth.OIDCFlowTTL))
+	if err != nil {
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to start single sign-on", http.StatusInternalServerError))
+		return
+	}
+	h.setFlowCookie(c, flow, auth.OIDCFlowTTL)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the login at the provider. The provider account is matched by its linked identity,
+// else linked to the user with the same (provider verified) email, else a new user is created.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	provider, ok := h.providers.Get(c.Param("provider"))
+	if !ok {
+		c.Error(apperrors.ErrNotFound)
+		return
+	}
+
+	flow, ok := h.readFlow(c, provider.Name())
+	// stateの確認が終わったらフローのCookieは不要（同じ応答の再利用を防ぐ）
+	h.setFlowCookie(c, "", 0)
+	if !ok {
+		logger.Warn("oidc-callback: state mismatch", "provider", provider.Name())
+		c.Error(apperrors.ErrInvalidSSOState)
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		logger.Warn("oidc-callback: provider returned an error", "provider", provider.Name(), "error", providerErr)
+		c.Error(apperrors.ErrSSOFailed)
+		return
+	}
+
+	identity, err := provider.Exchange(c, c.Query("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrProviderUnavailable) {
+			logger.Error("oidc-callback: provider unavailable", "provider", provider.Name(), "error", err.Error())
+			c.Error(apperrors.Wrap(err, apperrors.ErrSSOUnavailable, apperrors.ErrSSOProviderDown.Message, http.StatusBadGateway))
+			return
+		}
+		logger.Warn("oidc-callback: code exchange failed", "provider", provider.Name(), "error", err.Error())
+		c.Error(apperrors.ErrSSOFailed)
+		return
+	}
+
+	userID, created, err := h.resolveUser(c, provider.Name(), identity)
+	if err != nil {
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			logger.Warn("oidc-callback: login refused", "provider", provider.Name(), "error", err.Error())
+			c.Error(appErr)
+			return
+		}
+		logger.Error("oidc-callback: failed to resolve user", "provider", provider.Name(), "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
+		return
+	}
+	if created {
+		h.auth.metrics.Signup(metrics.MethodOIDC)
+	}
+
+	user, err := h.users.GetUserByID(c, userID)
+	if err != nil {
+		logger.Error("oidc-callback: user lookup failed", "user_id", userID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
+		return
+	}
+
+	h.finishLogin(c, user)
+}
+
+// Identities lists the provider accounts linked to the authenticated player
+func (h *OIDCHandler) Identities(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	identities, err := h.identities.ListUserIdentities(c, principal.UserID)
+	if err != nil {
+		utils.GetLogger(c).Error("oidc: failed to list identities", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to list linked accounts", http.StatusInternalServerError))
+		return
+	}
+	if identities == nil {
+		identities = []db.UserIdentity{}
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// resolveUser returns the user of the provider account, linking or creating it on first login.
+// created reports whether a new account was created.
+func (h *OIDCHandler) resolveUser(c *gin.Context, provider string, identity oidc.Identity) (userID uuid.UUID, created bool, err error) {
+	logger := utils.GetLogger(c)
+
+	err = h.tx.RunInTx(c, func(ctx context.Context) error {
+		created = false
+		linked, err := h.identities.GetUserIdentity(ctx, provider, identity.Subject)
+		if err == nil {
+			userID = linked.UserID
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// 確認済みでないメールアドレスで既存アカウントに紐付けると乗っ取りに使えるため拒否する
+		if identity.Email == "" || !identity.EmailVerified {
+			return apperrors.ErrSSOEmailUnverified
+		}
+
+		existing, err := h.users.GetUserByEmail(ctx, identity.Email)
+		switch {
+		case err == nil:
+			userID = existing.ID
+			// メールアドレス未確認のアカウントは、第三者が先に同じアドレスで登録したものかもしれない。
+			// 登録時のパスワード・セッション・2段階認証を無効にし、プロバイダーで確認できた本人だけが使えるようにする
+			if !existing.EmailVerifiedAt.Valid {
+				if err := h.resetUnverifiedAccount(ctx, userID); err != nil {
+					return err
+				}
+				logger.Warn("oidc-callback: unverified account reset before linking", "provider", provider, "user_id", userID)
+			}
+			logger.Info("oidc-callback: linking existing account", "provider", provider, "user_id", userID)
+		case errors.Is(err, sql.ErrNoRows):
+			// パスワードは空（どのパスワードとも一致しない）。必要ならパスワード再設定で設定できる
+			row, err := h.users.CreateUser(ctx, db.CreateUserParams{
+				Email: identity.Email,
+				Name:  ssoDisplayName(identity),
+			})
+			if err != nil {
+				return err
+			}
+			userID, created = row.ID, true
+			if err := createProfile(ctx, h.auth.profiles, userID, "", row.Name); err != nil {
+				return err
+			}
+			logger.Info("oidc-callback: user created", "provider", provider, "user_id", userID)
+		default:
+			return err
+		}
+
+		if err := h.users.MarkEmailVerified(ctx, userID); err != nil {
+			return err
+		}
+		_, err = h.identities.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+			UserID:   userID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		})
+		return err
+	})
+	return userID, created, err
+}
+
+// resetUnverifiedAccount clears the password, sessions and 2FA of an account whose email was never
+// verified, so whoever registered it cannot log in once it is linked to the provider account.
+// The owner can set a password again through the password reset.
+func (h *OIDCHandler) resetUnverifiedAccount(ctx context.Context, userID uuid.UUID) error {
+	if err := h.users.UpdatePassword(ctx, userID, ""); err != nil {
+		return err
+	}
+	if err := h.auth.sessions.RevokeUserSessions(ctx, userID, uuid.Nil); err != nil {
+		return err
+	}
+	if err := h.auth.mfa.Disable(ctx, userID); err != nil && !errors.Is(err, mfa.ErrNotEnrolled) {
+		return err
+	}
+	return nil
+}
+
+// finishLogin starts the session, or returns an MFA challenge when the player enabled 2FA
+func (h *OIDCHandler) finishLogin(c *gin.Context, user db.User) {
+	logger := utils.GetLogger(c)
+
+	if user.SuspendedAt.Valid {
+		logger.Warn("oidc-callback: account suspended", "user_id", user.ID)
+		h.auth.metrics.LoginFailed(metrics.MethodOIDC, metrics.ReasonSuspended)
+		c.Error(apperrors.ErrAccountSuspended)
+		return
+	}
+
+	mfaEnabled, err := h.auth.mfa.Enabled(c, user.ID)
+	if err != nil {
+		logger.Error("oidc-callback: failed to check two-factor authentication", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
+		return
+	}
+	if mfaEnabled {
+		challenge, err := h.auth.issueMFAChallenge(user)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+			return
+		}
+		logger.Info("oidc-callback: mfa required", "user_id", user.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "mfa_required", "mfa_token": challenge})
+		return
+	}
+
+	if err = h.auth.startSession(c, user); err != nil {
+		logger.Error("oidc-callback: failed to start session", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		return
+	}
+
+	h.auth.metrics.LoginSucceeded(metrics.MethodOIDC)
+	logger.Info("oidc-callback: login success", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "login_success"})
+}
+
+// readFlow returns the flow started for provider if the callback state matches it
+func (h *OIDCHandler) readFlow(c *gin.Context, provider string) (*auth.OIDCFlowClaims, bool) {
+	raw, err := c.Cookie(auth.OIDCFlowCookie)
+	if err != nil || raw == "" {
+		return nil, false
+	}
+
+	flow := &auth.OIDCFlowClaims{}
+	if _, err := h.auth.keys.Parse(raw, auth.AudienceOIDCFlow, flow); err != nil {
+		return nil, false
+	}
+	state := c.Query("state")
+	if flow.Purpose != auth.PurposeOIDCFlow || flow.Provider != provider || state == "" ||
+		subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+		return nil, false
+	}
+	return flow, true
+}
+
+// setFlowCookie sets (or with ttl 0 clears) the flow cookie. It is always SameSite=Lax:
+// the provider redirects back cross-site, which Strict cookies would not survive.
+func (h *OIDCHandler) setFlowCookie(c *gin.Context, value string, ttl time.Duration) {
+	maxAge := -1
+	if ttl > 0 {
+		maxAge = int(ttl.Seconds())
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.OIDCFlowCookie, value, maxAge, oidcFlowCookiePath, h.cfg.Cookie.Domain, h.cfg.Cookie.Secure, true)
+}
+
+// ssoDisplayName is the player name of an account created by SSO
+func ssoDisplayName(identity oidc.Identity) string {
+	if identity.Name != "" {
+		return identity.Name
+	}
+	local, _, _ := strings.Cut(identity.Email, "@")
+	return local
+}
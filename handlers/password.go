@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/mail"
+	"github.com/my-deer/mydeer/internal/passcheck"
+	"github.com/my-deer/mydeer/internal/passhash"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// PasswordHandler serves the forgotten password and change password endpoints
+type PasswordHandler struct {
+	cfg       *config.Config
+	users     db.UserRepository
+	sessions  db.SessionRepository
+	tokens    db.UserTokenRepository
+	tx        db.TxRunner
+	mailer    mail.Mailer
+	passwords *passhash.Hasher
+	policy    *passcheck.Checker
+	lockout   *lockout.Tracker
+}
+
+// NewPasswordHandler creates a PasswordHandler. New passwords are screened by policy, and tracker
+// throttles wrong current passwords given to change the password.
+func NewPasswordHandler(cfg *config.Config, users db.UserRepository, sessions db.SessionRepository, tokens db.UserTokenRepository, tx db.TxRunner, mailer mail.Mailer, passwords *passhash.Hasher, policy *passcheck.Checker, tracker *lockout.Tracker) *PasswordHandler {
+	return &PasswordHandler{
+		cfg:       cfg,
+		users:     users,
+		sessions:  sessions,
+		tokens:    tokens,
+		tx:        tx,
+		mailer:    mailer,
+		passwords: passwords,
+		policy:    policy,
+		lockout:   tracker,
+	}
+}
+
+// ForgotPasswordInput はパスワード再設定メール送信の入力構造体です。
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordInput はパスワード再設定の入力構造体です。
+type ResetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=12,max=256,complexpassword"`
+}
+
+// ChangePasswordInput はパスワード変更の入力構造体です。
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=12,max=256,complexpassword,nefield=CurrentPassword"`
+}
+
+// ForgotPassword emails a single-use reset link to the address.
+// The response is the same whether the account exists or not, and repeated requests
+// within the request interval are ignored without telling the caller.
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	var input ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	if err := h.sendResetLink(c, input.Email); err != nil {
+		// 送信失敗もアカウントの存在を漏らさないよう同じ応答を返す
+		logger.Error("password-forgot: failed to send reset link", "email", input.Email, "error", err.Error())
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "password_reset_email_sent"})
+}
+
+func (h *PasswordHandler) sendResetLink(c *gin.Context, email string) error {
+	logger := utils.GetLogger(c)
+
+	user, err := h.users.GetUserByEmail(c, email)
+	if err != nil {
+		logger.Info("password-forgot: unknown email", "email", email)
+		return nil
+	}
+
+	interval := time.Duration(h.cfg.Auth.PasswordReset.RequestInterval)
+	if latest, err := h.tokens.GetLatestUserToken(c, user.ID, db.TokenPurposePasswordReset); err == nil && time.Since(latest.CreatedAt.Time) < interval {
+		logger.Warn("password-forgot: throttled", "email", email)
+		return nil
+	}
+
+	ttl := time.Duration(h.cfg.Auth.PasswordReset.TokenTTL)
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if _, err = h.tokens.CreateUserToken(c, db.CreateUserTokenParams{
+		UserID:    user.ID,
+		Purpose:   db.TokenPurposePasswordReset,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return err
+	}
+
+	link := publicLink(h.cfg, "/password/reset", url.Values{"token": {token}})
+	if err = h.mailer.Send(c, mail.PasswordResetMessage(mailLocale(c, user.Locale), user.Email, link, ttl)); err != nil {
+		return err
+	}
+
+	logger.Info("password-forgot: reset link sent", "user_id", user.ID)
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token and logs out every session of the user
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	var input ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	// トークンを消費する前に審査するので、拒否されても同じリンクで再設定できる
+	if appErr := checkNewPassword(h.policy, "password", input.Password); appErr != nil {
+		logger.Warn("password-reset: password rejected", "error", appErr.Error())
+		c.Error(appErr)
+		return
+	}
+
+	passwordHash, err := h.passwords.Hash(input.Password)
+	if err != nil {
+		logger.Error("password-reset: failed to hash password", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to reset password", http.StatusInternalServerError))
+		return
+	}
+
+	var userID uuid.UUID
+	err = h.tx.RunInTx(c, func(ctx context.Context) error {
+		token, err := h.tokens.ConsumeUserToken(ctx, db.TokenPurposePasswordReset, auth.HashOpaqueToken(input.Token))
+		if err != nil {
+			return err
+		}
+		userID = token.UserID
+
+		if err := h.users.UpdatePassword(ctx, userID, passwordHash); err != nil {
+			return err
+		}
+		// 他のリセットリンクも無効化し、全セッションをログアウトさせる
+		if err := h.tokens.InvalidateUserTokens(ctx, userID, db.TokenPurposePasswordReset); err != nil {
+			return err
+		}
+		if err := h.sessions.RevokeUserSessions(ctx, userID, uuid.Nil); err != nil {
+			return err
+		}
+		// リセットリンクを受け取れた = メールアドレスの所有を確認できた
+		return h.users.MarkEmailVerified(ctx, userID)
+	})
+	if errors.Is(err, db.ErrUserTokenInvalid) {
+		logger.Warn("password-reset: invalid or used token")
+		c.Error(apperrors.ErrInvalidResetToken)
+		return
+	}
+	if err != nil {
+		logger.Error("password-reset: failed to reset password", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to reset password", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("password-reset: password reset", "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "password_reset"})
+}
+
+// ChangePassword replaces the password of the authenticated player after checking the current one.
+// Every other session of the player is revoked; the current session stays logged in.
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	var input ChangePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	user, err := h.users.GetUserByID(c, principal.UserID)
+	if err != nil {
+		logger.Error("password-change: user lookup failed", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to change password", http.StatusInternalServerError))
+		return
+	}
+	if !checkReauthLockout(c, h.lockout, user, "password-change") {
+		return
+	}
+	if ok, _ := h.passwords.Verify(user.Password, input.CurrentPassword); !ok {
+		logger.Warn("password-change: wrong current password", "user_id", user.ID)
+		recordReauthFailure(c, h.lockout, user, "password-change")
+		c.Error(apperrors.ErrWrongPassword)
+		return
+	}
+	if err = h.lockout.RecordSuccess(c, user.Email); err != nil {
+		logger.Error("password-change: failed to reset lockout", "user_id", user.ID, "error", err.Error())
+	}
+
+	if appErr := checkNewPassword(h.policy, "new_password", input.NewPassword, user.Email, user.Name); appErr != nil {
+		logger.Warn("password-change: password rejected", "user_id", user.ID, "error", appErr.Error())
+		c.Error(appErr)
+		return
+	}
+
+	passwordHash, err := h.passwords.Hash(input.NewPassword)
+	if err != nil {
+		logger.Error("password-change: failed to hash password", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to change password", http.StatusInternalServerError))
+		return
+	}
+
+	err = h.tx.RunInTx(c, func(ctx context.Context) error {
+		if err := h.users.UpdatePassword(ctx, user.ID, passwordHash); err != nil {
+			return err
+		}
+		if err := h.tokens.InvalidateUserTokens(ctx, user.ID, db.TokenPurposePasswordReset); err != nil {
+			return err
+		}
+		return h.sessions.RevokeUserSessions(ctx, user.ID, principal.SessionID)
+	})
+	if err != nil {
+		logger.Error("password-change: failed to change password", "user_id", user.ID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to change password", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("password-change: password changed", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "password_changed"})
+}
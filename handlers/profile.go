@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/profile"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/types"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// maxDisplayNameLength bounds display names, which default to the name given at signup
+const maxDisplayNameLength = 50
+
+// ProfileHandler serves the public player profiles and the profile of the authenticated player
+type ProfileHandler struct {
+	users    db.UserRepository
+	profiles db.ProfileRepository
+}
+
+// NewProfileHandler creates a ProfileHandler
+func NewProfileHandler(users db.UserRepository, profiles db.ProfileRepository) *ProfileHandler {
+	return &ProfileHandler{
+		users:    users,
+		profiles: profiles,
+	}
+}
+
+// UpdateMeInput はプロフィール更新の入力構造体です。省略したフィールドは変更しません。
+// bio と avatar_ref は空文字で削除できます。
+type UpdateMeInput struct {
+	Handle      *string `json:"handle" binding:"omitempty,handle"`
+	DisplayName *string `json:"display_name" binding:"omitempty,min=1,max=50"`
+	Bio         *string `json:"bio" binding:"omitempty,max=300"`
+	AvatarRef   *string `json:"avatar_ref" binding:"omitempty,url,max=512"`
+}
+
+// validateHandle checks the characters and length of a handle; reservation is checked by the handlers
+func validateHandle(fl validator.FieldLevel) bool {
+	return profile.ValidHandle(fl.Field().String())
+}
+
+// reservedHandleError rejects a handle that players may not choose
+func reservedHandleError(handle string) *apperrors.AppError {
+	if !profile.Reserved(handle) {
+		return nil
+	}
+	return apperrors.ErrInvalidInput.WithDetails([]apperrors.FieldViolation{{Field: "handle", Rule: "reservedhandle"}})
+}
+
+// GetPlayer returns the public profile of the player with the handle.
+// Players whose account is scheduled for deletion or suspended are not shown.
+func (h *ProfileHandler) GetPlayer(c *gin.Context) {
+	p, err := h.profiles.GetPlayerProfileByHandle(c, c.Param("handle"))
+	if err == nil {
+		var user db.User
+		if user, err = h.users.GetUserByID(c, p.UserID); err == nil && (user.DeleteAfter.Valid || user.SuspendedAt.Valid) {
+			err = errors.Wrapf(sql.ErrNoRows, "player is leaving or suspended: %s", p.Handle)
+		}
+	}
+	if err != nil {
+		appErr := apperrors.FormatError(apperrors.WrapDBError(err))
+		if !apperrors.IsNotFound(appErr) {
+			utils.GetLogger(c).Error("players: failed to get profile", "handle", c.Param("handle"), "error", err.Error())
+		}
+		c.Error(appErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewPlayer(p))
+}
+
+// GetMe returns the profile and account details of the authenticated player
+func (h *ProfileHandler) GetMe(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	user, p, err := h.loadMe(c, principal.UserID)
+	if err != nil {
+		utils.GetLogger(c).Error("me: failed to load profile", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to load profile", http.StatusInternalServerError))
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewMe(user, p))
+}
+
+// UpdateMe changes the profile of the authenticated player
+func (h *ProfileHandler) UpdateMe(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+
+	var input UpdateMeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+	if input.Handle != nil {
+		if appErr := reservedHandleError(*input.Handle); appErr != nil {
+			// 自動で付けられた予約済みの handle（player_…）をそのまま送り返すのは変更ではないので許可する
+			current, err := h.profiles.GetPlayerProfile(c, principal.UserID)
+			if err != nil {
+				logger.Error("me: failed to load profile", "user_id", principal.UserID, "error", err.Error())
+				c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to update profile", http.StatusInternalServerError))
+				return
+			}
+			if !strings.EqualFold(current.Handle, *input.Handle) {
+				c.Error(appErr)
+				return
+			}
+		}
+	}
+
+	_, err := h.profiles.UpdatePlayerProfile(c, principal.UserID, db.UpdatePlayerProfileParams{
+		Handle:      input.Handle,
+		DisplayName: input.DisplayName,
+		Bio:         input.Bio,
+		AvatarRef:   input.AvatarRef,
+	})
+	if err != nil {
+		appErr := apperrors.FormatError(apperrors.WrapDBError(err))
+		if apperrors.IsDuplicate(appErr) {
+			logger.Warn("me: handle taken", "user_id", principal.UserID, "handle", *input.Handle)
+		} else {
+			logger.Error("me: failed to update profile", "user_id", principal.UserID, "error", err.Error())
+		}
+		c.Error(appErr)
+		return
+	}
+
+	user, p, err := h.loadMe(c, principal.UserID)
+	if err != nil {
+		logger.Error("me: failed to load profile", "user_id", principal.UserID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to load profile", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("me: profile updated", "user_id", principal.UserID)
+	c.JSON(http.StatusOK, types.NewMe(user, p))
+}
+
+func (h *ProfileHandler) loadMe(ctx context.Context, userID uuid.UUID) (db.User, db.PlayerProfile, error) {
+	user, err := h.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return db.User{}, db.PlayerProfile{}, err
+	}
+	p, err := h.profiles.GetPlayerProfile(ctx, userID)
+	if err != nil {
+		return db.User{}, db.PlayerProfile{}, err
+	}
+	return user, p, nil
+}
+
+// createProfile creates the profile of a new user. An empty handle is replaced by a generated one
+// and the display name is cut to the allowed length.
+func createProfile(ctx context.Context, profiles db.ProfileRepository, userID uuid.UUID, handle, displayName string) error {
+	if handle == "" {
+		generated, err := profile.GenerateHandle()
+		if err != nil {
+			return err
+		}
+		handle = generated
+	}
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		displayName = string([]rune(displayName)[:maxDisplayNameLength])
+	}
+
+	_, err := profiles.CreatePlayerProfile(ctx, db.CreatePlayerProfileParams{
+		UserID:      userID,
+		Handle:      handle,
+		DisplayName: displayName,
+	})
+	return err
+}
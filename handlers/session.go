@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// RefreshInput はトークン更新用の入力構造体です。Cookieが無い場合のみ使用します。
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken rotates the refresh token and issues a new access token.
+// A refresh token that was already rotated is treated as stolen and revokes the whole session family.
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	refreshToken := h.readRefreshToken(c)
+	if refreshToken == "" {
+		c.Error(apperrors.ErrInvalidRefresh)
+		return
+	}
+
+	session, err := h.sessions.GetSessionByTokenHash(c, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		logger.Warn("refresh: session lookup failed", "error", err)
+		c.Error(apperrors.ErrInvalidRefresh)
+		return
+	}
+
+	if session.RevokedAt.Valid {
+		logger.Warn("refresh: session revoked", "session_id", session.FamilyID)
+		c.Error(apperrors.ErrSessionRevoked)
+		return
+	}
+
+	// 既にローテーション済みのトークンが再利用された場合はセッションごと失効させる
+	if session.RotatedAt.Valid {
+		h.revokeReusedSession(c, session.FamilyID)
+		return
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		logger.Warn("refresh: refresh token expired", "session_id", session.FamilyID)
+		c.Error(apperrors.ErrInvalidRefresh)
+		return
+	}
+
+	user, err := h.users.GetUserByID(c, session.UserID)
+	if err != nil {
+		logger.Warn("refresh: user lookup failed", "user_id", session.UserID, "error", err)
+		c.Error(apperrors.ErrInvalidRefresh)
+		return
+	}
+
+	// 利用停止時にセッションは失効させているが、念のため停止中のアカウントは更新させない
+	if user.SuspendedAt.Valid {
+		logger.Warn("refresh: account suspended", "user_id", user.ID, "session_id", session.FamilyID)
+		if err := h.sessions.RevokeSessionFamily(c, session.FamilyID); err != nil {
+			logger.Error("refresh: failed to revoke session", "session_id", session.FamilyID, "error", err.Error())
+		}
+		h.clearSessionCookies(c)
+		c.Error(apperrors.ErrAccountSuspended)
+		return
+	}
+
+	nextToken, params, err := h.newSessionParams(c, user.ID, session.FamilyID)
+	if err != nil {
+		logger.Error("refresh: failed to generate refresh token", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		return
+	}
+
+	if _, err = h.sessions.RotateSession(c, session.ID, params); err != nil {
+		if errors.Is(err, db.ErrSessionInactive) {
+			// 並行したリクエストが先にローテーションした = 再利用とみなす
+			h.revokeReusedSession(c, session.FamilyID)
+			return
+		}
+		logger.Error("refresh: failed to rotate session", "session_id", session.FamilyID, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to refresh session", http.StatusInternalServerError))
+		return
+	}
+
+	accessToken, err := h.signAccessToken(c, user, session.FamilyID)
+	if err != nil {
+		logger.Error("refresh: error signing token", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		return
+	}
+
+	if err = h.setSessionCookies(c, accessToken, nextToken); err != nil {
+		logger.Error("refresh: failed to generate csrf token", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("refresh: success", "session_id", session.FamilyID)
+	c.JSON(http.StatusOK, gin.H{"message": "token_refreshed"})
+}
+
+// Logout revokes the session family of the presented refresh token and clears the auth cookies.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	if refreshToken := h.readRefreshToken(c); refreshToken != "" {
+		session, err := h.sessions.GetSessionByTokenHash(c, auth.HashRefreshToken(refreshToken))
+		if err == nil {
+			if err = h.sessions.RevokeSessionFamily(c, session.FamilyID); err != nil {
+				logger.Error("logout: failed to revoke session", "session_id", session.FamilyID, "error", err.Error())
+				c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to revoke session", http.StatusInternalServerError))
+				return
+			}
+			logger.Info("logout: session revoked", "session_id", session.FamilyID)
+		}
+	}
+
+	h.clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "logout_success"})
+}
+
+// Session returns the principal of the current access token. Must be mounted behind middleware.Auth.
+func (h *AuthHandler) Session(c *gin.Context) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		c.Error(apperrors.ErrUnauthenticated)
+		return
+	}
+	c.JSON(http.StatusOK, principal)
+}
+
+// startSession creates a new session family for the user and sets the auth cookies.
+func (h *AuthHandler) startSession(c *gin.Context, user db.User) error {
+	// 退会の猶予期間中にログインした場合は退会を取り消す
+	if err := h.cancelDeletion(c, user); err != nil {
+		return err
+	}
+
+	familyID := uuid.New()
+
+	refreshToken, params, err := h.newSessionParams(c, user.ID, familyID)
+	if err != nil {
+		return err
+	}
+	if _, err = h.sessions.CreateSession(c, params); err != nil {
+		return err
+	}
+
+	accessToken, err := h.signAccessToken(c, user, familyID)
+	if err != nil {
+		return err
+	}
+
+	return h.setSessionCookies(c, accessToken, refreshToken)
+}
+
+func (h *AuthHandler) revokeReusedSession(c *gin.Context, familyID uuid.UUID) {
+	logger := utils.GetLogger(c)
+	logger.Warn("refresh: refresh token reuse detected, revoking session", "session_id", familyID)
+	if err := h.sessions.RevokeSessionFamily(c, familyID); err != nil {
+		logger.Error("refresh: failed to revoke session", "session_id", familyID, "error", err.Error())
+	}
+	h.clearSessionCookies(c)
+	c.Error(apperrors.ErrSessionRevoked)
+}
+
+func (h *AuthHandler) newSessionParams(c *gin.Context, userID, familyID uuid.UUID) (string, db.CreateSessionParams, error) {
+	refreshToken, tokenHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return "", db.CreateSessionParams{}, err
+	}
+	return refreshToken, db.CreateSessionParams{
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: tokenHash,
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+		ExpiresAt: time.Now().Add(time.Duration(h.cfg.Auth.RefreshTokenTTL)),
+	}, nil
+}
+
+// signAccessToken issues an access token for the session. The roles it carries are informational;
+// permissions are checked against the database on every request.
+func (h *AuthHandler) signAccessToken(c *gin.Context, user db.User, sessionID uuid.UUID) (string, error) {
+	roles, err := h.authz.UserRoles(c, user.ID)
+	if err != nil {
+		return "", err
+	}
+	return h.keys.Sign(auth.NewAccessClaims(auth.Principal{
+		UserID:     user.ID,
+		PlayerName: user.Name,
+		Roles:      roles,
+		SessionID:  sessionID,
+		Locale:     user.Locale,
+	}, time.Now(), time.Duration(h.cfg.Auth.AccessTokenTTL)))
+}
+
+func (h *AuthHandler) readRefreshToken(c *gin.Context) string {
+	if token, err := c.Cookie(h.cfg.Cookie.Name(auth.RefreshTokenCookie)); err == nil && token != "" {
+		return token
+	}
+	var input RefreshInput
+	if err := c.ShouldBindJSON(&input); err == nil {
+		return input.RefreshToken
+	}
+	return ""
+}
+
+// setSessionCookies sets the token cookies and a new CSRF token, and echoes the access token
+// in the Authorization header when auth.echo_token_header is enabled
+func (h *AuthHandler) setSessionCookies(c *gin.Context, accessToken, refreshToken string) error {
+	csrfToken, err := auth.NewCSRFToken()
+	if err != nil {
+		return err
+	}
+	refreshTTL := time.Duration(h.cfg.Auth.RefreshTokenTTL)
+	setCookie(c, h.cfg.Cookie, auth.AccessTokenCookie, accessToken, time.Duration(h.cfg.Auth.AccessTokenTTL), true)
+	setCookie(c, h.cfg.Cookie, auth.RefreshTokenCookie, refreshToken, refreshTTL, true)
+	// CSRFトークンはクライアントのスクリプトがヘッダーに付けるため HttpOnly にしない
+	setCookie(c, h.cfg.Cookie, auth.CSRFCookie, csrfToken, refreshTTL, false)
+	if h.cfg.Auth.EchoTokenHeader {
+		c.Header("Authorization", accessToken)
+	}
+	return nil
+}
+
+func (h *AuthHandler) clearSessionCookies(c *gin.Context) {
+	setCookie(c, h.cfg.Cookie, auth.AccessTokenCookie, "", -1, true)
+	setCookie(c, h.cfg.Cookie, auth.RefreshTokenCookie, "", -1, true)
+	setCookie(c, h.cfg.Cookie, auth.CSRFCookie, "", -1, false)
+}
+
+// setCookie sets a cookie for the whole site using the configured Secure/Domain/SameSite policy
+// and name prefix
+func setCookie(c *gin.Context, cookie config.CookieConfig, name, value string, ttl time.Duration, httpOnly bool) {
+	maxAge := -1
+	if ttl > 0 {
+		maxAge = int(ttl.Seconds())
+	}
+	sameSite, _ := cookie.SameSiteMode() // validated at startup
+	c.SetSameSite(sameSite)
+	c.SetCookie(cookie.Name(name), value, maxAge, "/", cookie.Domain, cookie.Secure, httpOnly)
+}
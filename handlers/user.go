@@ -1,25 +1,37 @@
 package handlers
 
 import (
-	"errors"
+	"context"
 	"net/http"
-	"time"
+	"reflect"
+	"strings"
 	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"github.com/golang-jwt/jwt"
-	"github.com/lib/pq"
+	"github.com/google/uuid"
 	"github.com/my-deer/mydeer/internal/db"
 	apperrors "github.com/my-deer/mydeer/internal/errors"
-	"github.com/my-deer/mydeer/middleware"
+	"github.com/my-deer/mydeer/internal/metrics"
 	"github.com/my-deer/mydeer/utils"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // RegisterValidators registers custom validators for the application
 func RegisterValidators(v *validator.Validate) {
 	v.RegisterValidation("complexpassword", validateComplexPassword)
+	v.RegisterValidation("handle", validateHandle)
+
+	// バリデーションエラーのフィールド名にJSONタグ名を使う
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
 }
 
 // validateComplexPassword checks if password has uppercase, lowercase, digit, and symbol
@@ -53,102 +65,210 @@ type LoginInput struct {
 // カスタムバリデーション("validpassword")はここでは使用せず、後でローカル検証します。
 type SignupInput struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=12,max=72,complexpassword"`
+	Password string `json:"password" binding:"required,min=12,max=256,complexpassword"`
 	Name     string `json:"name" binding:"required"`
+	// Handle は公開プロフィールのID（省略時は自動生成、後から PATCH /me で変更可能）
+	Handle string `json:"handle" binding:"omitempty,handle"`
+	// Locale はエラーメッセージ等の言語設定（省略時はAccept-Languageに従う）
+	Locale string `json:"locale" binding:"omitempty,oneof=ja en"`
 }
 
-// LoginHandler is, receive Email and Password and login, issue JWT token and set cookie.
-func LoginHandler(c *gin.Context) {
+// Login is, receive Email and Password and login, start a session and set the token cookies.
+func (h *AuthHandler) Login(c *gin.Context) {
 	logger := utils.GetLogger(c)
-	mydb := c.MustGet("mydb").(*db.DB)
 
 	var input LoginInput
 	if err := c.ShouldBindJSON(&input); err != nil {
-		logger.Error("login: failed to bind json", "error", err.Error())
-		c.Error(apperrors.ErrInvalidInput)
+		logger.Warn("login: validation error", "error", err.Error())
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	// 失敗が続いているアカウント・IPはパスワードを確認する前に拒否する
+	// （存在しないアカウントも同じように扱うので、ロックからアカウントの有無は分からない）
+	wait, err := h.lockout.Check(c, input.Email, c.ClientIP())
+	if err != nil {
+		logger.Error("login: failed to check lockout", "email", input.Email, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
+		return
+	}
+	if wait > 0 {
+		logger.Warn("login: locked out", "email", input.Email, "ip", c.ClientIP(), "retry_after", wait)
+		h.metrics.LoginFailed(metrics.MethodPassword, metrics.ReasonLocked)
+		setRetryAfter(c, wait)
+		c.Error(apperrors.ErrLoginLocked)
 		return
 	}
 
-	user, err := mydb.GetUserByEmail(c, input.Email)
+	user, err := h.users.GetUserByEmail(c, input.Email)
 	if err != nil {
 		logger.Warn("login: user lookup failed", "email", input.Email, "error", err)
+		// 存在しないアカウントでもパスワードを照合したのと同じだけ時間をかける
+		h.passwords.VerifyDummy(input.Password)
+		h.recordLoginFailure(c, input.Email, metrics.MethodPassword, metrics.ReasonInvalidCredentials)
 		// Always return generic error for authentication attempts to prevent user enumeration
 		c.Error(apperrors.ErrInvalidCredentials)
 		return
 	}
 
 	// パスワード比較（passwordはログに出力しない）
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+	ok, needsRehash := h.passwords.Verify(user.Password, input.Password)
+	if !ok {
 		logger.Warn("login: invalid credentials", "email", input.Email)
+		h.recordLoginFailure(c, input.Email, metrics.MethodPassword, metrics.ReasonInvalidCredentials)
 		c.Error(apperrors.ErrInvalidCredentials)
 		return
 	}
+	if needsRehash {
+		h.rehashPassword(c, user.ID, input.Password)
+	}
 
-	// JWTトークン作成
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": input.Email,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(middleware.SECRET_KEY))
+	mfaEnabled, err := h.mfa.Enabled(c, user.ID)
 	if err != nil {
-		logger.Error("login: error signing token", "email", input.Email, "error", err.Error())
-		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		logger.Error("login: failed to check two-factor authentication", "email", input.Email, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to log in", http.StatusInternalServerError))
 		return
 	}
 
-	// Set-CookieヘッダーにJWTトークンを設定（24時間有効, HttpOnly）
-	c.SetCookie("token", tokenString, int(24*time.Hour.Seconds()), "/", "", false, true)
+	// 2段階認証が有効な場合、失敗回数は認証コードの確認後にリセットする
+	if !mfaEnabled {
+		if err = h.lockout.RecordSuccess(c, input.Email); err != nil {
+			logger.Error("login: failed to reset lockout", "email", input.Email, "error", err.Error())
+		}
+	}
+
+	// メールアドレス未確認のアカウントはログインさせない（パスワード確認後に判定し、アカウントの存在を漏らさない）
+	if h.cfg.Auth.EmailVerification.Required && !user.EmailVerifiedAt.Valid {
+		logger.Warn("login: email not verified", "email", input.Email)
+		h.metrics.LoginFailed(metrics.MethodPassword, metrics.ReasonUnverified)
+		c.Error(apperrors.ErrEmailNotVerified)
+		return
+	}
+
+	// 利用停止中のアカウントも同様にパスワード確認後に拒否する
+	if user.SuspendedAt.Valid {
+		logger.Warn("login: account suspended", "email", input.Email)
+		h.metrics.LoginFailed(metrics.MethodPassword, metrics.ReasonSuspended)
+		c.Error(apperrors.ErrAccountSuspended)
+		return
+	}
 
+	// 2段階認証が有効な場合はセッションを開始せず、/login/mfa で使うチャレンジを返す
+	if mfaEnabled {
+		challenge, err := h.issueMFAChallenge(user)
+		if err != nil {
+			logger.Error("login: failed to issue mfa challenge", "email", input.Email, "error", err.Error())
+			c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+			return
+		}
+		logger.Info("login: mfa required", "email", input.Email)
+		c.JSON(http.StatusOK, gin.H{"message": "mfa_required", "mfa_token": challenge})
+		return
+	}
+
+	// アクセストークンとリフレッシュトークンを発行し、Cookieにセット（HttpOnly）
+	if err = h.startSession(c, user); err != nil {
+		logger.Error("login: failed to start session", "email", input.Email, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to generate authentication token", http.StatusInternalServerError))
+		return
+	}
+
+	h.metrics.LoginSucceeded(metrics.MethodPassword)
 	logger.Info("login: success", "email", input.Email)
-	c.Header("Authorization", tokenString) // 必要ならヘッダーにもセット
 	c.JSON(http.StatusOK, gin.H{"message": "login_success"})
 }
 
-// SignupHandler は、受け取ったEmail, Password, Nameを検証後、bcryptでハッシュ化しDBに保存します。
-func SignupHandler(c *gin.Context) {
+// recordLoginFailure counts a failed login for the lockout and the metrics.
+// Errors are only logged so the response stays the generic one.
+func (h *AuthHandler) recordLoginFailure(c *gin.Context, email, method, reason string) {
+	h.metrics.LoginFailed(method, reason)
+	if err := h.lockout.RecordFailure(c, email, c.ClientIP()); err != nil {
+		utils.GetLogger(c).Error("login: failed to record failure", "email", email, "error", err.Error())
+	}
+}
+
+// rehashPassword replaces a legacy or outdated hash after a successful login.
+// Failures are only logged; the old hash keeps working.
+func (h *AuthHandler) rehashPassword(c *gin.Context, userID uuid.UUID, password string) {
+	logger := utils.GetLogger(c)
+
+	hash, err := h.passwords.Hash(password)
+	if err == nil {
+		err = h.users.UpdatePassword(c, userID, hash)
+	}
+	if err != nil {
+		logger.Error("login: failed to upgrade password hash", "user_id", userID, "error", err.Error())
+		return
+	}
+	logger.Info("login: password hash upgraded", "user_id", userID)
+}
+
+// Signup は、受け取ったEmail, Password, Nameを検証後、Argon2idでハッシュ化しDBに保存します。
+// アカウントはメールアドレス未確認の状態で作成され、確認用リンクをメールで送信します。
+func (h *AuthHandler) Signup(c *gin.Context) {
 	logger := utils.GetLogger(c)
-	mydb := c.MustGet("mydb").(*db.DB)
 
 	var input SignupInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		logger.Warn("signup: validation error", "error", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "validation_failed",
-			"code":  "400",
-		})
+		c.Error(apperrors.FromBindingError(err))
 		return
 	}
 
-	// bcryptでパスワードをハッシュ化（passwordはログに出さない）
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if appErr := reservedHandleError(input.Handle); appErr != nil {
+		logger.Warn("signup: reserved handle", "email", input.Email, "handle", input.Handle)
+		c.Error(appErr)
+		return
+	}
+
+	// 流出済み・推測されやすいパスワードは拒否する
+	if appErr := checkNewPassword(h.policy, "password", input.Password, input.Email, input.Name); appErr != nil {
+		logger.Warn("signup: password rejected", "email", input.Email, "error", appErr.Error())
+		c.Error(appErr)
+		return
+	}
+
+	// パスワードをハッシュ化（passwordはログに出さない）
+	hashedPassword, err := h.passwords.Hash(input.Password)
 	if err != nil {
 		logger.Error("signup: failed to hash password", "email", input.Email, "error", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_server_error", "code": "500"})
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to create user", http.StatusInternalServerError))
 		return
 	}
 
-	// ユーザー登録（DB側でUUID自動生成前提）
-	_, err = mydb.CreateUser(c, db.CreateUserParams{
-		Email:    input.Email,
-		Password: string(hashedPassword),
-		Name:     input.Name,
+	// ユーザーとプロフィールを同時に登録（DB側でUUID自動生成前提）
+	var user db.CreateUserRow
+	err = h.tx.RunInTx(c, func(ctx context.Context) error {
+		var err error
+		user, err = h.users.CreateUser(ctx, db.CreateUserParams{
+			Email:    input.Email,
+			Password: hashedPassword,
+			Name:     input.Name,
+			Locale:   input.Locale,
+		})
+		if err != nil {
+			return err
+		}
+		return createProfile(ctx, h.profiles, user.ID, input.Handle, input.Name)
 	})
 	if err != nil {
-		// 重複エラーの場合、Postgresのエラーコード23505（unique violation）をチェック
-		var pqErr *pq.Error
-		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
-			logger.Warn("signup: duplicate email", "email", input.Email)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "email_already_exists",
-				"code":  "400",
-			})
-			return
+		// 重複エラー（リポジトリがunique violationをapperrorsの重複エラーとして返す。メールアドレスかhandle）
+		appErr := apperrors.FormatError(apperrors.WrapDBError(err))
+		if apperrors.IsDuplicate(appErr) {
+			logger.Warn("signup: duplicate email or handle", "email", input.Email, "handle", input.Handle)
+		} else {
+			logger.Error("signup: failed to create user", "email", input.Email, "error", err.Error())
 		}
-		logger.Error("signup: failed to create user", "email", input.Email, "error", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_server_error", "code": "500"})
+		c.Error(appErr)
 		return
 	}
 
+	// 確認メールの送信に失敗してもアカウントは作成済み（再送で回復できる）
+	if err = h.verification.Send(c, user.ID, user.Email, user.Locale); err != nil {
+		logger.Error("signup: failed to send verification email", "email", input.Email, "error", err.Error())
+	}
+
+	h.metrics.Signup(metrics.MethodPassword)
 	logger.Info("signup: user created", "email", input.Email)
 	c.JSON(http.StatusOK, gin.H{"message": "user_created"})
 }
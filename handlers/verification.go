@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/mail"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// EmailVerificationHandler sends verification links and serves the endpoints that consume them
+type EmailVerificationHandler struct {
+	cfg    *config.Config
+	users  db.UserRepository
+	tokens db.UserTokenRepository
+	tx     db.TxRunner
+	mailer mail.Mailer
+}
+
+// NewEmailVerificationHandler creates an EmailVerificationHandler
+func NewEmailVerificationHandler(cfg *config.Config, users db.UserRepository, tokens db.UserTokenRepository, tx db.TxRunner, mailer mail.Mailer) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		cfg:    cfg,
+		users:  users,
+		tokens: tokens,
+		tx:     tx,
+		mailer: mailer,
+	}
+}
+
+// VerifyEmailInput は確認トークンの入力構造体です（POST用）。
+type VerifyEmailInput struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// ResendVerificationInput は確認メール再送の入力構造体です。
+type ResendVerificationInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// VerifyEmail consumes the verification token from the link (GET ?token=) or the JSON body (POST)
+// and marks the email address of its user as verified.
+func (h *EmailVerificationHandler) VerifyEmail(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	var input VerifyEmailInput
+	bind := c.ShouldBindJSON
+	if c.Request.Method == http.MethodGet {
+		bind = c.ShouldBindQuery
+	}
+	if err := bind(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	// トークンの消費と確認済みへの更新は同じトランザクションで行う
+	var userID uuid.UUID
+	err := h.tx.RunInTx(c, func(ctx context.Context) error {
+		token, err := h.tokens.ConsumeUserToken(ctx, db.TokenPurposeEmailVerification, auth.HashOpaqueToken(input.Token))
+		if err != nil {
+			return err
+		}
+		userID = token.UserID
+		return h.users.MarkEmailVerified(ctx, token.UserID)
+	})
+	if errors.Is(err, db.ErrUserTokenInvalid) {
+		logger.Warn("verify-email: invalid or used token")
+		c.Error(apperrors.ErrInvalidEmailToken)
+		return
+	}
+	if err != nil {
+		logger.Error("verify-email: failed to verify", "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to verify email address", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("verify-email: verified", "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "email_verified"})
+}
+
+// ResendVerification sends a new verification link. Unknown and already verified addresses get the
+// same response so the endpoint cannot be used to probe accounts, and repeated requests within the
+// resend interval are ignored without telling the caller.
+func (h *EmailVerificationHandler) ResendVerification(c *gin.Context) {
+	logger := utils.GetLogger(c)
+
+	var input ResendVerificationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperrors.FromBindingError(err))
+		return
+	}
+
+	user, err := h.users.GetUserByEmail(c, input.Email)
+	if err != nil || user.EmailVerifiedAt.Valid {
+		logger.Info("resend-verification: nothing to send", "email", input.Email)
+		c.JSON(http.StatusAccepted, gin.H{"message": "verification_email_sent"})
+		return
+	}
+
+	// 前回の送信から一定時間は再送しない（制限中であることもアカウントの有無を漏らさないよう伝えない。
+	// リクエスト数の制限は email 単位のレート制限で行う）
+	interval := time.Duration(h.cfg.Auth.EmailVerification.ResendInterval)
+	if latest, err := h.tokens.GetLatestUserToken(c, user.ID, db.TokenPurposeEmailVerification); err == nil && time.Since(latest.CreatedAt.Time) < interval {
+		logger.Warn("resend-verification: throttled", "email", input.Email)
+		c.JSON(http.StatusAccepted, gin.H{"message": "verification_email_sent"})
+		return
+	}
+
+	if err := h.Send(c, user.ID, user.Email, user.Locale); err != nil {
+		logger.Error("resend-verification: failed to send", "email", input.Email, "error", err.Error())
+		c.Error(apperrors.Wrap(err, apperrors.ErrInternal, "Failed to send verification email", http.StatusInternalServerError))
+		return
+	}
+
+	logger.Info("resend-verification: sent", "email", input.Email)
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification_email_sent"})
+}
+
+// Send issues a verification token for the user and emails the link.
+// The email is written in the user's locale, or the locale of the request when the user has none.
+func (h *EmailVerificationHandler) Send(c *gin.Context, userID uuid.UUID, email, locale string) error {
+	ttl := time.Duration(h.cfg.Auth.EmailVerification.TokenTTL)
+
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if _, err = h.tokens.CreateUserToken(c, db.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   db.TokenPurposeEmailVerification,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return err
+	}
+
+	link := publicLink(h.cfg, "/verify-email", url.Values{"token": {token}})
+	return h.mailer.Send(c, mail.VerificationMessage(mailLocale(c, locale), email, link, ttl))
+}
@@ -0,0 +1,315 @@
+// Package account implements the end of the account lifecycle: deletion with a grace period,
+// the purge of accounts whose grace period ended and the export of the personal data of a player,
+// as well as the operations of the admin API on accounts (suspension, forced logout, roles).
+// Every step is recorded in the audit log.
+package account
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/rbac"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionDeletionRequested = "account.deletion_requested"
+	ActionDeletionCancelled = "account.deletion_cancelled"
+	ActionPurged            = "account.purged"
+	ActionExported          = "account.exported"
+	ActionSuspended         = "account.suspended"
+	ActionUnsuspended       = "account.unsuspended"
+	ActionSessionsRevoked   = "account.sessions_revoked"
+	ActionRolesChanged      = "account.roles_changed"
+)
+
+// ErrUnknownRole is returned by SetRoles for a role that does not exist or cannot be assigned
+var ErrUnknownRole = errors.New("account: unknown role")
+
+// purgeBatchSize bounds the accounts deleted by one PurgeDue call
+const purgeBatchSize = 100
+
+// Client identifies who made a request and from where, for the audit log
+type Client struct {
+	// ActorID is the account making the request, uuid.Nil for the system
+	ActorID   uuid.UUID
+	IP        string
+	UserAgent string
+}
+
+// Data is everything stored about a user, collected by Export
+type Data struct {
+	User       db.User
+	Profile    db.PlayerProfile
+	Sessions   []db.Session
+	Tokens     []db.UserToken
+	Identities []db.UserIdentity
+	MFA        mfa.Status
+	// LoginAttempt is the failed login counter of the email address, nil when there is none
+	LoginAttempt *db.LoginAttempt
+	AuditLogs    []db.AuditLog
+}
+
+// Service deletes and exports accounts
+type Service struct {
+	users      db.UserRepository
+	sessions   db.SessionRepository
+	tokens     db.UserTokenRepository
+	attempts   db.LoginAttemptRepository
+	identities db.IdentityRepository
+	profiles   db.ProfileRepository
+	audit      db.AuditLogRepository
+	roles      db.RoleRepository
+	tx         db.TxRunner
+	mfa        *mfa.Service
+	grace      time.Duration
+	now        func() time.Time
+}
+
+// Deps are the repositories and services a Service works with
+type Deps struct {
+	Users      db.UserRepository
+	Sessions   db.SessionRepository
+	Tokens     db.UserTokenRepository
+	Attempts   db.LoginAttemptRepository
+	Identities db.IdentityRepository
+	Profiles   db.ProfileRepository
+	Audit      db.AuditLogRepository
+	Roles      db.RoleRepository
+	Tx         db.TxRunner
+	// MFA provides the 2FA status included in exports; it may be nil when Export is not used
+	MFA *mfa.Service
+	// GracePeriod is how long a deleted account can be restored before PurgeDue removes it
+	GracePeriod time.Duration
+}
+
+// NewService creates a Service
+func NewService(d Deps) *Service {
+	return &Service{
+		users:      d.Users,
+		sessions:   d.Sessions,
+		tokens:     d.Tokens,
+		attempts:   d.Attempts,
+		identities: d.Identities,
+		profiles:   d.Profiles,
+		audit:      d.Audit,
+		roles:      d.Roles,
+		tx:         d.Tx,
+		mfa:        d.MFA,
+		grace:      d.GracePeriod,
+		now:        time.Now,
+	}
+}
+
+// RequestDeletion schedules the deletion of the account after the grace period and logs it out
+// everywhere. It returns the time the account will be deleted.
+func (s *Service) RequestDeletion(ctx context.Context, userID uuid.UUID, client Client) (time.Time, error) {
+	deleteAfter := s.now().Add(s.grace)
+	err := s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.users.ScheduleUserDeletion(ctx, userID, deleteAfter); err != nil {
+			return err
+		}
+		if err := s.sessions.RevokeUserSessions(ctx, userID, uuid.Nil); err != nil {
+			return err
+		}
+		return s.record(ctx, userID, ActionDeletionRequested, client)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return deleteAfter, nil
+}
+
+// CancelDeletion restores an account scheduled for deletion and reports whether it was scheduled
+func (s *Service) CancelDeletion(ctx context.Context, userID uuid.UUID, client Client) (bool, error) {
+	var cancelled bool
+	err := s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		cancelled, err = s.users.CancelUserDeletion(ctx, userID)
+		if err != nil || !cancelled {
+			return err
+		}
+		return s.record(ctx, userID, ActionDeletionCancelled, client)
+	})
+	return cancelled, err
+}
+
+// PurgeDue deletes the accounts whose grace period ended at now and returns their ids.
+// Accounts that fail to be deleted are skipped and reported in the error; they are retried
+// by the next call.
+func (s *Service) PurgeDue(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	due, err := s.users.ListUsersDueForDeletion(ctx, now, purgeBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []uuid.UUID
+	var errs []error
+	for _, user := range due {
+		deleted, err := s.purge(ctx, user, now)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "account: failed to purge %s", user.ID))
+			continue
+		}
+		if deleted {
+			purged = append(purged, user.ID)
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// purge deletes the account and every row referencing it. Rows that outlive the account
+// (the audit log) are anonymized instead. It reports false when another purge got there first
+// or the deletion was cancelled since the account was listed.
+func (s *Service) purge(ctx context.Context, user db.User, now time.Time) (bool, error) {
+	var deleted bool
+	err := s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		// 一覧の取得後にログインで取り消された場合は削除しない（削除予定を削除と同じ文で確認する）
+		var err error
+		deleted, err = s.users.DeleteUserDueForDeletion(ctx, user.ID, now)
+		if err != nil || !deleted {
+			return err
+		}
+		if err := s.audit.AnonymizeAuditLogs(ctx, user.ID); err != nil {
+			return err
+		}
+		// ログイン失敗の記録はメールアドレスをキーにしているため外部キーでは消えない
+		if err := s.attempts.DeleteLoginAttempt(ctx, lockout.AccountKey(user.Email)); err != nil {
+			return err
+		}
+		return s.record(ctx, user.ID, ActionPurged, Client{})
+	})
+	return deleted, err
+}
+
+// Export records the export in the audit log and returns everything stored about the user
+func (s *Service) Export(ctx context.Context, userID uuid.UUID, client Client) (Data, error) {
+	if err := s.record(ctx, userID, ActionExported, client); err != nil {
+		return Data{}, err
+	}
+
+	var (
+		data Data
+		err  error
+	)
+	if data.User, err = s.users.GetUserByID(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if data.Profile, err = s.profiles.GetPlayerProfile(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if data.Sessions, err = s.sessions.ListUserSessions(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if data.Tokens, err = s.tokens.ListUserTokens(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if data.Identities, err = s.identities.ListUserIdentities(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if data.MFA, err = s.mfa.Status(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	attempt, err := s.attempts.GetLoginAttempt(ctx, lockout.AccountKey(data.User.Email))
+	switch {
+	case err == nil:
+		data.LoginAttempt = &attempt
+	case !errors.Is(err, sql.ErrNoRows):
+		return Data{}, err
+	}
+	if data.AuditLogs, err = s.audit.ListAuditLogs(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	return data, nil
+}
+
+// Suspend blocks the user from logging in and logs it out everywhere.
+// It reports false when the user was already suspended.
+func (s *Service) Suspend(ctx context.Context, userID uuid.UUID, client Client) (bool, error) {
+	var suspended bool
+	err := s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		suspended, err = s.users.SuspendUser(ctx, userID)
+		if err != nil || !suspended {
+			return err
+		}
+		if err := s.sessions.RevokeUserSessions(ctx, userID, uuid.Nil); err != nil {
+			return err
+		}
+		return s.record(ctx, userID, ActionSuspended, client)
+	})
+	return suspended, err
+}
+
+// Unsuspend lifts the suspension of the user and reports whether it was suspended
+func (s *Service) Unsuspend(ctx context.Context, userID uuid.UUID, client Client) (bool, error) {
+	var unsuspended bool
+	err := s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		unsuspended, err = s.users.UnsuspendUser(ctx, userID)
+		if err != nil || !unsuspended {
+			return err
+		}
+		return s.record(ctx, userID, ActionUnsuspended, client)
+	})
+	return unsuspended, err
+}
+
+// RevokeSessions logs the user out everywhere. Access tokens already issued stop working
+// on their next request.
+func (s *Service) RevokeSessions(ctx context.Context, userID uuid.UUID, client Client) error {
+	return s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.sessions.RevokeUserSessions(ctx, userID, uuid.Nil); err != nil {
+			return err
+		}
+		return s.record(ctx, userID, ActionSessionsRevoked, client)
+	})
+}
+
+// SetRoles replaces the roles assigned to the user and returns them sorted.
+// The player role is implicit and may not be assigned; unknown roles fail with ErrUnknownRole.
+func (s *Service) SetRoles(ctx context.Context, userID uuid.UUID, roles []string, client Client) ([]string, error) {
+	known, err := s.roles.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assigned := []string{}
+	for _, role := range roles {
+		if role == rbac.RolePlayer || !slices.ContainsFunc(known, func(r db.Role) bool { return r.Name == role }) {
+			return nil, errors.Wrapf(ErrUnknownRole, "%q", role)
+		}
+		if !slices.Contains(assigned, role) {
+			assigned = append(assigned, role)
+		}
+	}
+	slices.Sort(assigned)
+
+	err = s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.roles.SetUserRoles(ctx, userID, assigned); err != nil {
+			return err
+		}
+		return s.record(ctx, userID, ActionRolesChanged, client)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assigned, nil
+}
+
+func (s *Service) record(ctx context.Context, userID uuid.UUID, action string, client Client) error {
+	_, err := s.audit.CreateAuditLog(ctx, db.CreateAuditLogParams{
+		UserID:    userID,
+		ActorID:   client.ActorID,
+		Action:    action,
+		IPAddress: client.IP,
+		UserAgent: client.UserAgent,
+	})
+	return err
+}
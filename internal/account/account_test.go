@@ -0,0 +1,303 @@
+package account
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/db/memory"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, now *time.Time) (*Service, *memory.Store) {
+	store := memory.New()
+	mfaService, err := mfa.NewService(store, "mydeer", nil)
+	require.NoError(t, err)
+	s := NewService(Deps{
+		Users:       store,
+		Sessions:    store,
+		Tokens:      store,
+		Attempts:    store,
+		Identities:  store,
+		Profiles:    store,
+		Audit:       store,
+		Roles:       store,
+		Tx:          store,
+		MFA:         mfaService,
+		GracePeriod: 24 * time.Hour,
+	})
+	s.now = func() time.Time { return *now }
+	return s, store
+}
+
+func createUser(t *testing.T, store *memory.Store, email string) uuid.UUID {
+	ctx := context.Background()
+	user, err := store.CreateUser(ctx, db.CreateUserParams{Email: email, Password: "x", Name: "Deer"})
+	require.NoError(t, err)
+	_, err = store.CreatePlayerProfile(ctx, db.CreatePlayerProfileParams{UserID: user.ID, Handle: "deer_" + user.ID.String()[:8], DisplayName: "Deer"})
+	require.NoError(t, err)
+	_, err = store.CreateSession(ctx, db.CreateSessionParams{FamilyID: uuid.New(), UserID: user.ID, TokenHash: email, IPAddress: "192.0.2.1", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	return user.ID
+}
+
+func TestDeletionIsPurgedAfterGracePeriod(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, store := newTestService(t, &now)
+	ctx := context.Background()
+	client := Client{IP: "192.0.2.1", UserAgent: "test"}
+
+	userID := createUser(t, store, "leaving@example.com")
+	otherID := createUser(t, store, "staying@example.com")
+	_, err := store.IncrementLoginFailures(ctx, lockout.AccountKey("leaving@example.com"), now, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	deleteAfter, err := s.RequestDeletion(ctx, userID, client)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(24*time.Hour), deleteAfter)
+
+	// すべてのセッションが失効する
+	sessions, err := store.ListUserSessions(ctx, userID)
+	require.NoError(t, err)
+	for _, session := range sessions {
+		assert.True(t, session.RevokedAt.Valid)
+	}
+
+	// 猶予期間中は削除されない
+	purged, err := s.PurgeDue(ctx, now.Add(23*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+
+	purged, err = s.PurgeDue(ctx, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{userID}, purged)
+
+	_, err = store.GetUserByID(ctx, userID)
+	assert.Error(t, err)
+	_, err = store.GetPlayerProfile(ctx, userID)
+	assert.Error(t, err)
+	sessions, err = store.ListUserSessions(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	_, err = store.GetLoginAttempt(ctx, lockout.AccountKey("leaving@example.com"))
+	assert.Error(t, err)
+
+	// 監査ログは残るが、接続元の情報は消える
+	entries, err := store.ListAuditLogs(ctx, userID)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, ActionDeletionRequested, entries[0].Action)
+		assert.Equal(t, ActionPurged, entries[1].Action)
+	}
+	for _, entry := range entries {
+		assert.Empty(t, entry.IPAddress)
+		assert.Empty(t, entry.UserAgent)
+	}
+
+	// 他のアカウントには影響しない
+	_, err = store.GetUserByID(ctx, otherID)
+	assert.NoError(t, err)
+
+	purged, err = s.PurgeDue(ctx, now.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+}
+
+func TestCancelDeletion(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, store := newTestService(t, &now)
+	ctx := context.Background()
+
+	userID := createUser(t, store, "undecided@example.com")
+
+	cancelled, err := s.CancelDeletion(ctx, userID, Client{})
+	require.NoError(t, err)
+	assert.False(t, cancelled)
+
+	_, err = s.RequestDeletion(ctx, userID, Client{})
+	require.NoError(t, err)
+	cancelled, err = s.CancelDeletion(ctx, userID, Client{})
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+
+	purged, err := s.PurgeDue(ctx, now.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+
+	entries, err := store.ListAuditLogs(ctx, userID)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, ActionDeletionRequested, entries[0].Action)
+		assert.Equal(t, ActionDeletionCancelled, entries[1].Action)
+	}
+}
+
+func TestPurgeAnonymizesActionsOfTheUser(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, store := newTestService(t, &now)
+	ctx := context.Background()
+
+	moderatorID := createUser(t, store, "retiring_mod@example.com")
+	userID := createUser(t, store, "troll@example.com")
+	_, err := s.Suspend(ctx, userID, Client{ActorID: moderatorID, IP: "192.0.2.1", UserAgent: "test"})
+	require.NoError(t, err)
+
+	_, err = s.RequestDeletion(ctx, moderatorID, Client{IP: "192.0.2.1", UserAgent: "test"})
+	require.NoError(t, err)
+	purged, err := s.PurgeDue(ctx, now.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{moderatorID}, purged)
+
+	// 削除されたモデレーターが他のアカウントに行った操作の記録からも接続元の情報は消える
+	entries, err := store.ListAuditLogs(ctx, userID)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, ActionSuspended, entries[0].Action)
+		assert.Equal(t, moderatorID, entries[0].ActorID.UUID)
+		assert.Empty(t, entries[0].IPAddress)
+		assert.Empty(t, entries[0].UserAgent)
+	}
+}
+
+// cancellingStore cancels the deletion of every listed user right after ListUsersDueForDeletion,
+// like a player logging in while the purge job runs
+type cancellingStore struct {
+	*memory.Store
+}
+
+func (s cancellingStore) ListUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]db.User, error) {
+	due, err := s.Store.ListUsersDueForDeletion(ctx, now, limit)
+	for _, user := range due {
+		if _, err := s.CancelUserDeletion(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+	return due, err
+}
+
+func TestPurgeSkipsDeletionCancelledAfterListing(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, store := newTestService(t, &now)
+	s.users = cancellingStore{store}
+	ctx := context.Background()
+
+	userID := createUser(t, store, "returning@example.com")
+	_, err := s.RequestDeletion(ctx, userID, Client{IP: "192.0.2.1"})
+	require.NoError(t, err)
+
+	purged, err := s.PurgeDue(ctx, now.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+
+	_, err = store.GetUserByID(ctx, userID)
+	assert.NoError(t, err)
+	_, err = store.GetPlayerProfile(ctx, userID)
+	assert.NoError(t, err)
+	entries, err := store.ListAuditLogs(ctx, userID)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, ActionDeletionRequested, entries[0].Action)
+		assert.Equal(t, "192.0.2.1", entries[0].IPAddress)
+	}
+}
+
+func TestExport(t *testing.T) {
+	now := time.Now()
+	s, store := newTestService(t, &now)
+	ctx := context.Background()
+
+	userID := createUser(t, store, "curious@example.com")
+
+	data, err := s.Export(ctx, userID, Client{IP: "192.0.2.1"})
+	require.NoError(t, err)
+	assert.Equal(t, "curious@example.com", data.User.Email)
+	assert.Equal(t, "Deer", data.Profile.DisplayName)
+	assert.Len(t, data.Sessions, 1)
+	assert.Nil(t, data.LoginAttempt)
+	assert.False(t, data.MFA.Enabled)
+	// エクスポート自体も記録され、アーカイブに含まれる
+	if assert.Len(t, data.AuditLogs, 1) {
+		assert.Equal(t, ActionExported, data.AuditLogs[0].Action)
+		assert.Equal(t, "192.0.2.1", data.AuditLogs[0].IPAddress)
+	}
+}
+
+func TestSuspend(t *testing.T) {
+	now := time.Now()
+	s, store := newTestService(t, &now)
+	ctx := context.Background()
+	adminID := createUser(t, store, "admin@example.com")
+	client := Client{ActorID: adminID, IP: "192.0.2.1"}
+
+	userID := createUser(t, store, "troll@example.com")
+
+	suspended, err := s.Suspend(ctx, userID, client)
+	require.NoError(t, err)
+	assert.True(t, suspended)
+	user, err := store.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, user.SuspendedAt.Valid)
+	sessions, err := store.ListUserSessions(ctx, userID)
+	require.NoError(t, err)
+	for _, session := range sessions {
+		assert.True(t, session.RevokedAt.Valid)
+	}
+
+	// 停止済みなら何もしない
+	suspended, err = s.Suspend(ctx, userID, client)
+	require.NoError(t, err)
+	assert.False(t, suspended)
+
+	unsuspended, err := s.Unsuspend(ctx, userID, client)
+	require.NoError(t, err)
+	assert.True(t, unsuspended)
+	user, err = store.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, user.SuspendedAt.Valid)
+
+	// 操作した管理者が記録される
+	entries, err := store.ListAuditLogs(ctx, userID)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, ActionSuspended, entries[0].Action)
+		assert.Equal(t, ActionUnsuspended, entries[1].Action)
+		assert.Equal(t, adminID, entries[0].ActorID.UUID)
+	}
+}
+
+func TestSetRoles(t *testing.T) {
+	now := time.Now()
+	s, store := newTestService(t, &now)
+	ctx := context.Background()
+
+	userID := createUser(t, store, "helper@example.com")
+
+	roles, err := s.SetRoles(ctx, userID, []string{"moderator", "admin", "moderator"}, Client{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "moderator"}, roles)
+
+	for _, role := range []string{"player", "owner"} {
+		_, err = s.SetRoles(ctx, userID, []string{role}, Client{})
+		assert.ErrorIs(t, err, ErrUnknownRole, role)
+	}
+	assigned, err := store.ListUserRoles(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "moderator"}, assigned)
+
+	roles, err = s.SetRoles(ctx, userID, []string{}, Client{})
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+	assigned, err = store.ListUserRoles(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, assigned)
+
+	entries, err := store.ListAuditLogs(ctx, userID)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.False(t, entries[0].ActorID.Valid)
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// RolePlayer is the role every registered account has
+const RolePlayer = "player"
+
+// Audiences of the tokens signed by the KeySet. Every kind of token has its own, so a consumer
+// of the JWKS that checks the audience never accepts an MFA challenge or an OIDC flow as an access token.
+const (
+	AudienceAccess       = "mydeer:access"
+	AudienceMFAChallenge = "mydeer:mfa_challenge"
+	AudienceOIDCFlow     = "mydeer:oidc_flow"
+)
+
+// Claims are the claims carried by an access token.
+// The subject is the user UUID, never the email address.
+type Claims struct {
+	PlayerName string   `json:"name,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	SessionID  string   `json:"sid"`
+	Locale     string   `json:"locale,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID     uuid.UUID `json:"user_id"`
+	PlayerName string    `json:"player_name"`
+	Roles      []string  `json:"roles"`
+	SessionID  uuid.UUID `json:"session_id"`
+	// Locale is the preferred message language of the player, empty when not set
+	Locale string `json:"locale,omitempty"`
+}
+
+// HasRole reports whether the principal has the given role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAccessClaims builds the claims of an access token for the principal, valid for ttl
+func NewAccessClaims(p Principal, now time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		PlayerName: p.PlayerName,
+		Roles:      p.Roles,
+		SessionID:  p.SessionID.String(),
+		Locale:     p.Locale,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   p.UserID.String(),
+			Audience:  jwt.ClaimStrings{AudienceAccess},
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// Principal converts verified claims into a Principal
+func (c *Claims) Principal() (Principal, error) {
+	userID, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return Principal{}, errors.Wrap(err, "invalid subject claim")
+	}
+	sessionID, err := uuid.Parse(c.SessionID)
+	if err != nil {
+		return Principal{}, errors.Wrap(err, "invalid sid claim")
+	}
+	return Principal{
+		UserID:     userID,
+		PlayerName: c.PlayerName,
+		Roles:      c.Roles,
+		SessionID:  sessionID,
+		Locale:     c.Locale,
+	}, nil
+}
+
+// PurposeMFAChallenge marks a token issued after the password step of a login with 2FA
+const PurposeMFAChallenge = "mfa_challenge"
+
+// MFAChallengeClaims are the claims of the short-lived token exchanged for a session
+// once the second factor is verified. It carries no sid, so it is never accepted as an access token.
+type MFAChallengeClaims struct {
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// NewMFAChallengeClaims builds the claims of an MFA challenge for the user, valid for ttl
+func NewMFAChallengeClaims(userID uuid.UUID, now time.Time, ttl time.Duration) *MFAChallengeClaims {
+	return &MFAChallengeClaims{
+		Purpose: PurposeMFAChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{AudienceMFAChallenge},
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// UserID returns the user of a verified challenge
+func (c *MFAChallengeClaims) UserID() (uuid.UUID, error) {
+	if c.Purpose != PurposeMFAChallenge {
+		return uuid.Nil, errors.New("not an mfa challenge")
+	}
+	userID, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "invalid subject claim")
+	}
+	return userID, nil
+}
+
+// PurposeOIDCFlow marks the token keeping the state of an OpenID Connect login between redirects
+const PurposeOIDCFlow = "oidc_flow"
+
+// OIDCFlowClaims are stored in a short-lived cookie while the browser is at the provider.
+// The callback accepts the response only if state matches, and uses nonce and verifier to check it.
+type OIDCFlowClaims struct {
+	Purpose  string `json:"purpose"`
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// NewOIDCFlowClaims builds the claims of an OpenID Connect login started at now, valid for ttl
+func NewOIDCFlowClaims(provider, state, nonce, verifier string, now time.Time, ttl time.Duration) *OIDCFlowClaims {
+	return &OIDCFlowClaims{
+		Purpose:  PurposeOIDCFlow,
+		Provider: provider,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{AudienceOIDCFlow},
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
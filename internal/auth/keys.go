@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// KeySpec describes a single JWT key as it appears in configuration.
+// HS256 keys use Secret (or a file containing the secret), RS256/EdDSA keys use a PEM file.
+// A PEM file holding only a public key makes the key verification-only.
+type KeySpec struct {
+	ID        string `json:"id" yaml:"id" toml:"id"`
+	Algorithm string `json:"algorithm" yaml:"algorithm" toml:"algorithm"`
+	Secret    string `json:"secret" yaml:"secret" toml:"secret"`
+	KeyFile   string `json:"key_file" yaml:"key_file" toml:"key_file"`
+}
+
+// KeyConfig lists the keys accepted for verification and the one used for signing
+type KeyConfig struct {
+	ActiveKeyID string    `json:"active_key_id" yaml:"active_key_id" toml:"active_key_id"`
+	Keys        []KeySpec `json:"keys" yaml:"keys" toml:"keys"`
+}
+
+// key is a parsed KeySpec
+type key struct {
+	id        string
+	algorithm string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet signs tokens with the active key and verifies tokens with any configured key,
+// selected by the "kid" header. This allows old keys to keep verifying during rotation.
+type KeySet struct {
+	active *key
+	keys   map[string]*key
+	order  []string
+}
+
+// NewKeySet parses the configured keys. The active key must be able to sign.
+func NewKeySet(cfg KeyConfig) (*KeySet, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("no jwt keys configured")
+	}
+
+	ks := &KeySet{keys: make(map[string]*key, len(cfg.Keys))}
+	for _, spec := range cfg.Keys {
+		k, err := parseKey(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ks.keys[k.id]; dup {
+			return nil, errors.Newf("duplicate jwt key id: %s", k.id)
+		}
+		ks.keys[k.id] = k
+		ks.order = append(ks.order, k.id)
+	}
+
+	activeID := cfg.ActiveKeyID
+	if activeID == "" {
+		activeID = cfg.Keys[0].ID
+	}
+	active, ok := ks.keys[activeID]
+	if !ok {
+		return nil, errors.Newf("active jwt key not found: %s", activeID)
+	}
+	if active.signKey == nil {
+		return nil, errors.Newf("active jwt key %s has no private key", activeID)
+	}
+	ks.active = active
+
+	return ks, nil
+}
+
+// NewEphemeralKeySet creates a KeySet with a random HS256 key.
+// Tokens signed with it do not survive a restart, so it is meant for development only.
+func NewEphemeralKeySet() (*KeySet, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "failed to generate jwt secret")
+	}
+	return NewKeySet(KeyConfig{Keys: []KeySpec{{
+		ID:        "ephemeral",
+		Algorithm: AlgHS256,
+		Secret:    base64.RawURLEncoding.EncodeToString(secret),
+	}}})
+}
+
+// ActiveKeyID returns the kid used for newly signed tokens
+func (ks *KeySet) ActiveKeyID() string {
+	return ks.active.id
+}
+
+// Sign signs the claims with the active key and sets the "kid" header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.id
+	signed, err := token.SignedString(ks.active.signKey)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign token")
+	}
+	return signed, nil
+}
+
+// Parse verifies the token signature with the key named by its "kid" header and decodes it into claims.
+// The token algorithm must match the algorithm configured for that key, and the token must be
+// issued for audience (one of the Audience constants).
+func (ks *KeySet) Parse(tokenString, audience string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyFunc, jwt.WithAudience(audience))
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, errors.Newf("unknown key id: %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, errors.Newf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.verifyKey, nil
+}
+
+// JWK is a single public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of all asymmetric keys. Symmetric keys are never exposed.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range ks.order {
+		k := ks.keys[id]
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     k.id,
+				Use:       "sig",
+				Algorithm: k.algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     k.id,
+				Use:       "sig",
+				Algorithm: k.algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
+
+func parseKey(spec KeySpec) (*key, error) {
+	if spec.ID == "" {
+		return nil, errors.New("jwt key id is required")
+	}
+
+	k := &key{id: spec.ID, algorithm: spec.Algorithm}
+	switch spec.Algorithm {
+	case AlgHS256:
+		secret, err := readSecret(spec)
+		if err != nil {
+			return nil, err
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = secret
+		k.verifyKey = secret
+	case AlgRS256, AlgEdDSA:
+		if spec.KeyFile == "" {
+			return nil, errors.Newf("jwt key %s: key_file is required for %s", spec.ID, spec.Algorithm)
+		}
+		signKey, verifyKey, err := readPEMKey(spec.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "jwt key %s", spec.ID)
+		}
+		if spec.Algorithm == AlgRS256 {
+			k.method = jwt.SigningMethodRS256
+			if _, ok := verifyKey.(*rsa.PublicKey); !ok {
+				return nil, errors.Newf("jwt key %s: not an RSA key", spec.ID)
+			}
+		} else {
+			k.method = jwt.SigningMethodEdDSA
+			if _, ok := verifyKey.(ed25519.PublicKey); !ok {
+				return nil, errors.Newf("jwt key %s: not an Ed25519 key", spec.ID)
+			}
+		}
+		k.signKey = signKey
+		k.verifyKey = verifyKey
+	default:
+		return nil, errors.Newf("jwt key %s: unsupported algorithm %q", spec.ID, spec.Algorithm)
+	}
+
+	return k, nil
+}
+
+func readSecret(spec KeySpec) ([]byte, error) {
+	secret := spec.Secret
+	if spec.KeyFile != "" {
+		raw, err := os.ReadFile(spec.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "jwt key %s: failed to read secret file", spec.ID)
+		}
+		secret = strings.TrimSpace(string(raw))
+	}
+	if len(secret) < 32 {
+		return nil, errors.Newf("jwt key %s: HS256 secret must be at least 32 bytes", spec.ID)
+	}
+	return []byte(secret), nil
+}
+
+// readPEMKey returns the private key (nil for public-only files) and the public key from a PEM file
+func readPEMKey(path string) (crypto.Signer, crypto.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read key file")
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, nil, errors.New("no PEM data found in key file")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse public key")
+		}
+		return nil, pub, nil
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse RSA private key")
+		}
+		return priv, priv.Public(), nil
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse private key")
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.New("unsupported private key type")
+		}
+		return signer, signer.Public(), nil
+	default:
+		return nil, nil, errors.Newf("unsupported PEM block type: %s", block.Type)
+	}
+}
+
+// KeyConfigFromEnv builds a KeyConfig from environment variables.
+//
+//	JWT_SECRET            single HS256 secret (kid "default")
+//	JWT_KEYS              comma separated "kid:alg:path" entries, path is a PEM file or secret file
+//	JWT_ACTIVE_KEY_ID     kid used for signing (defaults to the first key)
+//
+// It returns false when no key is configured.
+func KeyConfigFromEnv() (KeyConfig, bool, error) {
+	cfg := KeyConfig{ActiveKeyID: os.Getenv("JWT_ACTIVE_KEY_ID")}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		cfg.Keys = append(cfg.Keys, KeySpec{ID: "default", Algorithm: AlgHS256, Secret: secret})
+	}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return KeyConfig{}, false, errors.Newf("invalid JWT_KEYS entry %q, expected kid:alg:path", entry)
+		}
+		cfg.Keys = append(cfg.Keys, KeySpec{ID: parts[0], Algorithm: parts[1], KeyFile: parts[2]})
+	}
+
+	return cfg, len(cfg.Keys) > 0, nil
+}
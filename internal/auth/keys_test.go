@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeEd25519Key(t *testing.T, dir string) (string, string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	privPath := filepath.Join(dir, "ed.pem")
+	pubPath := filepath.Join(dir, "ed.pub.pem")
+	require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600))
+	require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o600))
+	return privPath, pubPath
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey := KeySpec{ID: "old", Algorithm: AlgHS256, Secret: "0123456789abcdef0123456789abcdef"}
+
+	before, err := NewKeySet(KeyConfig{Keys: []KeySpec{oldKey}})
+	require.NoError(t, err)
+	signed, err := before.Sign(jwt.MapClaims{"sid": "s1", "aud": AudienceAccess})
+	require.NoError(t, err)
+
+	// After rotation the new key signs while the old key still verifies
+	privPath, _ := writeEd25519Key(t, t.TempDir())
+	after, err := NewKeySet(KeyConfig{
+		ActiveKeyID: "new",
+		Keys:        []KeySpec{oldKey, {ID: "new", Algorithm: AlgEdDSA, KeyFile: privPath}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "new", after.ActiveKeyID())
+
+	token, err := after.Parse(signed, AudienceAccess, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "s1", token.Claims.(jwt.MapClaims)["sid"])
+
+	// The symmetric key is never published
+	jwks := after.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "new", jwks.Keys[0].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+}
+
+func TestKeySetPublicKeyOnlyVerifies(t *testing.T) {
+	privPath, pubPath := writeEd25519Key(t, t.TempDir())
+
+	signer, err := NewKeySet(KeyConfig{Keys: []KeySpec{{ID: "k1", Algorithm: AlgEdDSA, KeyFile: privPath}}})
+	require.NoError(t, err)
+	signed, err := signer.Sign(jwt.MapClaims{"sid": "s1", "aud": AudienceAccess})
+	require.NoError(t, err)
+
+	// A public key cannot be the active signing key
+	_, err = NewKeySet(KeyConfig{Keys: []KeySpec{{ID: "k1", Algorithm: AlgEdDSA, KeyFile: pubPath}}})
+	assert.Error(t, err)
+
+	verifier, err := NewKeySet(KeyConfig{
+		ActiveKeyID: "hs",
+		Keys: []KeySpec{
+			{ID: "hs", Algorithm: AlgHS256, Secret: "0123456789abcdef0123456789abcdef"},
+			{ID: "k1", Algorithm: AlgEdDSA, KeyFile: pubPath},
+		},
+	})
+	require.NoError(t, err)
+	_, err = verifier.Parse(signed, AudienceAccess, jwt.MapClaims{})
+	assert.NoError(t, err)
+}
+
+func TestKeySetRejectsAlgorithmMismatch(t *testing.T) {
+	ks, err := NewKeySet(KeyConfig{Keys: []KeySpec{{ID: "k1", Algorithm: AlgHS256, Secret: "0123456789abcdef0123456789abcdef"}}})
+	require.NoError(t, err)
+
+	// Same kid but signed with a different HMAC variant must not verify
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"aud": AudienceAccess})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	_, err = ks.Parse(signed, AudienceAccess, jwt.MapClaims{})
+	assert.Error(t, err)
+}
+
+func TestParseRequiresAudience(t *testing.T) {
+	ks, err := NewEphemeralKeySet()
+	require.NoError(t, err)
+	now := time.Now()
+	userID := uuid.New()
+
+	access, err := ks.Sign(NewAccessClaims(Principal{UserID: userID, SessionID: uuid.New()}, now, time.Minute))
+	require.NoError(t, err)
+	challenge, err := ks.Sign(NewMFAChallengeClaims(userID, now, time.Minute))
+	require.NoError(t, err)
+	flow, err := ks.Sign(NewOIDCFlowClaims("mock", "state", "nonce", "verifier", now, time.Minute))
+	require.NoError(t, err)
+	unscoped, err := ks.Sign(jwt.MapClaims{"sub": userID.String(), "sid": uuid.NewString()})
+	require.NoError(t, err)
+
+	_, err = ks.Parse(access, AudienceAccess, &Claims{})
+	assert.NoError(t, err)
+	_, err = ks.Parse(challenge, AudienceMFAChallenge, &MFAChallengeClaims{})
+	assert.NoError(t, err)
+	_, err = ks.Parse(flow, AudienceOIDCFlow, &OIDCFlowClaims{})
+	assert.NoError(t, err)
+
+	// 他の種類のトークンや audience の無いトークンはアクセストークンとして受け付けない
+	for _, signed := range []string{challenge, flow} {
+		_, err = ks.Parse(signed, AudienceAccess, &Claims{})
+		assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	}
+	_, err = ks.Parse(unscoped, AudienceAccess, &Claims{})
+	assert.ErrorIs(t, err, jwt.ErrTokenRequiredClaimMissing)
+	_, err = ks.Parse(access, AudienceMFAChallenge, &MFAChallengeClaims{})
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// AccessTokenTTL is the default lifetime of the JWT set in the "token" cookie
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is the default lifetime of a single refresh token
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// AccessTokenCookie is the cookie name holding the access token
+	AccessTokenCookie = "token"
+	// RefreshTokenCookie is the cookie name holding the refresh token
+	RefreshTokenCookie = "refresh_token"
+	// CSRFCookie is the cookie name holding the CSRF token. It is readable by scripts,
+	// which send it back in the CSRFHeader request header.
+	CSRFCookie = "csrf_token"
+	// CSRFHeader is the request header echoing the CSRF cookie
+	CSRFHeader = "X-CSRF-Token"
+	// HostCookiePrefix restricts a cookie to the host that set it over HTTPS
+	HostCookiePrefix = "__Host-"
+
+	// EmailVerificationTokenTTL is the default lifetime of an email verification link
+	EmailVerificationTokenTTL = 24 * time.Hour
+	// PasswordResetTokenTTL is the default lifetime of a password reset link
+	PasswordResetTokenTTL = time.Hour
+	// MFAChallengeTTL is the default time allowed for the second login step
+	MFAChallengeTTL = 5 * time.Minute
+	// OIDCFlowTTL is how long a player may stay at the OpenID Connect provider during login
+	OIDCFlowTTL = 10 * time.Minute
+	// OIDCFlowCookie is the cookie name holding the OpenID Connect login state
+	OIDCFlowCookie = "oidc_flow"
+
+	opaqueTokenBytes = 32
+)
+
+// NewOpaqueToken generates a random single-use token (refresh, email verification, ...)
+// and returns it together with the hash that should be stored in the database
+func NewOpaqueToken() (string, string, error) {
+	buf := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", errors.Wrap(err, "failed to generate token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hex encoded SHA-256 hash of a token from NewOpaqueToken.
+// The tokens are high entropy so a fast hash is sufficient here.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewCSRFToken generates a random token for the double-submit CSRF cookie
+func NewCSRFToken() (string, error) {
+	token, _, err := NewOpaqueToken()
+	return token, err
+}
+
+// NewRefreshToken generates a random opaque refresh token and returns it together
+// with the hash that should be stored in the database
+func NewRefreshToken() (string, string, error) {
+	return NewOpaqueToken()
+}
+
+// HashRefreshToken returns the hash of a refresh token as stored in the database
+func HashRefreshToken(token string) string {
+	return HashOpaqueToken(token)
+}
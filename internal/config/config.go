@@ -0,0 +1,995 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/i18n"
+	"github.com/my-deer/mydeer/internal/mail"
+	"github.com/my-deer/mydeer/internal/oidc"
+	"github.com/my-deer/mydeer/internal/passcheck"
+	"github.com/my-deer/mydeer/internal/passhash"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the application configuration.
+// Values are resolved in order: defaults, optional config file (YAML or TOML), environment variables.
+type Config struct {
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	Account  AccountConfig  `yaml:"account" toml:"account"`
+	Cookie   CookieConfig   `yaml:"cookie" toml:"cookie"`
+	// RateLimit configures the request rate limits of the routes
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Logging   LoggingConfig   `yaml:"logging" toml:"logging"`
+	I18n      I18nConfig      `yaml:"i18n" toml:"i18n"`
+	Mail      mail.Config     `yaml:"mail" toml:"mail"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// PublicURL is the externally reachable base URL used in links sent to players
+	PublicURL         string   `yaml:"public_url" toml:"public_url"`
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests may drain after SIGTERM
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// ReadinessTimeout bounds the dependency checks of GET /readyz
+	ReadinessTimeout Duration `yaml:"readiness_timeout" toml:"readiness_timeout"`
+	// TrustedProxies are the IPs or CIDRs of the reverse proxies whose X-Forwarded-For is used as
+	// the client IP (lockout, rate limits, logs). Empty trusts none and uses the peer address.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// DatabaseConfig configures the PostgreSQL connection.
+// URL takes precedence over the individual connection fields.
+type DatabaseConfig struct {
+	URL             string   `yaml:"url" toml:"url"`
+	Host            string   `yaml:"host" toml:"host"`
+	Port            int      `yaml:"port" toml:"port"`
+	User            string   `yaml:"user" toml:"user"`
+	Password        string   `yaml:"password" toml:"password"`
+	Name            string   `yaml:"name" toml:"name"`
+	SSLMode         string   `yaml:"sslmode" toml:"sslmode"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	AutoMigrate     bool     `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+// AuthConfig configures token lifetimes and JWT keys
+type AuthConfig struct {
+	AccessTokenTTL  Duration       `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	RefreshTokenTTL Duration       `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+	JWT             auth.KeyConfig `yaml:"jwt" toml:"jwt"`
+	// PasswordHash configures the Argon2id cost of new password hashes
+	PasswordHash PasswordHashConfig `yaml:"password_hash" toml:"password_hash"`
+	// PasswordPolicy configures the screening of new passwords
+	PasswordPolicy PasswordPolicyConfig `yaml:"password_policy" toml:"password_policy"`
+	// EmailVerification configures the signup email confirmation
+	EmailVerification EmailVerificationConfig `yaml:"email_verification" toml:"email_verification"`
+	// PasswordReset configures the forgotten password flow
+	PasswordReset PasswordResetConfig `yaml:"password_reset" toml:"password_reset"`
+	// Lockout configures the brute-force protection of login
+	Lockout LockoutConfig `yaml:"lockout" toml:"lockout"`
+	// MFA configures the optional TOTP two-factor authentication
+	MFA MFAConfig `yaml:"mfa" toml:"mfa"`
+	// OIDC configures single sign-on through OpenID Connect providers
+	OIDC OIDCConfig `yaml:"oidc" toml:"oidc"`
+	// EchoTokenHeader also returns the access token in the Authorization response header when a
+	// session starts or is refreshed, for clients that cannot use cookies
+	EchoTokenHeader bool `yaml:"echo_token_header" toml:"echo_token_header"`
+}
+
+// MetricsConfig configures the Prometheus metrics endpoint
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Path is served on server.addr; restrict it at the proxy if the server is public
+	Path string `yaml:"path" toml:"path"`
+}
+
+// Tracing exporters
+const (
+	// TracingExporterOTLP sends spans to an OpenTelemetry collector over OTLP/HTTP
+	TracingExporterOTLP = "otlp"
+	// TracingExporterStdout writes spans as JSON to stdout, for local debugging without a collector
+	TracingExporterStdout = "stdout"
+)
+
+// TracingConfig configures OpenTelemetry tracing. Incoming W3C traceparent headers are honoured
+// even when tracing is disabled, so the trace ids still reach the logs.
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Exporter is otlp or stdout
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the OTLP/HTTP endpoint URL, e.g. http://otel-collector:4318.
+	// Empty uses OTEL_EXPORTER_OTLP_ENDPOINT or the OpenTelemetry default.
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// SampleRatio is the share of new traces that are recorded, 0 to 1.
+	// Requests whose caller already sampled the trace are always recorded.
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+}
+
+// Log formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LoggingConfig configures the logger shared by the server and the request middleware
+type LoggingConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level" toml:"level"`
+	// Format is json or text
+	Format   string            `yaml:"format" toml:"format"`
+	Sampling LogSamplingConfig `yaml:"sampling" toml:"sampling"`
+	// TrustRequestID reuses the X-Request-ID header sent by the client or proxy instead of generating one
+	TrustRequestID bool `yaml:"trust_request_id" toml:"trust_request_id"`
+	// RedactKeys are attribute keys whose values are replaced, in addition to the built-in ones
+	// (password, token, secret, authorization, cookie and keys ending in _<one of them>)
+	RedactKeys []string `yaml:"redact_keys" toml:"redact_keys"`
+	// MaskKeys are attribute keys whose values are masked as email addresses, in addition to email
+	MaskKeys []string `yaml:"mask_keys" toml:"mask_keys"`
+}
+
+// LogSamplingConfig thins out repeated debug and info lines. Within each second the first Initial lines
+// with the same level and message are written, then every Thereafter-th one. Warnings and errors are never sampled.
+// Initial 0 disables sampling.
+type LogSamplingConfig struct {
+	Initial    int `yaml:"initial" toml:"initial"`
+	Thereafter int `yaml:"thereafter" toml:"thereafter"`
+}
+
+// Rate limit stores
+const (
+	// RateLimitStoreMemory keeps the counters in the process; each replica limits on its own
+	RateLimitStoreMemory = "memory"
+	// RateLimitStorePostgres keeps the counters in the database shared by all replicas
+	RateLimitStorePostgres = "postgres"
+)
+
+// RateLimitConfig configures request rate limiting. Every rule that matches a route is applied.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Store is where the counters are kept: memory or postgres
+	Store string `yaml:"store" toml:"store"`
+	// CleanupInterval is how often expired counters are deleted
+	CleanupInterval Duration        `yaml:"cleanup_interval" toml:"cleanup_interval"`
+	Rules           []RateLimitRule `yaml:"rules" toml:"rules"`
+}
+
+// RateLimitRule allows Limit requests per Window to Routes for each client of the By scope.
+// See ratelimit.Policy.
+type RateLimitRule struct {
+	// Name identifies the counters of the rule; renaming it resets them
+	Name string `yaml:"name" toml:"name"`
+	// Routes are "*" (every route), a path such as "/login" (every method) or a method and
+	// a path such as "POST /login". Paths are the registered patterns, e.g. "/players/:handle".
+	Routes []string `yaml:"routes" toml:"routes"`
+	Limit  int      `yaml:"limit" toml:"limit"`
+	Window Duration `yaml:"window" toml:"window"`
+	// By is ip, player (the authenticated player, else the client IP) or route (all clients together).
+	// The client IP is taken from X-Forwarded-For only behind server.trusted_proxies.
+	By string `yaml:"by" toml:"by"`
+}
+
+// PasswordHashConfig is the Argon2id cost. Stored hashes with other parameters
+// (or legacy bcrypt hashes) are replaced on the next successful login.
+type PasswordHashConfig struct {
+	// Memory in KiB
+	Memory      int `yaml:"memory" toml:"memory"`
+	Iterations  int `yaml:"iterations" toml:"iterations"`
+	Parallelism int `yaml:"parallelism" toml:"parallelism"`
+}
+
+// Params converts the configuration to passhash parameters
+func (p PasswordHashConfig) Params() passhash.Params {
+	return passhash.Params{
+		Memory:      uint32(p.Memory),
+		Iterations:  uint32(p.Iterations),
+		Parallelism: uint8(p.Parallelism),
+	}
+}
+
+// PasswordPolicyConfig configures which new passwords are accepted at signup, reset and change.
+// The bundled list of common passwords is always checked.
+type PasswordPolicyConfig struct {
+	// MinScore is the lowest accepted strength score, 0 (anything) to 4
+	MinScore int `yaml:"min_score" toml:"min_score"`
+	// BreachedList is an optional local copy of the Have I Been Pwned password hashes:
+	// a directory of k-anonymity range files or a single HASH:COUNT file (see passcheck.Open)
+	BreachedList string `yaml:"breached_list" toml:"breached_list"`
+	// MinBreachCount ignores entries of BreachedList seen fewer times
+	MinBreachCount int `yaml:"min_breach_count" toml:"min_breach_count"`
+}
+
+// EmailVerificationConfig configures the email verification flow
+type EmailVerificationConfig struct {
+	// Required makes login refuse accounts whose email is not verified yet
+	Required bool `yaml:"required" toml:"required"`
+	// TokenTTL is the lifetime of a verification link
+	TokenTTL Duration `yaml:"token_ttl" toml:"token_ttl"`
+	// ResendInterval is the minimum time between two verification emails to the same account
+	ResendInterval Duration `yaml:"resend_interval" toml:"resend_interval"`
+}
+
+// AccountConfig configures the deletion of accounts
+type AccountConfig struct {
+	// DeletionGracePeriod is how long a deleted account is kept; logging in within it cancels the deletion
+	DeletionGracePeriod Duration `yaml:"deletion_grace_period" toml:"deletion_grace_period"`
+	// PurgeInterval is how often the server deletes the accounts whose grace period ended.
+	// 0 disables the job, e.g. on all replicas but one.
+	PurgeInterval Duration `yaml:"purge_interval" toml:"purge_interval"`
+}
+
+// CookieConfig configures the auth cookies
+type CookieConfig struct {
+	Secure   bool   `yaml:"secure" toml:"secure"`
+	Domain   string `yaml:"domain" toml:"domain"`
+	SameSite string `yaml:"samesite" toml:"samesite"`
+	// HostPrefix names the session and CSRF cookies with the "__Host-" prefix, which browsers only accept
+	// from a secure origin without a Domain attribute, so that subdomains cannot overwrite them.
+	// The OpenID Connect flow cookie is scoped to the callback path and keeps its name.
+	HostPrefix bool `yaml:"host_prefix" toml:"host_prefix"`
+	// CSRF requires state-changing requests authenticated by cookie to echo the CSRF cookie
+	// in the X-CSRF-Token header. Requests with an Authorization header are not affected.
+	CSRF bool `yaml:"csrf" toml:"csrf"`
+}
+
+// I18nConfig configures the language of API messages
+type I18nConfig struct {
+	// DefaultLocale is used when neither the user nor Accept-Language selects a supported locale
+	DefaultLocale string `yaml:"default_locale" toml:"default_locale"`
+}
+
+// PasswordResetConfig configures the password reset flow
+type PasswordResetConfig struct {
+	// TokenTTL is the lifetime of a reset link
+	TokenTTL Duration `yaml:"token_ttl" toml:"token_ttl"`
+	// RequestInterval is the minimum time between two reset emails to the same account.
+	// Requests within the interval are silently ignored.
+	RequestInterval Duration `yaml:"request_interval" toml:"request_interval"`
+}
+
+// Login lockout stores
+const (
+	// LockoutStoreMemory keeps the failure counters in the process; each replica locks on its own
+	// and the mydeer lockout command cannot see them
+	LockoutStoreMemory = "memory"
+	// LockoutStorePostgres keeps the failure counters in the database shared by all replicas
+	LockoutStorePostgres = "postgres"
+)
+
+// LockoutConfig configures the temporary lockout after repeated failed logins
+type LockoutConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Store is where the failure counters are kept: memory or postgres
+	Store string `yaml:"store" toml:"store"`
+	// Account limits failures per email address
+	Account LockoutPolicy `yaml:"account" toml:"account"`
+	// IP limits failures per client IP across all accounts
+	IP LockoutPolicy `yaml:"ip" toml:"ip"`
+}
+
+// LockoutPolicy is the lockout policy of one kind of key. See lockout.Policy.
+type LockoutPolicy struct {
+	Threshold  int      `yaml:"threshold" toml:"threshold"`
+	BaseDelay  Duration `yaml:"base_delay" toml:"base_delay"`
+	MaxDelay   Duration `yaml:"max_delay" toml:"max_delay"`
+	ResetAfter Duration `yaml:"reset_after" toml:"reset_after"`
+}
+
+// MFAConfig configures TOTP two-factor authentication
+type MFAConfig struct {
+	// Issuer is the account issuer shown by authenticator apps
+	Issuer string `yaml:"issuer" toml:"issuer"`
+	// EncryptionKey is the base64 encoded 32 byte AES key encrypting the TOTP secrets at rest.
+	// When empty the secrets are stored unencrypted (development only).
+	EncryptionKey string `yaml:"encryption_key" toml:"encryption_key"`
+	// ChallengeTTL is how long the second login step may take after the password was accepted
+	ChallengeTTL Duration `yaml:"challenge_ttl" toml:"challenge_ttl"`
+}
+
+// Key decodes EncryptionKey. It returns nil when no key is configured.
+func (m MFAConfig) Key() ([]byte, error) {
+	if m.EncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(m.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("auth.mfa.encryption_key must be 32 bytes encoded in base64")
+	}
+	return key, nil
+}
+
+// providerNamePattern restricts provider names to what is safe in URLs
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// OIDCConfig configures the OpenID Connect providers offered for login.
+// The callback URL to register at a provider is <server.public_url>/auth/oidc/<name>/callback.
+type OIDCConfig struct {
+	Providers []oidc.ProviderConfig `yaml:"providers" toml:"providers"`
+}
+
+// Duration is a time.Duration that can be decoded from strings like "15m"
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the configuration used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			PublicURL:         "http://localhost:8080",
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(60 * time.Second),
+			ShutdownTimeout:   Duration(20 * time.Second),
+			ReadinessTimeout:  Duration(2 * time.Second),
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			Name:            "postgres",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: Duration(5 * time.Minute),
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  Duration(auth.AccessTokenTTL),
+			RefreshTokenTTL: Duration(auth.RefreshTokenTTL),
+			PasswordHash: PasswordHashConfig{
+				Memory:      int(passhash.DefaultParams.Memory),
+				Iterations:  int(passhash.DefaultParams.Iterations),
+				Parallelism: int(passhash.DefaultParams.Parallelism),
+			},
+			PasswordPolicy: PasswordPolicyConfig{
+				MinScore:       passcheck.DefaultMinScore,
+				MinBreachCount: 1,
+			},
+			EmailVerification: EmailVerificationConfig{
+				Required:       true,
+				TokenTTL:       Duration(auth.EmailVerificationTokenTTL),
+				ResendInterval: Duration(time.Minute),
+			},
+			PasswordReset: PasswordResetConfig{
+				TokenTTL:        Duration(auth.PasswordResetTokenTTL),
+				RequestInterval: Duration(time.Minute),
+			},
+			Lockout: LockoutConfig{
+				Enabled: true,
+				Store:   LockoutStorePostgres,
+				Account: LockoutPolicy{
+					Threshold:  5,
+					BaseDelay:  Duration(time.Second),
+					MaxDelay:   Duration(15 * time.Minute),
+					ResetAfter: Duration(time.Hour),
+				},
+				IP: LockoutPolicy{
+					Threshold:  20,
+					BaseDelay:  Duration(time.Second),
+					MaxDelay:   Duration(15 * time.Minute),
+					ResetAfter: Duration(time.Hour),
+				},
+			},
+			MFA: MFAConfig{
+				Issuer:       "mydeer",
+				ChallengeTTL: Duration(auth.MFAChallengeTTL),
+			},
+			EchoTokenHeader: true,
+		},
+		Account: AccountConfig{
+			DeletionGracePeriod: Duration(30 * 24 * time.Hour),
+			PurgeInterval:       Duration(time.Hour),
+		},
+		Cookie: CookieConfig{
+			Secure:   true,
+			SameSite: "lax",
+			CSRF:     true,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:         true,
+			Store:           RateLimitStoreMemory,
+			CleanupInterval: Duration(10 * time.Minute),
+			Rules: []RateLimitRule{
+				{Name: "auth", Routes: []string{"POST /login", "POST /login/mfa", "POST /signup"}, Limit: 20, Window: Duration(time.Minute), By: "ip"},
+				{Name: "email", Routes: []string{"POST /verify-email/resend", "POST /password/forgot"}, Limit: 5, Window: Duration(15 * time.Minute), By: "ip"},
+				{Name: "api", Routes: []string{"*"}, Limit: 300, Window: Duration(time.Minute), By: "player"},
+			},
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterOTLP,
+			SampleRatio: 1,
+			ServiceName: "mydeer",
+		},
+		Logging: LoggingConfig{
+			Level:          "info",
+			Format:         LogFormatJSON,
+			TrustRequestID: true,
+		},
+		I18n: I18nConfig{
+			DefaultLocale: string(i18n.Default),
+		},
+		Mail: mail.Config{
+			Driver: mail.DriverStdout,
+			From:   "mydeer <no-reply@localhost>",
+			SMTP: mail.SMTPConfig{
+				Port: 587,
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the optional file at path and the environment,
+// then validates it. An empty path falls back to the CONFIG_FILE environment variable.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "config: failed to read %s", path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, c)
+	case ".toml":
+		err = toml.Unmarshal(raw, c)
+	default:
+		return errors.Newf("config: unsupported file format %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "config: failed to parse %s", path)
+	}
+	return nil
+}
+
+// loadEnv overrides the configuration with environment variables
+func (c *Config) loadEnv() error {
+	var errs []error
+
+	setString(&c.Server.Addr, "SERVER_ADDR")
+	if port := os.Getenv("PORT"); port != "" && os.Getenv("SERVER_ADDR") == "" {
+		c.Server.Addr = ":" + port
+	}
+	setString(&c.Server.PublicURL, "PUBLIC_URL")
+
+	errs = append(errs,
+		setDuration(&c.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT"),
+		setDuration(&c.Server.ReadTimeout, "SERVER_READ_TIMEOUT"),
+		setDuration(&c.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT"),
+		setDuration(&c.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT"),
+		setDuration(&c.Server.ShutdownTimeout, "SERVER_SHUTDOWN_TIMEOUT"),
+		setDuration(&c.Server.ReadinessTimeout, "SERVER_READINESS_TIMEOUT"),
+	)
+	setList(&c.Server.TrustedProxies, "SERVER_TRUSTED_PROXIES")
+
+	setString(&c.Database.URL, "DATABASE_URL")
+	setString(&c.Database.Host, "POSTGRES_HOST")
+	errs = append(errs, setInt(&c.Database.Port, "POSTGRES_PORT"))
+	setString(&c.Database.User, "POSTGRES_USER")
+	setString(&c.Database.Password, "POSTGRES_PASSWORD")
+	setString(&c.Database.Name, "POSTGRES_DB")
+	setString(&c.Database.SSLMode, "POSTGRES_SSLMODE")
+	errs = append(errs,
+		setInt(&c.Database.MaxOpenConns, "DB_MAX_OPEN_CONNS"),
+		setInt(&c.Database.MaxIdleConns, "DB_MAX_IDLE_CONNS"),
+		setDuration(&c.Database.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME"),
+		setBool(&c.Database.AutoMigrate, "DB_AUTO_MIGRATE"),
+		setDuration(&c.Auth.AccessTokenTTL, "ACCESS_TOKEN_TTL"),
+		setDuration(&c.Auth.RefreshTokenTTL, "REFRESH_TOKEN_TTL"),
+		setInt(&c.Auth.PasswordHash.Memory, "PASSWORD_HASH_MEMORY"),
+		setInt(&c.Auth.PasswordHash.Iterations, "PASSWORD_HASH_ITERATIONS"),
+		setInt(&c.Auth.PasswordHash.Parallelism, "PASSWORD_HASH_PARALLELISM"),
+		setInt(&c.Auth.PasswordPolicy.MinScore, "PASSWORD_MIN_SCORE"),
+		setInt(&c.Auth.PasswordPolicy.MinBreachCount, "PASSWORD_MIN_BREACH_COUNT"),
+		setBool(&c.Auth.EmailVerification.Required, "EMAIL_VERIFICATION_REQUIRED"),
+		setDuration(&c.Auth.EmailVerification.TokenTTL, "EMAIL_VERIFICATION_TOKEN_TTL"),
+		setDuration(&c.Auth.EmailVerification.ResendInterval, "EMAIL_VERIFICATION_RESEND_INTERVAL"),
+		setDuration(&c.Auth.PasswordReset.TokenTTL, "PASSWORD_RESET_TOKEN_TTL"),
+		setDuration(&c.Auth.PasswordReset.RequestInterval, "PASSWORD_RESET_REQUEST_INTERVAL"),
+		setBool(&c.Auth.Lockout.Enabled, "LOGIN_LOCKOUT_ENABLED"),
+		setInt(&c.Auth.Lockout.Account.Threshold, "LOGIN_LOCKOUT_ACCOUNT_THRESHOLD"),
+		setInt(&c.Auth.Lockout.IP.Threshold, "LOGIN_LOCKOUT_IP_THRESHOLD"),
+		setDuration(&c.Auth.MFA.ChallengeTTL, "MFA_CHALLENGE_TTL"),
+		setDuration(&c.Account.DeletionGracePeriod, "ACCOUNT_DELETION_GRACE_PERIOD"),
+		setDuration(&c.Account.PurgeInterval, "ACCOUNT_PURGE_INTERVAL"),
+	)
+	setString(&c.Auth.PasswordPolicy.BreachedList, "PASSWORD_BREACHED_LIST")
+	setString(&c.Auth.Lockout.Store, "LOGIN_LOCKOUT_STORE")
+	setString(&c.Auth.MFA.Issuer, "MFA_ISSUER")
+	setString(&c.Auth.MFA.EncryptionKey, "MFA_ENCRYPTION_KEY")
+
+	keys, ok, err := auth.KeyConfigFromEnv()
+	errs = append(errs, err)
+	if ok {
+		c.Auth.JWT = keys
+	}
+
+	errs = append(errs,
+		setBool(&c.Auth.EchoTokenHeader, "AUTH_ECHO_TOKEN_HEADER"),
+		setBool(&c.Cookie.Secure, "COOKIE_SECURE"),
+		setBool(&c.Cookie.HostPrefix, "COOKIE_HOST_PREFIX"),
+		setBool(&c.Cookie.CSRF, "COOKIE_CSRF"),
+	)
+	errs = append(errs,
+		setBool(&c.RateLimit.Enabled, "RATE_LIMIT_ENABLED"),
+		setDuration(&c.RateLimit.CleanupInterval, "RATE_LIMIT_CLEANUP_INTERVAL"),
+	)
+	setString(&c.RateLimit.Store, "RATE_LIMIT_STORE")
+	errs = append(errs, setBool(&c.Metrics.Enabled, "METRICS_ENABLED"))
+	setString(&c.Metrics.Path, "METRICS_PATH")
+	errs = append(errs,
+		setBool(&c.Tracing.Enabled, "TRACING_ENABLED"),
+		setFloat(&c.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO"),
+	)
+	setString(&c.Tracing.Exporter, "TRACING_EXPORTER")
+	setString(&c.Tracing.Endpoint, "TRACING_ENDPOINT")
+	setString(&c.Tracing.ServiceName, "TRACING_SERVICE_NAME")
+	setString(&c.Logging.Level, "LOG_LEVEL")
+	setString(&c.Logging.Format, "LOG_FORMAT")
+	errs = append(errs,
+		setInt(&c.Logging.Sampling.Initial, "LOG_SAMPLING_INITIAL"),
+		setInt(&c.Logging.Sampling.Thereafter, "LOG_SAMPLING_THEREAFTER"),
+		setBool(&c.Logging.TrustRequestID, "LOG_TRUST_REQUEST_ID"),
+	)
+	setString(&c.Cookie.Domain, "COOKIE_DOMAIN")
+	setString(&c.Cookie.SameSite, "COOKIE_SAMESITE")
+	setString(&c.I18n.DefaultLocale, "DEFAULT_LOCALE")
+
+	setString(&c.Mail.Driver, "MAIL_DRIVER")
+	setString(&c.Mail.From, "MAIL_FROM")
+	setString(&c.Mail.Path, "MAIL_PATH")
+	setString(&c.Mail.SMTP.Host, "SMTP_HOST")
+	errs = append(errs, setInt(&c.Mail.SMTP.Port, "SMTP_PORT"))
+	setString(&c.Mail.SMTP.Username, "SMTP_USERNAME")
+	setString(&c.Mail.SMTP.Password, "SMTP_PASSWORD")
+
+	return errors.Join(errs...)
+}
+
+// Validate checks the configuration and reports every problem found
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+	if u, err := url.Parse(c.Server.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("server.public_url must be an absolute URL, got %q", c.Server.PublicURL))
+	}
+	for _, t := range []struct {
+		name  string
+		value Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"server.readiness_timeout", c.Server.ReadinessTimeout},
+	} {
+		if t.value <= 0 {
+			problems = append(problems, t.name+" must be positive")
+		}
+	}
+	for i, proxy := range c.Server.TrustedProxies {
+		if !validProxy(proxy) {
+			problems = append(problems, fmt.Sprintf("server.trusted_proxies[%d] must be an IP or a CIDR, got %q", i, proxy))
+		}
+	}
+
+	if c.Database.URL != "" {
+		if _, err := url.Parse(c.Database.URL); err != nil {
+			problems = append(problems, fmt.Sprintf("database.url is invalid: %v", err))
+		}
+	} else {
+		if c.Database.Host == "" {
+			problems = append(problems, "database.host is required")
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			problems = append(problems, fmt.Sprintf("database.port must be between 1 and 65535, got %d", c.Database.Port))
+		}
+		if c.Database.User == "" {
+			problems = append(problems, "database.user is required")
+		}
+		if c.Database.Name == "" {
+			problems = append(problems, "database.name is required")
+		}
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database connection pool sizes must not be negative")
+	}
+
+	if c.Auth.AccessTokenTTL <= 0 {
+		problems = append(problems, "auth.access_token_ttl must be positive")
+	}
+	if c.Auth.RefreshTokenTTL <= c.Auth.AccessTokenTTL {
+		problems = append(problems, "auth.refresh_token_ttl must be longer than auth.access_token_ttl")
+	}
+
+	if ph := c.Auth.PasswordHash; ph.Iterations < 1 || ph.Parallelism < 1 || ph.Parallelism > 255 || ph.Memory < 8*ph.Parallelism || ph.Memory > 4*1024*1024 {
+		problems = append(problems, "auth.password_hash needs iterations >= 1, parallelism 1-255 and 8*parallelism <= memory (KiB) <= 4194304")
+	}
+
+	if pp := c.Auth.PasswordPolicy; pp.MinScore < 0 || pp.MinScore > 4 {
+		problems = append(problems, fmt.Sprintf("auth.password_policy.min_score must be between 0 and 4, got %d", pp.MinScore))
+	}
+	if c.Auth.PasswordPolicy.MinBreachCount < 1 {
+		problems = append(problems, "auth.password_policy.min_breach_count must be at least 1")
+	}
+
+	if c.Auth.EmailVerification.TokenTTL <= 0 {
+		problems = append(problems, "auth.email_verification.token_ttl must be positive")
+	}
+	if c.Auth.EmailVerification.ResendInterval < 0 {
+		problems = append(problems, "auth.email_verification.resend_interval must not be negative")
+	}
+	if c.Auth.PasswordReset.TokenTTL <= 0 {
+		problems = append(problems, "auth.password_reset.token_ttl must be positive")
+	}
+	if c.Auth.PasswordReset.RequestInterval < 0 {
+		problems = append(problems, "auth.password_reset.request_interval must not be negative")
+	}
+	if c.Auth.Lockout.Store != LockoutStoreMemory && c.Auth.Lockout.Store != LockoutStorePostgres {
+		problems = append(problems, fmt.Sprintf("auth.lockout.store must be memory or postgres, got %q", c.Auth.Lockout.Store))
+	}
+	if c.Auth.Lockout.Enabled {
+		for _, p := range []struct {
+			name   string
+			policy LockoutPolicy
+		}{
+			{"account", c.Auth.Lockout.Account},
+			{"ip", c.Auth.Lockout.IP},
+		} {
+			if p.policy.Threshold < 0 {
+				problems = append(problems, fmt.Sprintf("auth.lockout.%s.threshold must not be negative", p.name))
+			}
+			if p.policy.Threshold > 0 && (p.policy.BaseDelay <= 0 || p.policy.MaxDelay < p.policy.BaseDelay || p.policy.ResetAfter <= 0) {
+				problems = append(problems, fmt.Sprintf("auth.lockout.%s needs 0 < base_delay <= max_delay and a positive reset_after", p.name))
+			}
+		}
+	}
+
+	if c.Auth.MFA.Issuer == "" {
+		problems = append(problems, "auth.mfa.issuer is required")
+	}
+	if c.Auth.MFA.ChallengeTTL <= 0 {
+		problems = append(problems, "auth.mfa.challenge_ttl must be positive")
+	}
+	if _, err := c.Auth.MFA.Key(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	seen := make(map[string]bool, len(c.Auth.OIDC.Providers))
+	for i, p := range c.Auth.OIDC.Providers {
+		if !providerNamePattern.MatchString(p.Name) {
+			problems = append(problems, fmt.Sprintf("auth.oidc.providers[%d].name must match %s, got %q", i, providerNamePattern, p.Name))
+		} else if seen[p.Name] {
+			problems = append(problems, fmt.Sprintf("auth.oidc.providers[%d].name %q is duplicated", i, p.Name))
+		}
+		seen[p.Name] = true
+		if u, err := url.Parse(p.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("auth.oidc.providers[%d].issuer must be an absolute URL, got %q", i, p.Issuer))
+		}
+		if p.ClientID == "" {
+			problems = append(problems, fmt.Sprintf("auth.oidc.providers[%d].client_id is required", i))
+		}
+	}
+
+	if c.Account.DeletionGracePeriod < 0 {
+		problems = append(problems, "account.deletion_grace_period must not be negative")
+	}
+	if c.Account.PurgeInterval < 0 {
+		problems = append(problems, "account.purge_interval must not be negative")
+	}
+
+	if _, err := c.Cookie.SameSiteMode(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Cookie.SameSite == "none" && !c.Cookie.Secure {
+		problems = append(problems, "cookie.samesite=none requires cookie.secure=true")
+	}
+	if c.Cookie.HostPrefix && (!c.Cookie.Secure || c.Cookie.Domain != "") {
+		problems = append(problems, "cookie.host_prefix requires cookie.secure=true and an empty cookie.domain")
+	}
+
+	if c.RateLimit.Enabled {
+		problems = append(problems, c.RateLimit.validate()...)
+	}
+
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		problems = append(problems, fmt.Sprintf("metrics.path must start with /, got %q", c.Metrics.Path))
+	}
+
+	if c.Tracing.Enabled {
+		if c.Tracing.Exporter != TracingExporterOTLP && c.Tracing.Exporter != TracingExporterStdout {
+			problems = append(problems, fmt.Sprintf("tracing.exporter must be otlp or stdout, got %q", c.Tracing.Exporter))
+		}
+		if c.Tracing.Endpoint != "" {
+			if u, err := url.Parse(c.Tracing.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("tracing.endpoint must be an absolute URL, got %q", c.Tracing.Endpoint))
+			}
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			problems = append(problems, fmt.Sprintf("tracing.sample_ratio must be between 0 and 1, got %v", c.Tracing.SampleRatio))
+		}
+		if c.Tracing.ServiceName == "" {
+			problems = append(problems, "tracing.service_name is required")
+		}
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level))
+	}
+	if c.Logging.Format != LogFormatJSON && c.Logging.Format != LogFormatText {
+		problems = append(problems, fmt.Sprintf("logging.format must be json or text, got %q", c.Logging.Format))
+	}
+	if c.Logging.Sampling.Initial < 0 || c.Logging.Sampling.Thereafter < 0 {
+		problems = append(problems, "logging.sampling.initial and logging.sampling.thereafter must not be negative")
+	}
+
+	if _, err := c.I18n.Locale(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	switch c.Mail.Driver {
+	case mail.DriverSMTP:
+		if c.Mail.SMTP.Host == "" {
+			problems = append(problems, "mail.smtp.host is required with mail.driver=smtp")
+		}
+	case mail.DriverFile:
+		if c.Mail.Path == "" {
+			problems = append(problems, "mail.path is required with mail.driver=file")
+		}
+	case mail.DriverStdout:
+	default:
+		problems = append(problems, fmt.Sprintf("mail.driver must be one of smtp, file, stdout, got %q", c.Mail.Driver))
+	}
+	if c.Mail.From == "" {
+		problems = append(problems, "mail.from is required")
+	}
+
+	if len(problems) > 0 {
+		return errors.Newf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func (r RateLimitConfig) validate() []string {
+	var problems []string
+	if r.Store != RateLimitStoreMemory && r.Store != RateLimitStorePostgres {
+		problems = append(problems, fmt.Sprintf("rate_limit.store must be memory or postgres, got %q", r.Store))
+	}
+	if r.CleanupInterval <= 0 {
+		problems = append(problems, "rate_limit.cleanup_interval must be positive")
+	}
+	seen := make(map[string]bool, len(r.Rules))
+	for i, rule := range r.Rules {
+		if rule.Name == "" {
+			problems = append(problems, fmt.Sprintf("rate_limit.rules[%d].name is required", i))
+		} else if seen[rule.Name] {
+			problems = append(problems, fmt.Sprintf("rate_limit.rules[%d].name %q is duplicated", i, rule.Name))
+		}
+		seen[rule.Name] = true
+		if len(rule.Routes) == 0 {
+			problems = append(problems, fmt.Sprintf("rate_limit.rules[%d].routes is required", i))
+		}
+		for _, route := range rule.Routes {
+			if !validRateLimitRoute(route) {
+				problems = append(problems, fmt.Sprintf("rate_limit.rules[%d].routes: %q must be *, a path or a method and a path", i, route))
+			}
+		}
+		if rule.Limit < 1 || rule.Window < Duration(time.Second) {
+			problems = append(problems, fmt.Sprintf("rate_limit.rules[%d] needs limit >= 1 and window >= 1s", i))
+		}
+		if rule.By != "ip" && rule.By != "player" && rule.By != "route" {
+			problems = append(problems, fmt.Sprintf("rate_limit.rules[%d].by must be ip, player or route, got %q", i, rule.By))
+		}
+	}
+	return problems
+}
+
+// validProxy checks the forms accepted by gin's SetTrustedProxies
+func validProxy(proxy string) bool {
+	if strings.Contains(proxy, "/") {
+		_, _, err := net.ParseCIDR(proxy)
+		return err == nil
+	}
+	return net.ParseIP(proxy) != nil
+}
+
+// validRateLimitRoute checks the route forms accepted by ratelimit.Routes
+func validRateLimitRoute(route string) bool {
+	fields := strings.Fields(route)
+	switch len(fields) {
+	case 1:
+		return fields[0] == "*" || strings.HasPrefix(fields[0], "/")
+	case 2:
+		return strings.HasPrefix(fields[1], "/")
+	}
+	return false
+}
+
+// DSN returns the PostgreSQL connection string
+func (d DatabaseConfig) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     fmt.Sprintf("%s:%d", d.Host, d.Port),
+		Path:     d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// Name returns the name of the cookie, with the "__Host-" prefix when HostPrefix is set
+func (c CookieConfig) Name(name string) string {
+	if c.HostPrefix {
+		return auth.HostCookiePrefix + name
+	}
+	return name
+}
+
+// SameSiteMode converts the configured SameSite value to http.SameSite
+func (c CookieConfig) SameSiteMode() (http.SameSite, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return http.SameSiteDefaultMode, errors.Newf("cookie.samesite must be one of lax, strict, none, got %q", c.SameSite)
+	}
+}
+
+// Locale returns the configured default locale
+func (c I18nConfig) Locale() (i18n.Locale, error) {
+	loc, ok := i18n.Parse(c.DefaultLocale)
+	if !ok {
+		return "", errors.Newf("i18n.default_locale must be one of %v, got %q", i18n.Supported, c.DefaultLocale)
+	}
+	return loc, nil
+}
+
+func setString(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		*dst = v
+	}
+}
+
+// setList sets dst from a comma-separated environment variable
+func setList(dst *[]string, key string) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+}
+
+func setInt(dst *int, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.Newf("config: %s must be an integer, got %q", key, v)
+	}
+	*dst = n
+	return nil
+}
+
+func setFloat(dst *float64, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return errors.Newf("config: %s must be a number, got %q", key, v)
+	}
+	*dst = f
+	return nil
+}
+
+func setBool(dst *bool, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.Newf("config: %s must be a boolean, got %q", key, v)
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *Duration, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return errors.Newf("config: %s must be a duration like 15m, got %q", key, v)
+	}
+	*dst = Duration(d)
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+)
+
+// CreateAuditLogParams contains the parameters for recording an operation on an account
+type CreateAuditLogParams struct {
+	UserID uuid.UUID
+	// ActorID is the account that performed the operation, uuid.Nil for the system
+	ActorID   uuid.UUID
+	Action    string
+	IPAddress string
+	UserAgent string
+}
+
+// CreateAuditLog records an operation on an account
+func (d *DB) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) (AuditLog, error) {
+	entry := &AuditLog{
+		UserID:    arg.UserID,
+		ActorID:   uuid.NullUUID{UUID: arg.ActorID, Valid: arg.ActorID != uuid.Nil},
+		Action:    arg.Action,
+		IPAddress: arg.IPAddress,
+		UserAgent: arg.UserAgent,
+	}
+
+	_, err := d.conn(ctx).NewInsert().Model(entry).Returning("*").Exec(ctx)
+	if err != nil {
+		return AuditLog{}, errors.Wrapf(err, "failed to create audit log: %s", arg.Action)
+	}
+
+	return *entry, nil
+}
+
+// ListAuditLogs returns the audit log of the user, oldest first
+func (d *DB) ListAuditLogs(ctx context.Context, userID uuid.UUID) ([]AuditLog, error) {
+	var entries []AuditLog
+	err := d.conn(ctx).NewSelect().
+		Model(&entries).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list audit logs of user: %s", userID)
+	}
+	return entries, nil
+}
+
+// AnonymizeAuditLogs clears the client IP and user agent of every audit log entry of the user,
+// including the entries where the user acted on another account
+func (d *DB) AnonymizeAuditLogs(ctx context.Context, userID uuid.UUID) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*AuditLog)(nil)).
+		Set("ip_address = ''").
+		Set("user_agent = ''").
+		Where("user_id = ? OR actor_id = ?", userID, userID).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to anonymize audit logs of user: %s", userID)
+	}
+	return nil
+}
@@ -1,8 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"net/http"
+	"time"
 
+	_ "github.com/lib/pq"
+	"github.com/my-deer/mydeer/internal/config"
 	"github.com/my-deer/mydeer/internal/errors"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
@@ -20,16 +25,39 @@ func New(sqlDB *sql.DB) *DB {
 	// Register models
 	bunDB.RegisterModel((*UserTable)(nil))
 
+	// Record a span for every query (a no-op until tracing is set up)
+	bunDB.AddQueryHook(queryTracer{})
+
 	return &DB{
 		db: bunDB,
 	}
 }
 
-// Begin starts a new transaction
-func (d *DB) Begin() (bun.Tx, error) {
-	tx, err := d.db.Begin()
+// Open connects to PostgreSQL using the database configuration and applies the pool settings
+func Open(cfg config.DatabaseConfig) (*DB, error) {
+	sqlDB, err := sql.Open("postgres", cfg.DSN())
 	if err != nil {
-		return bun.Tx{}, errors.Wrap(err, "failed to begin transaction", "Internal error", 500)
+		return nil, errors.Wrap(err, errors.ErrDBConnection, "Failed to open database", http.StatusInternalServerError)
 	}
-	return tx, nil
+
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+
+	return New(sqlDB), nil
+}
+
+// Close closes the underlying database connection
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// SQL returns the underlying connection pool, e.g. to export its statistics
+func (d *DB) SQL() *sql.DB {
+	return d.db.DB
+}
+
+// Ping verifies the database connection is alive
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
 }
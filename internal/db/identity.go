@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+)
+
+// CreateUserIdentityParams contains the parameters for linking a provider account to a user
+type CreateUserIdentityParams struct {
+	UserID   uuid.UUID
+	Provider string
+	Subject  string
+	Email    string
+}
+
+// GetUserIdentity returns the link of the provider account identified by subject
+func (d *DB) GetUserIdentity(ctx context.Context, provider, subject string) (UserIdentity, error) {
+	var identity UserIdentity
+	err := d.conn(ctx).NewSelect().
+		Model(&identity).
+		Where("provider = ?", provider).
+		Where("subject = ?", subject).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UserIdentity{}, errors.Wrapf(err, "identity not found: %s", provider)
+		}
+		return UserIdentity{}, errors.Wrapf(err, "failed to get identity: %s", provider)
+	}
+
+	return identity, nil
+}
+
+// CreateUserIdentity links a provider account to the user.
+// A provider account can be linked to only one user (unique provider and subject).
+func (d *DB) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	identity := &UserIdentity{
+		UserID:   arg.UserID,
+		Provider: arg.Provider,
+		Subject:  arg.Subject,
+		Email:    arg.Email,
+	}
+
+	_, err := d.conn(ctx).NewInsert().Model(identity).Returning("*").Exec(ctx)
+	if err != nil {
+		return UserIdentity{}, errors.Wrapf(err, "failed to create identity: %s", arg.Provider)
+	}
+
+	return *identity, nil
+}
+
+// ListUserIdentities returns the provider accounts linked to the user, oldest first
+func (d *DB) ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]UserIdentity, error) {
+	var identities []UserIdentity
+	err := d.conn(ctx).NewSelect().
+		Model(&identities).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list identities of user: %s", userID)
+	}
+	return identities, nil
+}
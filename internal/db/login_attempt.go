@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// GetLoginAttempt returns the failure counter of the key
+func (d *DB) GetLoginAttempt(ctx context.Context, key string) (LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := d.conn(ctx).NewSelect().
+		Model(&attempt).
+		Where("key = ?", key).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return LoginAttempt{}, errors.Wrapf(err, "login attempt not found: %s", key)
+		}
+		return LoginAttempt{}, errors.Wrapf(err, "failed to get login attempt: %s", key)
+	}
+
+	return attempt, nil
+}
+
+// IncrementLoginFailures records a failed login for the key and returns the updated counter.
+// Counters whose last failure is before resetBefore start again from 1.
+func (d *DB) IncrementLoginFailures(ctx context.Context, key string, now, resetBefore time.Time) (LoginAttempt, error) {
+	attempt := &LoginAttempt{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: now,
+	}
+
+	// 同時の失敗でも取りこぼさないようUPSERTで加算する
+	_, err := d.conn(ctx).NewInsert().
+		Model(attempt).
+		On("CONFLICT (key) DO UPDATE").
+		Set("failures = CASE WHEN la.last_failure_at < ? THEN 1 ELSE la.failures + 1 END", resetBefore).
+		Set("locked_until = CASE WHEN la.last_failure_at < ? THEN NULL ELSE la.locked_until END", resetBefore).
+		Set("last_failure_at = EXCLUDED.last_failure_at").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return LoginAttempt{}, errors.Wrapf(err, "failed to record login failure: %s", key)
+	}
+
+	return *attempt, nil
+}
+
+// SetLoginLockout locks the key until the given time
+func (d *DB) SetLoginLockout(ctx context.Context, key string, until time.Time) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*LoginAttempt)(nil)).
+		Set("locked_until = ?", until).
+		Where("key = ?", key).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to lock login: %s", key)
+	}
+	return nil
+}
+
+// DeleteLoginAttempt forgets the failures of the key, lifting any lockout
+func (d *DB) DeleteLoginAttempt(ctx context.Context, key string) error {
+	_, err := d.conn(ctx).NewDelete().
+		Model((*LoginAttempt)(nil)).
+		Where("key = ?", key).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete login attempt: %s", key)
+	}
+	return nil
+}
+
+// ListLoginLockouts returns every key that is locked at now, longest lockout first
+func (d *DB) ListLoginLockouts(ctx context.Context, now time.Time) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := d.conn(ctx).NewSelect().
+		Model(&attempts).
+		Where("locked_until > ?", now).
+		Order("locked_until DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list login lockouts")
+	}
+	return attempts, nil
+}
@@ -0,0 +1,1074 @@
+// Package memory provides in-memory implementations of the db repositories
+// for handler tests that should run without PostgreSQL.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+)
+
+// Store keeps every table in maps guarded by a single mutex
+type Store struct {
+	mu            sync.Mutex
+	users         map[uuid.UUID]db.User
+	sessions      map[uuid.UUID]db.Session
+	userTokens    map[uuid.UUID]db.UserToken
+	loginAttempts map[string]db.LoginAttempt
+	rateLimits    map[rateLimitWindow]db.RateLimitCounter
+	mfa           map[uuid.UUID]db.UserMFA
+	recoveryCodes map[uuid.UUID]db.MFARecoveryCode
+	identities    map[uuid.UUID]db.UserIdentity
+	profiles      map[uuid.UUID]db.PlayerProfile
+	auditLogs     map[uuid.UUID]db.AuditLog
+	userRoles     map[uuid.UUID][]db.UserRole
+	// roles と rolePermissions はマイグレーションで投入される固定データなので変更しない
+	roles           []db.Role
+	rolePermissions []db.RolePermission
+}
+
+var (
+	_ db.UserRepository         = (*Store)(nil)
+	_ db.SessionRepository      = (*Store)(nil)
+	_ db.UserTokenRepository    = (*Store)(nil)
+	_ db.LoginAttemptRepository = (*Store)(nil)
+	_ db.RateLimitRepository    = (*Store)(nil)
+	_ db.MFARepository          = (*Store)(nil)
+	_ db.IdentityRepository     = (*Store)(nil)
+	_ db.ProfileRepository      = (*Store)(nil)
+	_ db.AuditLogRepository     = (*Store)(nil)
+	_ db.RoleRepository         = (*Store)(nil)
+	_ db.TxRunner               = (*Store)(nil)
+)
+
+// New creates an empty Store with the roles seeded by the migrations
+func New() *Store {
+	return &Store{
+		users:         make(map[uuid.UUID]db.User),
+		sessions:      make(map[uuid.UUID]db.Session),
+		userTokens:    make(map[uuid.UUID]db.UserToken),
+		loginAttempts: make(map[string]db.LoginAttempt),
+		rateLimits:    make(map[rateLimitWindow]db.RateLimitCounter),
+		mfa:           make(map[uuid.UUID]db.UserMFA),
+		recoveryCodes: make(map[uuid.UUID]db.MFARecoveryCode),
+		identities:    make(map[uuid.UUID]db.UserIdentity),
+		profiles:      make(map[uuid.UUID]db.PlayerProfile),
+		auditLogs:     make(map[uuid.UUID]db.AuditLog),
+		userRoles:     make(map[uuid.UUID][]db.UserRole),
+		roles: []db.Role{
+			{Name: "admin", Description: "モデレーターの権限に加えてロールの変更"},
+			{Name: "moderator", Description: "プレイヤーの検索・利用停止・強制ログアウト"},
+			{Name: "player", Description: "すべてのプレイヤー"},
+		},
+		rolePermissions: []db.RolePermission{
+			{Role: "admin", Permission: "roles:assign"},
+			{Role: "admin", Permission: "sessions:revoke"},
+			{Role: "admin", Permission: "users:read"},
+			{Role: "admin", Permission: "users:suspend"},
+			{Role: "moderator", Permission: "sessions:revoke"},
+			{Role: "moderator", Permission: "users:read"},
+			{Role: "moderator", Permission: "users:suspend"},
+		},
+	}
+}
+
+// RunInTx runs fn and restores the previous state of the store when it returns an error.
+// Nested calls behave like savepoints. Unlike PostgreSQL there is no isolation from
+// concurrent callers, which is fine for handler tests.
+func (s *Store) RunInTx(ctx context.Context, fn func(ctx context.Context) error, opts ...db.TxOption) error {
+	s.mu.Lock()
+	users := maps.Clone(s.users)
+	sessions := maps.Clone(s.sessions)
+	userTokens := maps.Clone(s.userTokens)
+	loginAttempts := maps.Clone(s.loginAttempts)
+	rateLimits := maps.Clone(s.rateLimits)
+	mfa := maps.Clone(s.mfa)
+	recoveryCodes := maps.Clone(s.recoveryCodes)
+	identities := maps.Clone(s.identities)
+	profiles := maps.Clone(s.profiles)
+	auditLogs := maps.Clone(s.auditLogs)
+	userRoles := maps.Clone(s.userRoles)
+	s.mu.Unlock()
+
+	if err := fn(ctx); err != nil {
+		s.mu.Lock()
+		s.users, s.sessions, s.userTokens, s.loginAttempts = users, sessions, userTokens, loginAttempts
+		s.mfa, s.recoveryCodes, s.identities, s.profiles, s.auditLogs = mfa, recoveryCodes, identities, profiles, auditLogs
+		s.userRoles, s.rateLimits = userRoles, rateLimits
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// uniqueViolation reproduces the error PostgreSQL returns for a unique constraint,
+// mapped the same way the bun implementation maps it
+func uniqueViolation(constraint string) error {
+	return apperrors.WrapDBError(&pq.Error{
+		Code:       "23505",
+		Message:    "duplicate key value violates unique constraint \"" + constraint + "\"",
+		Constraint: constraint,
+	})
+}
+
+func nullNow() sql.NullTime {
+	return sql.NullTime{Time: time.Now(), Valid: true}
+}
+
+// CreateUser stores a new user. Emails are unique like the users_email_key constraint.
+func (s *Store) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.CreateUserRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == arg.Email {
+			return db.CreateUserRow{}, uniqueViolation("users_email_key")
+		}
+	}
+
+	user := db.User{
+		ID:        uuid.New(),
+		Email:     arg.Email,
+		Password:  arg.Password,
+		Name:      arg.Name,
+		Locale:    arg.Locale,
+		CreatedAt: nullNow(),
+		UpdatedAt: nullNow(),
+	}
+	s.users[user.ID] = user
+
+	return db.CreateUserRow{
+		ID:        user.ID,
+		Email:     user.Email,
+		Name:      user.Name,
+		Locale:    user.Locale,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}, nil
+}
+
+// GetUserByEmail returns a user by email
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return db.User{}, errors.Wrapf(sql.ErrNoRows, "user not found by email: %s", email)
+}
+
+// GetUserByID returns a user by id
+func (s *Store) GetUserByID(ctx context.Context, id uuid.UUID) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return db.User{}, errors.Wrapf(sql.ErrNoRows, "user not found by id: %s", id)
+	}
+	return u, nil
+}
+
+// MarkEmailVerified records that the user verified the email address
+func (s *Store) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	if !u.EmailVerifiedAt.Valid {
+		u.EmailVerifiedAt = nullNow()
+	}
+	u.UpdatedAt = nullNow()
+	s.users[id] = u
+	return nil
+}
+
+// UpdatePassword replaces the password hash of the user
+func (s *Store) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	u.Password = passwordHash
+	u.UpdatedAt = nullNow()
+	s.users[id] = u
+	return nil
+}
+
+// ScheduleUserDeletion marks the user for deletion at the given time
+func (s *Store) ScheduleUserDeletion(ctx context.Context, id uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	u.DeleteAfter = sql.NullTime{Time: at, Valid: true}
+	u.UpdatedAt = nullNow()
+	s.users[id] = u
+	return nil
+}
+
+// CancelUserDeletion clears a scheduled deletion and reports whether there was one
+func (s *Store) CancelUserDeletion(ctx context.Context, id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || !u.DeleteAfter.Valid {
+		return false, nil
+	}
+	u.DeleteAfter = sql.NullTime{}
+	u.UpdatedAt = nullNow()
+	s.users[id] = u
+	return true, nil
+}
+
+// ListUsersDueForDeletion returns up to limit users whose deletion is scheduled at or before now,
+// oldest first
+func (s *Store) ListUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []db.User
+	for _, u := range s.users {
+		if u.DeleteAfter.Valid && !u.DeleteAfter.Time.After(now) {
+			due = append(due, u)
+		}
+	}
+	slices.SortFunc(due, func(a, b db.User) int {
+		return a.DeleteAfter.Time.Compare(b.DeleteAfter.Time)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// DeleteUserDueForDeletion deletes the user and, like ON DELETE CASCADE, every row referencing it,
+// if its deletion is still scheduled at or before now. It reports whether the user was deleted.
+func (s *Store) DeleteUserDueForDeletion(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || !u.DeleteAfter.Valid || u.DeleteAfter.Time.After(now) {
+		return false, nil
+	}
+	delete(s.users, id)
+	delete(s.mfa, id)
+	delete(s.profiles, id)
+	delete(s.userRoles, id)
+	s.deleteRecoveryCodesLocked(id)
+	maps.DeleteFunc(s.sessions, func(_ uuid.UUID, session db.Session) bool { return session.UserID == id })
+	maps.DeleteFunc(s.userTokens, func(_ uuid.UUID, token db.UserToken) bool { return token.UserID == id })
+	maps.DeleteFunc(s.identities, func(_ uuid.UUID, identity db.UserIdentity) bool { return identity.UserID == id })
+	return true, nil
+}
+
+// SuspendUser marks the user as suspended and reports whether it was not suspended before
+func (s *Store) SuspendUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || u.SuspendedAt.Valid {
+		return false, nil
+	}
+	u.SuspendedAt = nullNow()
+	u.UpdatedAt = nullNow()
+	s.users[id] = u
+	return true, nil
+}
+
+// UnsuspendUser lifts the suspension of the user and reports whether it was suspended
+func (s *Store) UnsuspendUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || !u.SuspendedAt.Valid {
+		return false, nil
+	}
+	u.SuspendedAt = sql.NullTime{}
+	u.UpdatedAt = nullNow()
+	s.users[id] = u
+	return true, nil
+}
+
+// SearchUsers returns a page of the users whose email, name or handle contains the query
+// case-insensitively, oldest first, and the number of matches
+func (s *Store) SearchUsers(ctx context.Context, arg db.SearchUsersParams) ([]db.UserSearchRow, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := strings.ToLower(arg.Query)
+	rows := []db.UserSearchRow{}
+	for _, u := range s.users {
+		row := db.UserSearchRow{User: u, Handle: s.profiles[u.ID].Handle}
+		if query == "" ||
+			strings.Contains(strings.ToLower(u.Email), query) ||
+			strings.Contains(strings.ToLower(u.Name), query) ||
+			strings.Contains(strings.ToLower(row.Handle), query) {
+			rows = append(rows, row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b db.UserSearchRow) int {
+		if c := a.CreatedAt.Time.Compare(b.CreatedAt.Time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+
+	total := len(rows)
+	rows = rows[min(arg.Offset, total):]
+	if arg.Limit > 0 && len(rows) > arg.Limit {
+		rows = rows[:arg.Limit]
+	}
+	return rows, total, nil
+}
+
+// CreateSession stores a new refresh token for the given session family
+func (s *Store) CreateSession(ctx context.Context, arg db.CreateSessionParams) (db.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createSessionLocked(arg)
+}
+
+func (s *Store) createSessionLocked(arg db.CreateSessionParams) (db.Session, error) {
+	if _, ok := s.users[arg.UserID]; !ok {
+		return db.Session{}, apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "sessions_user_id_fkey"})
+	}
+	for _, existing := range s.sessions {
+		if existing.TokenHash == arg.TokenHash {
+			return db.Session{}, uniqueViolation("sessions_token_hash_key")
+		}
+	}
+
+	session := db.Session{
+		ID:        uuid.New(),
+		FamilyID:  arg.FamilyID,
+		UserID:    arg.UserID,
+		TokenHash: arg.TokenHash,
+		UserAgent: arg.UserAgent,
+		IPAddress: arg.IPAddress,
+		ExpiresAt: arg.ExpiresAt,
+		CreatedAt: nullNow(),
+	}
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+// GetSessionByTokenHash returns the session row that owns the given refresh token hash
+func (s *Store) GetSessionByTokenHash(ctx context.Context, tokenHash string) (db.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			return session, nil
+		}
+	}
+	return db.Session{}, errors.Wrap(sql.ErrNoRows, "session not found by token hash")
+}
+
+// RotateSession marks the session as rotated and stores the next refresh token atomically
+func (s *Store) RotateSession(ctx context.Context, id uuid.UUID, next db.CreateSessionParams) (db.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.sessions[id]
+	if !ok || current.RotatedAt.Valid || current.RevokedAt.Valid || !current.ExpiresAt.After(time.Now()) {
+		return db.Session{}, db.ErrSessionInactive
+	}
+
+	session, err := s.createSessionLocked(next)
+	if err != nil {
+		return db.Session{}, err
+	}
+	current.RotatedAt = nullNow()
+	s.sessions[id] = current
+	return session, nil
+}
+
+// RevokeSessionFamily revokes every refresh token belonging to the session family
+func (s *Store) RevokeSessionFamily(ctx context.Context, familyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.FamilyID == familyID && !session.RevokedAt.Valid {
+			session.RevokedAt = nullNow()
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of the user except the family exceptFamilyID
+func (s *Store) RevokeUserSessions(ctx context.Context, userID, exceptFamilyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID && session.FamilyID != exceptFamilyID && !session.RevokedAt.Valid {
+			session.RevokedAt = nullNow()
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+// IsSessionActive reports whether the session family still has a usable refresh token
+func (s *Store) IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range s.sessions {
+		if session.FamilyID == familyID && !session.RotatedAt.Valid && !session.RevokedAt.Valid && session.ExpiresAt.After(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CountActiveSessions returns the number of session families that still have a usable refresh token
+func (s *Store) CountActiveSessions(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, session := range s.sessions {
+		if !session.RotatedAt.Valid && !session.RevokedAt.Valid && session.ExpiresAt.After(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ListUserSessions returns every stored refresh token of the user, oldest first
+func (s *Store) ListUserSessions(ctx context.Context, userID uuid.UUID) ([]db.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions []db.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b db.Session) int {
+		return a.CreatedAt.Time.Compare(b.CreatedAt.Time)
+	})
+	return sessions, nil
+}
+
+// CreateUserToken stores a new single-use token for the user
+func (s *Store) CreateUserToken(ctx context.Context, arg db.CreateUserTokenParams) (db.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[arg.UserID]; !ok {
+		return db.UserToken{}, apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "user_tokens_user_id_fkey"})
+	}
+	for _, existing := range s.userTokens {
+		if existing.TokenHash == arg.TokenHash {
+			return db.UserToken{}, uniqueViolation("user_tokens_token_hash_key")
+		}
+	}
+
+	token := db.UserToken{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		Purpose:   arg.Purpose,
+		TokenHash: arg.TokenHash,
+		ExpiresAt: arg.ExpiresAt,
+		CreatedAt: nullNow(),
+	}
+	s.userTokens[token.ID] = token
+	return token, nil
+}
+
+// ConsumeUserToken marks the token as used and returns it
+func (s *Store) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (db.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.userTokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		if token.Purpose != purpose || token.UsedAt.Valid || !token.ExpiresAt.After(time.Now()) {
+			break
+		}
+		token.UsedAt = nullNow()
+		s.userTokens[id] = token
+		return token, nil
+	}
+	return db.UserToken{}, db.ErrUserTokenInvalid
+}
+
+// GetLatestUserToken returns the most recently created token of the user for the purpose
+func (s *Store) GetLatestUserToken(ctx context.Context, userID uuid.UUID, purpose string) (db.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest db.UserToken
+	found := false
+	for _, token := range s.userTokens {
+		if token.UserID == userID && token.Purpose == purpose && (!found || token.CreatedAt.Time.After(latest.CreatedAt.Time)) {
+			latest, found = token, true
+		}
+	}
+	if !found {
+		return db.UserToken{}, errors.Wrapf(sql.ErrNoRows, "no %s token for user: %s", purpose, userID)
+	}
+	return latest, nil
+}
+
+// InvalidateUserTokens marks every unused token of the user for the purpose as used
+func (s *Store) InvalidateUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.userTokens {
+		if token.UserID == userID && token.Purpose == purpose && !token.UsedAt.Valid {
+			token.UsedAt = nullNow()
+			s.userTokens[id] = token
+		}
+	}
+	return nil
+}
+
+// ListUserTokens returns every single-use token of the user, used or not, oldest first
+func (s *Store) ListUserTokens(ctx context.Context, userID uuid.UUID) ([]db.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []db.UserToken
+	for _, token := range s.userTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b db.UserToken) int {
+		return a.CreatedAt.Time.Compare(b.CreatedAt.Time)
+	})
+	return tokens, nil
+}
+
+// GetLoginAttempt returns the failure counter of the key
+func (s *Store) GetLoginAttempt(ctx context.Context, key string) (db.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		return db.LoginAttempt{}, errors.Wrapf(sql.ErrNoRows, "login attempt not found: %s", key)
+	}
+	return attempt, nil
+}
+
+// IncrementLoginFailures records a failed login for the key and returns the updated counter
+func (s *Store) IncrementLoginFailures(ctx context.Context, key string, now, resetBefore time.Time) (db.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.loginAttempts[key]
+	if !ok || attempt.LastFailureAt.Before(resetBefore) {
+		attempt = db.LoginAttempt{Key: key}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	s.loginAttempts[key] = attempt
+	return attempt, nil
+}
+
+// SetLoginLockout locks the key until the given time
+func (s *Store) SetLoginLockout(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.loginAttempts[key]; ok {
+		attempt.LockedUntil = sql.NullTime{Time: until, Valid: true}
+		s.loginAttempts[key] = attempt
+	}
+	return nil
+}
+
+// DeleteLoginAttempt forgets the failures of the key, lifting any lockout
+func (s *Store) DeleteLoginAttempt(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+	return nil
+}
+
+// ListLoginLockouts returns every key that is locked at now, longest lockout first
+func (s *Store) ListLoginLockouts(ctx context.Context, now time.Time) ([]db.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var locked []db.LoginAttempt
+	for _, attempt := range s.loginAttempts {
+		if attempt.LockedUntil.Valid && attempt.LockedUntil.Time.After(now) {
+			locked = append(locked, attempt)
+		}
+	}
+	slices.SortFunc(locked, func(a, b db.LoginAttempt) int {
+		return b.LockedUntil.Time.Compare(a.LockedUntil.Time)
+	})
+	return locked, nil
+}
+
+// rateLimitWindow is the primary key of rate_limits
+type rateLimitWindow struct {
+	key   string
+	start int64
+}
+
+// IncrementRateLimit counts a request for the key in the window starting at windowStart
+// and returns the number of requests in that window so far
+func (s *Store) IncrementRateLimit(ctx context.Context, key string, windowStart, expiresAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := rateLimitWindow{key: key, start: windowStart.UnixNano()}
+	counter, ok := s.rateLimits[id]
+	if !ok {
+		counter = db.RateLimitCounter{Key: key, WindowStart: windowStart, ExpiresAt: expiresAt}
+	}
+	counter.Hits++
+	s.rateLimits[id] = counter
+	return counter.Hits, nil
+}
+
+// GetRateLimitHits returns the number of requests for the key in the window starting at windowStart,
+// 0 when none was counted
+func (s *Store) GetRateLimitHits(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rateLimits[rateLimitWindow{key: key, start: windowStart.UnixNano()}].Hits, nil
+}
+
+// DeleteExpiredRateLimits deletes the counters that expired at now and returns how many were deleted
+func (s *Store) DeleteExpiredRateLimits(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, counter := range s.rateLimits {
+		if !counter.ExpiresAt.After(now) {
+			delete(s.rateLimits, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// GetUserMFA returns the TOTP enrollment of the user
+func (s *Store) GetUserMFA(ctx context.Context, userID uuid.UUID) (db.UserMFA, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mfa[userID]
+	if !ok {
+		return db.UserMFA{}, errors.Wrapf(sql.ErrNoRows, "mfa not found for user: %s", userID)
+	}
+	return m, nil
+}
+
+// SaveUserMFA stores a new pending TOTP secret, replacing a previous one
+func (s *Store) SaveUserMFA(ctx context.Context, userID uuid.UUID, encryptedSecret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "user_mfa_user_id_fkey"})
+	}
+	s.mfa[userID] = db.UserMFA{UserID: userID, TOTPSecret: encryptedSecret, CreatedAt: nullNow()}
+	return nil
+}
+
+// EnableUserMFA marks the enrollment of the user as confirmed
+func (s *Store) EnableUserMFA(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m, ok := s.mfa[userID]; ok {
+		m.EnabledAt = nullNow()
+		s.mfa[userID] = m
+	}
+	return nil
+}
+
+// UseTOTPStep records that the TOTP code of step was used, reporting false for replays
+func (s *Store) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mfa[userID]
+	if !ok || m.LastUsedStep >= step {
+		return false, nil
+	}
+	m.LastUsedStep = step
+	s.mfa[userID] = m
+	return true, nil
+}
+
+// DeleteUserMFA removes the enrollment and the recovery codes of the user
+func (s *Store) DeleteUserMFA(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.mfa, userID)
+	s.deleteRecoveryCodesLocked(userID)
+	return nil
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores the new hashes
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteRecoveryCodesLocked(userID)
+	for _, hash := range codeHashes {
+		code := db.MFARecoveryCode{ID: uuid.New(), UserID: userID, CodeHash: hash, CreatedAt: nullNow()}
+		s.recoveryCodes[code.ID] = code
+	}
+	return nil
+}
+
+func (s *Store) deleteRecoveryCodesLocked(userID uuid.UUID) {
+	for id, code := range s.recoveryCodes {
+		if code.UserID == userID {
+			delete(s.recoveryCodes, id)
+		}
+	}
+}
+
+// UseRecoveryCode marks the matching unused recovery code as used and reports whether there was one
+func (s *Store) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, code := range s.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == codeHash && !code.UsedAt.Valid {
+			code.UsedAt = nullNow()
+			s.recoveryCodes[id] = code
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, code := range s.recoveryCodes {
+		if code.UserID == userID && !code.UsedAt.Valid {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetUserIdentity returns the link of the provider account identified by subject
+func (s *Store) GetUserIdentity(ctx context.Context, provider, subject string) (db.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return db.UserIdentity{}, errors.Wrapf(sql.ErrNoRows, "identity not found: %s", provider)
+}
+
+// CreateUserIdentity links a provider account to the user, unique per provider and subject
+func (s *Store) CreateUserIdentity(ctx context.Context, arg db.CreateUserIdentityParams) (db.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[arg.UserID]; !ok {
+		return db.UserIdentity{}, apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "user_identities_user_id_fkey"})
+	}
+	for _, identity := range s.identities {
+		if identity.Provider == arg.Provider && identity.Subject == arg.Subject {
+			return db.UserIdentity{}, uniqueViolation("user_identities_provider_subject_key")
+		}
+	}
+
+	identity := db.UserIdentity{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		Provider:  arg.Provider,
+		Subject:   arg.Subject,
+		Email:     arg.Email,
+		CreatedAt: nullNow(),
+	}
+	s.identities[identity.ID] = identity
+	return identity, nil
+}
+
+// ListUserIdentities returns the provider accounts linked to the user, oldest first
+func (s *Store) ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]db.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var identities []db.UserIdentity
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	slices.SortFunc(identities, func(a, b db.UserIdentity) int {
+		return a.CreatedAt.Time.Compare(b.CreatedAt.Time)
+	})
+	return identities, nil
+}
+
+// CreatePlayerProfile creates the profile of a user. Handles are unique case-insensitively
+// like the player_profiles_handle_key index.
+func (s *Store) CreatePlayerProfile(ctx context.Context, arg db.CreatePlayerProfileParams) (db.PlayerProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[arg.UserID]; !ok {
+		return db.PlayerProfile{}, apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "player_profiles_user_id_fkey"})
+	}
+	if _, ok := s.profiles[arg.UserID]; ok {
+		return db.PlayerProfile{}, uniqueViolation("player_profiles_pkey")
+	}
+	if s.handleTakenLocked(arg.Handle, arg.UserID) {
+		return db.PlayerProfile{}, uniqueViolation("player_profiles_handle_key")
+	}
+
+	profile := db.PlayerProfile{
+		UserID:      arg.UserID,
+		Handle:      arg.Handle,
+		DisplayName: arg.DisplayName,
+		CreatedAt:   nullNow(),
+		UpdatedAt:   nullNow(),
+	}
+	s.profiles[arg.UserID] = profile
+	return profile, nil
+}
+
+func (s *Store) handleTakenLocked(handle string, except uuid.UUID) bool {
+	for _, p := range s.profiles {
+		if p.UserID != except && strings.EqualFold(p.Handle, handle) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPlayerProfile returns the profile of a user
+func (s *Store) GetPlayerProfile(ctx context.Context, userID uuid.UUID) (db.PlayerProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.profiles[userID]
+	if !ok {
+		return db.PlayerProfile{}, errors.Wrapf(sql.ErrNoRows, "player profile not found: %s", userID)
+	}
+	return p, nil
+}
+
+// GetPlayerProfileByHandle returns the profile with the handle, compared case-insensitively
+func (s *Store) GetPlayerProfileByHandle(ctx context.Context, handle string) (db.PlayerProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.profiles {
+		if strings.EqualFold(p.Handle, handle) {
+			return p, nil
+		}
+	}
+	return db.PlayerProfile{}, errors.Wrapf(sql.ErrNoRows, "player profile not found by handle: %s", handle)
+}
+
+// UpdatePlayerProfile changes the given fields of the profile of a user and returns the result
+func (s *Store) UpdatePlayerProfile(ctx context.Context, userID uuid.UUID, arg db.UpdatePlayerProfileParams) (db.PlayerProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.profiles[userID]
+	if !ok {
+		return db.PlayerProfile{}, errors.Wrapf(sql.ErrNoRows, "player profile not found: %s", userID)
+	}
+	if arg.Handle != nil {
+		if s.handleTakenLocked(*arg.Handle, userID) {
+			return db.PlayerProfile{}, uniqueViolation("player_profiles_handle_key")
+		}
+		p.Handle = *arg.Handle
+	}
+	if arg.DisplayName != nil {
+		p.DisplayName = *arg.DisplayName
+	}
+	if arg.Bio != nil {
+		p.Bio = *arg.Bio
+	}
+	if arg.AvatarRef != nil {
+		p.AvatarRef = *arg.AvatarRef
+	}
+	p.UpdatedAt = nullNow()
+	s.profiles[userID] = p
+	return p, nil
+}
+
+// CreateAuditLog records an operation on an account
+func (s *Store) CreateAuditLog(ctx context.Context, arg db.CreateAuditLogParams) (db.AuditLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := db.AuditLog{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		ActorID:   uuid.NullUUID{UUID: arg.ActorID, Valid: arg.ActorID != uuid.Nil},
+		Action:    arg.Action,
+		IPAddress: arg.IPAddress,
+		UserAgent: arg.UserAgent,
+		CreatedAt: nullNow(),
+	}
+	s.auditLogs[entry.ID] = entry
+	return entry, nil
+}
+
+// ListAuditLogs returns the audit log of the user, oldest first
+func (s *Store) ListAuditLogs(ctx context.Context, userID uuid.UUID) ([]db.AuditLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []db.AuditLog
+	for _, entry := range s.auditLogs {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b db.AuditLog) int {
+		return a.CreatedAt.Time.Compare(b.CreatedAt.Time)
+	})
+	return entries, nil
+}
+
+// AnonymizeAuditLogs clears the client IP and user agent of every audit log entry of the user,
+// including the entries where the user acted on another account
+func (s *Store) AnonymizeAuditLogs(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, entry := range s.auditLogs {
+		if entry.UserID == userID || entry.ActorID == (uuid.NullUUID{UUID: userID, Valid: true}) {
+			entry.IPAddress, entry.UserAgent = "", ""
+			s.auditLogs[id] = entry
+		}
+	}
+	return nil
+}
+
+// ListRoles returns every role, ordered by name
+func (s *Store) ListRoles(ctx context.Context) ([]db.Role, error) {
+	return slices.Clone(s.roles), nil
+}
+
+// ListRolePermissions returns the permissions granted to the given roles, ordered by role and permission
+func (s *Store) ListRolePermissions(ctx context.Context, roles []string) ([]db.RolePermission, error) {
+	permissions := []db.RolePermission{}
+	for _, p := range s.rolePermissions {
+		if slices.Contains(roles, p.Role) {
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, nil
+}
+
+// ListUserRoles returns the roles assigned to the user, ordered by name.
+// The implicit player role is not included.
+func (s *Store) ListUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var roles []string
+	for _, r := range s.userRoles[userID] {
+		roles = append(roles, r.Role)
+	}
+	slices.Sort(roles)
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles assigned to the user.
+// An unknown user or role fails like the foreign keys of user_roles.
+func (s *Store) SetUserRoles(ctx context.Context, userID uuid.UUID, roles []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "user_roles_user_id_fkey"})
+	}
+	var assigned []db.UserRole
+	for _, role := range roles {
+		if !slices.ContainsFunc(s.roles, func(r db.Role) bool { return r.Name == role }) {
+			return apperrors.WrapDBError(&pq.Error{Code: "23503", Constraint: "user_roles_role_fkey"})
+		}
+		if slices.ContainsFunc(assigned, func(r db.UserRole) bool { return r.Role == role }) {
+			continue
+		}
+		// 既存の割り当ては作成日時を保つ
+		i := slices.IndexFunc(s.userRoles[userID], func(r db.UserRole) bool { return r.Role == role })
+		if i >= 0 {
+			assigned = append(assigned, s.userRoles[userID][i])
+			continue
+		}
+		assigned = append(assigned, db.UserRole{UserID: userID, Role: role, CreatedAt: nullNow()})
+	}
+	if len(assigned) == 0 {
+		delete(s.userRoles, userID)
+		return nil
+	}
+	s.userRoles[userID] = assigned
+	return nil
+}
+
+// CountUsersWithRole returns the number of users assigned the role
+func (s *Store) CountUsersWithRole(ctx context.Context, role string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, roles := range s.userRoles {
+		if slices.ContainsFunc(roles, func(r db.UserRole) bool { return r.Role == role }) {
+			n++
+		}
+	}
+	return n, nil
+}
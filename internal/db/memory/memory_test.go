@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	store := New()
+	ctx := context.Background()
+
+	_, err := store.CreateUser(ctx, db.CreateUserParams{Email: "a@example.com", Password: "x", Name: "A"})
+	require.NoError(t, err)
+
+	_, err = store.CreateUser(ctx, db.CreateUserParams{Email: "a@example.com", Password: "x", Name: "A"})
+	assert.True(t, apperrors.IsDuplicate(err))
+	assert.Equal(t, 409, apperrors.GetHTTPStatus(err))
+}
+
+func TestRunInTxRollsBackOnError(t *testing.T) {
+	store := New()
+	ctx := context.Background()
+
+	err := store.RunInTx(ctx, func(ctx context.Context) error {
+		if _, err := store.CreateUser(ctx, db.CreateUserParams{Email: "outer@example.com", Name: "Outer"}); err != nil {
+			return err
+		}
+
+		// A failing nested transaction only undoes its own work
+		innerErr := store.RunInTx(ctx, func(ctx context.Context) error {
+			if _, err := store.CreateUser(ctx, db.CreateUserParams{Email: "inner@example.com", Name: "Inner"}); err != nil {
+				return err
+			}
+			return errors.New("inner failed")
+		})
+		assert.Error(t, innerErr)
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = store.GetUserByEmail(ctx, "outer@example.com")
+	assert.NoError(t, err)
+	_, err = store.GetUserByEmail(ctx, "inner@example.com")
+	assert.Error(t, err)
+
+	// A failing outer transaction undoes everything
+	_ = store.RunInTx(ctx, func(ctx context.Context) error {
+		_, _ = store.CreateUser(ctx, db.CreateUserParams{Email: "rolled-back@example.com", Name: "X"})
+		return errors.New("outer failed")
+	})
+	_, err = store.GetUserByEmail(ctx, "rolled-back@example.com")
+	assert.Error(t, err)
+}
+
+func TestConsumeUserTokenIsSingleUse(t *testing.T) {
+	store := New()
+	ctx := context.Background()
+
+	user, err := store.CreateUser(ctx, db.CreateUserParams{Email: "token@example.com", Name: "Token"})
+	require.NoError(t, err)
+	_, err = store.CreateUserToken(ctx, db.CreateUserTokenParams{
+		UserID:    user.ID,
+		Purpose:   db.TokenPurposeEmailVerification,
+		TokenHash: "hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	_, err = store.ConsumeUserToken(ctx, "other_purpose", "hash")
+	assert.ErrorIs(t, err, db.ErrUserTokenInvalid)
+
+	token, err := store.ConsumeUserToken(ctx, db.TokenPurposeEmailVerification, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, token.UserID)
+
+	_, err = store.ConsumeUserToken(ctx, db.TokenPurposeEmailVerification, "hash")
+	assert.ErrorIs(t, err, db.ErrUserTokenInvalid)
+}
+
+func TestSearchUsers(t *testing.T) {
+	store := New()
+	ctx := context.Background()
+
+	for _, u := range []struct{ email, name, handle string }{
+		{"deer@example.com", "Deer", "bambi"},
+		{"fox@example.com", "Fox", "reynard"},
+		{"doe@example.com", "Doe", "deer_lover"},
+	} {
+		user, err := store.CreateUser(ctx, db.CreateUserParams{Email: u.email, Password: "x", Name: u.name})
+		require.NoError(t, err)
+		_, err = store.CreatePlayerProfile(ctx, db.CreatePlayerProfileParams{UserID: user.ID, Handle: u.handle, DisplayName: u.name})
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+
+	// メールアドレス・名前・ハンドルのどれかに大文字小文字を区別せず一致する
+	rows, total, err := store.SearchUsers(ctx, db.SearchUsersParams{Query: "DEER", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, "deer@example.com", rows[0].Email)
+		assert.Equal(t, "deer_lover", rows[1].Handle)
+	}
+
+	rows, total, err = store.SearchUsers(ctx, db.SearchUsersParams{Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, "fox@example.com", rows[0].Email)
+	}
+}
+
+func TestCountActiveSessions(t *testing.T) {
+	store := New()
+	ctx := context.Background()
+
+	user, err := store.CreateUser(ctx, db.CreateUserParams{Email: "sessions@example.com", Name: "Sessions"})
+	require.NoError(t, err)
+	session := func(hash string, expiresAt time.Time) db.Session {
+		s, err := store.CreateSession(ctx, db.CreateSessionParams{FamilyID: uuid.New(), UserID: user.ID, TokenHash: hash, ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		return s
+	}
+
+	rotated := session("rotated", time.Now().Add(time.Hour))
+	_, err = store.RotateSession(ctx, rotated.ID, db.CreateSessionParams{FamilyID: rotated.FamilyID, UserID: user.ID, TokenHash: "next", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	revoked := session("revoked", time.Now().Add(time.Hour))
+	require.NoError(t, store.RevokeSessionFamily(ctx, revoked.FamilyID))
+	session("expired", time.Now().Add(-time.Minute))
+
+	// ローテーション後のトークンだけが有効
+	count, err := store.CountActiveSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
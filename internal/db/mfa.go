@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+)
+
+// GetUserMFA returns the TOTP enrollment of the user
+func (d *DB) GetUserMFA(ctx context.Context, userID uuid.UUID) (UserMFA, error) {
+	var m UserMFA
+	err := d.conn(ctx).NewSelect().
+		Model(&m).
+		Where("user_id = ?", userID).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UserMFA{}, errors.Wrapf(err, "mfa not found for user: %s", userID)
+		}
+		return UserMFA{}, errors.Wrapf(err, "failed to get mfa for user: %s", userID)
+	}
+
+	return m, nil
+}
+
+// SaveUserMFA stores a new pending (not yet enabled) TOTP secret, replacing a previous pending one
+func (d *DB) SaveUserMFA(ctx context.Context, userID uuid.UUID, encryptedSecret string) error {
+	m := &UserMFA{UserID: userID, TOTPSecret: encryptedSecret}
+	_, err := d.conn(ctx).NewInsert().
+		Model(m).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("totp_secret = EXCLUDED.totp_secret").
+		Set("enabled_at = NULL").
+		Set("last_used_step = 0").
+		Set("created_at = current_timestamp").
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save mfa for user: %s", userID)
+	}
+	return nil
+}
+
+// EnableUserMFA marks the enrollment of the user as confirmed
+func (d *DB) EnableUserMFA(ctx context.Context, userID uuid.UUID) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*UserMFA)(nil)).
+		Set("enabled_at = current_timestamp").
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to enable mfa for user: %s", userID)
+	}
+	return nil
+}
+
+// UseTOTPStep records that the TOTP code of step was used.
+// It returns false when the step or a later one was already used, so each code works only once.
+func (d *DB) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	res, err := d.conn(ctx).NewUpdate().
+		Model((*UserMFA)(nil)).
+		Set("last_used_step = ?", step).
+		Where("user_id = ?", userID).
+		Where("last_used_step < ?", step).
+		Exec(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to record totp step for user: %s", userID)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record totp step")
+	}
+	return n > 0, nil
+}
+
+// DeleteUserMFA removes the enrollment and the recovery codes of the user
+func (d *DB) DeleteUserMFA(ctx context.Context, userID uuid.UUID) error {
+	return d.RunInTx(ctx, func(ctx context.Context) error {
+		if _, err := d.conn(ctx).NewDelete().Model((*MFARecoveryCode)(nil)).Where("user_id = ?", userID).Exec(ctx); err != nil {
+			return errors.Wrapf(err, "failed to delete recovery codes for user: %s", userID)
+		}
+		if _, err := d.conn(ctx).NewDelete().Model((*UserMFA)(nil)).Where("user_id = ?", userID).Exec(ctx); err != nil {
+			return errors.Wrapf(err, "failed to delete mfa for user: %s", userID)
+		}
+		return nil
+	})
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores the new hashes
+func (d *DB) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	return d.RunInTx(ctx, func(ctx context.Context) error {
+		if _, err := d.conn(ctx).NewDelete().Model((*MFARecoveryCode)(nil)).Where("user_id = ?", userID).Exec(ctx); err != nil {
+			return errors.Wrapf(err, "failed to delete recovery codes for user: %s", userID)
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+
+		codes := make([]MFARecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = MFARecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		if _, err := d.conn(ctx).NewInsert().Model(&codes).Exec(ctx); err != nil {
+			return errors.Wrapf(err, "failed to create recovery codes for user: %s", userID)
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks the matching unused recovery code as used and reports whether there was one
+func (d *DB) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	res, err := d.conn(ctx).NewUpdate().
+		Model((*MFARecoveryCode)(nil)).
+		Set("used_at = current_timestamp").
+		Where("user_id = ?", userID).
+		Where("code_hash = ?", codeHash).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to use recovery code for user: %s", userID)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to use recovery code")
+	}
+	return n > 0, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func (d *DB) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := d.conn(ctx).NewSelect().
+		Model((*MFARecoveryCode)(nil)).
+		Where("user_id = ?", userID).
+		Where("used_at IS NULL").
+		Count(ctx)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to count recovery codes for user: %s", userID)
+	}
+	return n, nil
+}
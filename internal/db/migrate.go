@@ -0,0 +1,177 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/migrations"
+)
+
+// migrationFile matches "0001_create_users_table.up.sql"
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migrator applies the migrations embedded in the binary.
+// It uses its own connection so closing it never affects the application pool.
+// Concurrent migrators (e.g. several replicas booting at once) are serialized with
+// a PostgreSQL advisory lock held by the postgres driver for the duration of each command.
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+// MigrationInfo describes one embedded migration
+type MigrationInfo struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// MigrationStatus is the state of the schema compared to the embedded migrations
+type MigrationStatus struct {
+	Version    uint
+	Dirty      bool
+	Migrations []MigrationInfo
+}
+
+// NewMigrator connects to the configured database and loads the embedded migrations
+func NewMigrator(cfg config.DatabaseConfig) (*Migrator, error) {
+	sqlDB, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database for migrations")
+	}
+
+	driver, err := postgres.WithInstance(sqlDB, &postgres.Config{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, errors.Wrap(err, "failed to create migration driver")
+	}
+
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		driver.Close()
+		return nil, errors.Wrap(err, "failed to load embedded migrations")
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, errors.Wrap(err, "failed to create migrator")
+	}
+
+	return &Migrator{m: m}, nil
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up() error {
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return errors.Wrap(err, "failed to apply migrations")
+	}
+	return nil
+}
+
+// Down rolls back the given number of migrations
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return errors.Newf("steps must be positive, got %d", steps)
+	}
+	if err := m.m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return errors.Wrap(err, "failed to roll back migrations")
+	}
+	return nil
+}
+
+// DownAll rolls back every applied migration
+func (m *Migrator) DownAll() error {
+	if err := m.m.Down(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return errors.Wrap(err, "failed to roll back migrations")
+	}
+	return nil
+}
+
+// Status returns the current schema version and every embedded migration
+func (m *Migrator) Status() (MigrationStatus, error) {
+	version, dirty, err := m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, errors.Wrap(err, "failed to read schema version")
+	}
+
+	infos, err := embeddedMigrations()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	for i := range infos {
+		infos[i].Applied = infos[i].Version <= version
+	}
+
+	return MigrationStatus{Version: version, Dirty: dirty, Migrations: infos}, nil
+}
+
+// Close releases the migrator's database connection
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.CombineErrors(srcErr, dbErr)
+}
+
+// CreateMigration writes an empty up/down migration pair with the next version number into dir
+func CreateMigration(dir, name string) ([]string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(name) {
+		return nil, errors.Newf("migration name must be snake_case, got %q", name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read migrations directory %s", dir)
+	}
+	var next uint64 = 1
+	for _, e := range entries {
+		if match := migrationFile.FindStringSubmatch(e.Name()); match != nil {
+			if v, _ := strconv.ParseUint(match[1], 10, 64); v >= next {
+				next = v + 1
+			}
+		}
+	}
+
+	var created []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", next, name, direction))
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			return created, errors.Wrapf(err, "failed to create %s", path)
+		}
+		created = append(created, path)
+	}
+	return created, nil
+}
+
+func embeddedMigrations() ([]MigrationInfo, error) {
+	files, err := fs.Glob(migrations.FS, "*.up.sql")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list embedded migrations")
+	}
+
+	var infos []MigrationInfo
+	for _, f := range files {
+		match := migrationFile.FindStringSubmatch(f)
+		if match == nil {
+			continue
+		}
+		v, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid migration version in %s", f)
+		}
+		infos = append(infos, MigrationInfo{Version: uint(v), Name: match[2]})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Version < infos[j].Version })
+	return infos, nil
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
@@ -11,10 +12,150 @@ import (
 type User struct {
 	bun.BaseModel `bun:"table:users,alias:u"`
 
+	ID              uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
+	Email           string       `bun:"email,notnull,unique" json:"email"`
+	Password        string       `bun:"password,notnull" json:"-"`
+	Name            string       `bun:"name,notnull" json:"name"`
+	Locale          string       `bun:"locale,notnull" json:"locale"`
+	EmailVerifiedAt sql.NullTime `bun:"email_verified_at" json:"email_verified_at"`
+	DeleteAfter     sql.NullTime `bun:"delete_after" json:"delete_after"`
+	SuspendedAt     sql.NullTime `bun:"suspended_at" json:"suspended_at"`
+	CreatedAt       sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+	UpdatedAt       sql.NullTime `bun:"updated_at,default:current_timestamp" json:"updated_at"`
+}
+
+// PlayerProfile is the public profile of a player. Handle is unique case-insensitively.
+type PlayerProfile struct {
+	bun.BaseModel `bun:"table:player_profiles,alias:pp"`
+
+	UserID      uuid.UUID    `bun:"user_id,pk,type:uuid" json:"user_id"`
+	Handle      string       `bun:"handle,notnull" json:"handle"`
+	DisplayName string       `bun:"display_name,notnull" json:"display_name"`
+	Bio         string       `bun:"bio,notnull" json:"bio"`
+	AvatarRef   string       `bun:"avatar_ref,notnull" json:"avatar_ref"`
+	CurrentTown string       `bun:"current_town,notnull" json:"current_town"`
+	CreatedAt   sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+	UpdatedAt   sql.NullTime `bun:"updated_at,default:current_timestamp" json:"updated_at"`
+}
+
+// UserToken is a hashed single-use token sent to the player, e.g. an email verification link
+type UserToken struct {
+	bun.BaseModel `bun:"table:user_tokens,alias:ut"`
+
+	ID        uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID    `bun:"user_id,notnull,type:uuid" json:"user_id"`
+	Purpose   string       `bun:"purpose,notnull" json:"purpose"`
+	TokenHash string       `bun:"token_hash,notnull,unique" json:"-"`
+	ExpiresAt time.Time    `bun:"expires_at,notnull" json:"expires_at"`
+	UsedAt    sql.NullTime `bun:"used_at" json:"used_at"`
+	CreatedAt sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// LoginAttempt counts the failed logins of an account or client IP
+type LoginAttempt struct {
+	bun.BaseModel `bun:"table:login_attempts,alias:la"`
+
+	Key           string       `bun:"key,pk" json:"key"`
+	Failures      int          `bun:"failures,notnull" json:"failures"`
+	LastFailureAt time.Time    `bun:"last_failure_at,notnull" json:"last_failure_at"`
+	LockedUntil   sql.NullTime `bun:"locked_until" json:"locked_until"`
+}
+
+// RateLimitCounter counts the requests of a rate limit key within one fixed window
+type RateLimitCounter struct {
+	bun.BaseModel `bun:"table:rate_limits,alias:rl"`
+
+	Key         string    `bun:"key,pk" json:"key"`
+	WindowStart time.Time `bun:"window_start,pk" json:"window_start"`
+	Hits        int       `bun:"hits,notnull" json:"hits"`
+	ExpiresAt   time.Time `bun:"expires_at,notnull" json:"expires_at"`
+}
+
+// UserMFA is the TOTP enrollment of a user. TOTPSecret is encrypted by the mfa package.
+type UserMFA struct {
+	bun.BaseModel `bun:"table:user_mfa,alias:um"`
+
+	UserID       uuid.UUID    `bun:"user_id,pk,type:uuid" json:"user_id"`
+	TOTPSecret   string       `bun:"totp_secret,notnull" json:"-"`
+	EnabledAt    sql.NullTime `bun:"enabled_at" json:"enabled_at"`
+	LastUsedStep int64        `bun:"last_used_step,notnull" json:"-"`
+	CreatedAt    sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// MFARecoveryCode is a hashed single-use code that replaces a TOTP code
+type MFARecoveryCode struct {
+	bun.BaseModel `bun:"table:mfa_recovery_codes,alias:mrc"`
+
+	ID        uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID    `bun:"user_id,notnull,type:uuid" json:"user_id"`
+	CodeHash  string       `bun:"code_hash,notnull" json:"-"`
+	UsedAt    sql.NullTime `bun:"used_at" json:"used_at"`
+	CreatedAt sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// UserIdentity links a user to an account of an OpenID Connect provider
+type UserIdentity struct {
+	bun.BaseModel `bun:"table:user_identities,alias:ui"`
+
+	ID        uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID    `bun:"user_id,notnull,type:uuid" json:"user_id"`
+	Provider  string       `bun:"provider,notnull" json:"provider"`
+	Subject   string       `bun:"subject,notnull" json:"subject"`
+	Email     string       `bun:"email,notnull" json:"email"`
+	CreatedAt sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// AuditLog records an operation on an account. It has no foreign key to users so that it
+// outlives the account; the client details are cleared when the account is purged.
+type AuditLog struct {
+	bun.BaseModel `bun:"table:audit_logs,alias:al"`
+
+	ID        uuid.UUID     `bun:"id,pk,default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID     `bun:"user_id,notnull,type:uuid" json:"user_id"`
+	ActorID   uuid.NullUUID `bun:"actor_id,type:uuid" json:"actor_id"`
+	Action    string        `bun:"action,notnull" json:"action"`
+	IPAddress string        `bun:"ip_address,notnull" json:"ip_address"`
+	UserAgent string        `bun:"user_agent,notnull" json:"user_agent"`
+	CreatedAt sql.NullTime  `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// Role is a named set of permissions. Every user has the player role implicitly.
+type Role struct {
+	bun.BaseModel `bun:"table:roles,alias:r"`
+
+	Name        string `bun:"name,pk" json:"name"`
+	Description string `bun:"description,notnull" json:"description"`
+}
+
+// RolePermission grants a permission to a role
+type RolePermission struct {
+	bun.BaseModel `bun:"table:role_permissions,alias:rp"`
+
+	Role       string `bun:"role,pk" json:"role"`
+	Permission string `bun:"permission,pk" json:"permission"`
+}
+
+// UserRole assigns a role other than player to a user
+type UserRole struct {
+	bun.BaseModel `bun:"table:user_roles,alias:ur"`
+
+	UserID    uuid.UUID    `bun:"user_id,pk,type:uuid" json:"user_id"`
+	Role      string       `bun:"role,pk" json:"role"`
+	CreatedAt sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// Session represents a single refresh token issued within a login session family
+type Session struct {
+	bun.BaseModel `bun:"table:sessions,alias:s"`
+
 	ID        uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
-	Email     string       `bun:"email,notnull,unique" json:"email"`
-	Password  string       `bun:"password,notnull" json:"password"`
-	Name      string       `bun:"name,notnull" json:"name"`
+	FamilyID  uuid.UUID    `bun:"family_id,notnull,type:uuid" json:"family_id"`
+	UserID    uuid.UUID    `bun:"user_id,notnull,type:uuid" json:"user_id"`
+	TokenHash string       `bun:"token_hash,notnull,unique" json:"-"`
+	UserAgent string       `bun:"user_agent,notnull" json:"user_agent"`
+	IPAddress string       `bun:"ip_address,notnull" json:"ip_address"`
+	ExpiresAt time.Time    `bun:"expires_at,notnull" json:"expires_at"`
+	RotatedAt sql.NullTime `bun:"rotated_at" json:"rotated_at"`
+	RevokedAt sql.NullTime `bun:"revoked_at" json:"revoked_at"`
 	CreatedAt sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
-	UpdatedAt sql.NullTime `bun:"updated_at,default:current_timestamp" json:"updated_at"`
 }
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+)
+
+// CreatePlayerProfileParams contains the parameters for creating a player profile
+type CreatePlayerProfileParams struct {
+	UserID      uuid.UUID
+	Handle      string
+	DisplayName string
+}
+
+// UpdatePlayerProfileParams contains the profile fields to change. Nil fields are left as they are.
+type UpdatePlayerProfileParams struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarRef   *string
+}
+
+// CreatePlayerProfile creates the profile of a user.
+// A handle already used by another player (in any case) is reported as an apperrors duplicate.
+func (d *DB) CreatePlayerProfile(ctx context.Context, arg CreatePlayerProfileParams) (PlayerProfile, error) {
+	profile := &PlayerProfile{
+		UserID:      arg.UserID,
+		Handle:      arg.Handle,
+		DisplayName: arg.DisplayName,
+	}
+
+	_, err := d.conn(ctx).NewInsert().Model(profile).Returning("*").Exec(ctx)
+	if err != nil {
+		if dbErr := apperrors.WrapDBError(err); apperrors.IsDuplicate(dbErr) {
+			return PlayerProfile{}, dbErr
+		}
+		return PlayerProfile{}, errors.Wrapf(err, "failed to create player profile: %s", arg.UserID)
+	}
+
+	return *profile, nil
+}
+
+// GetPlayerProfile returns the profile of a user
+func (d *DB) GetPlayerProfile(ctx context.Context, userID uuid.UUID) (PlayerProfile, error) {
+	var profile PlayerProfile
+	err := d.conn(ctx).NewSelect().
+		Model(&profile).
+		Where("user_id = ?", userID).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return PlayerProfile{}, errors.Wrapf(err, "player profile not found: %s", userID)
+		}
+		return PlayerProfile{}, errors.Wrapf(err, "failed to get player profile: %s", userID)
+	}
+
+	return profile, nil
+}
+
+// GetPlayerProfileByHandle returns the profile with the handle, compared case-insensitively
+func (d *DB) GetPlayerProfileByHandle(ctx context.Context, handle string) (PlayerProfile, error) {
+	var profile PlayerProfile
+	err := d.conn(ctx).NewSelect().
+		Model(&profile).
+		Where("lower(handle) = lower(?)", handle).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return PlayerProfile{}, errors.Wrapf(err, "player profile not found by handle: %s", handle)
+		}
+		return PlayerProfile{}, errors.Wrapf(err, "failed to get player profile by handle: %s", handle)
+	}
+
+	return profile, nil
+}
+
+// UpdatePlayerProfile changes the given fields of the profile of a user and returns the result
+func (d *DB) UpdatePlayerProfile(ctx context.Context, userID uuid.UUID, arg UpdatePlayerProfileParams) (PlayerProfile, error) {
+	var profile PlayerProfile
+	q := d.conn(ctx).NewUpdate().
+		Model(&profile).
+		Set("updated_at = current_timestamp").
+		Where("user_id = ?", userID)
+	if arg.Handle != nil {
+		q = q.Set("handle = ?", *arg.Handle)
+	}
+	if arg.DisplayName != nil {
+		q = q.Set("display_name = ?", *arg.DisplayName)
+	}
+	if arg.Bio != nil {
+		q = q.Set("bio = ?", *arg.Bio)
+	}
+	if arg.AvatarRef != nil {
+		q = q.Set("avatar_ref = ?", *arg.AvatarRef)
+	}
+
+	err := q.Returning("*").Scan(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return PlayerProfile{}, errors.Wrapf(err, "player profile not found: %s", userID)
+		}
+		if dbErr := apperrors.WrapDBError(err); apperrors.IsDuplicate(dbErr) {
+			return PlayerProfile{}, dbErr
+		}
+		return PlayerProfile{}, errors.Wrapf(err, "failed to update player profile: %s", userID)
+	}
+
+	return profile, nil
+}
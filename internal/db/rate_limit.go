@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// IncrementRateLimit counts a request for the key in the window starting at windowStart
+// and returns the number of requests in that window so far
+func (d *DB) IncrementRateLimit(ctx context.Context, key string, windowStart, expiresAt time.Time) (int, error) {
+	counter := &RateLimitCounter{
+		Key:         key,
+		WindowStart: windowStart,
+		Hits:        1,
+		ExpiresAt:   expiresAt,
+	}
+
+	// 複数レプリカから同時に数えても取りこぼさないようUPSERTで加算する
+	_, err := d.conn(ctx).NewInsert().
+		Model(counter).
+		On("CONFLICT (key, window_start) DO UPDATE").
+		Set("hits = rl.hits + 1").
+		Returning("hits").
+		Exec(ctx)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to count request: %s", key)
+	}
+
+	return counter.Hits, nil
+}
+
+// GetRateLimitHits returns the number of requests for the key in the window starting at windowStart,
+// 0 when none was counted
+func (d *DB) GetRateLimitHits(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	var hits int
+	err := d.conn(ctx).NewSelect().
+		Model((*RateLimitCounter)(nil)).
+		Column("hits").
+		Where("key = ?", key).
+		Where("window_start = ?", windowStart).
+		Scan(ctx, &hits)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, errors.Wrapf(err, "failed to get request count: %s", key)
+	}
+
+	return hits, nil
+}
+
+// DeleteExpiredRateLimits deletes the counters that expired at now and returns how many were deleted
+func (d *DB) DeleteExpiredRateLimits(ctx context.Context, now time.Time) (int, error) {
+	res, err := d.conn(ctx).NewDelete().
+		Model((*RateLimitCounter)(nil)).
+		Where("expires_at <= ?", now).
+		Exec(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired rate limits")
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired rate limits")
+	}
+	return int(deleted), nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserRepository is the persistence interface for users.
+// Implementations must report unique-email violations as apperrors duplicates
+// and missing rows as errors wrapping sql.ErrNoRows.
+type UserRepository interface {
+	CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) error
+	UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+	ScheduleUserDeletion(ctx context.Context, id uuid.UUID, at time.Time) error
+	CancelUserDeletion(ctx context.Context, id uuid.UUID) (bool, error)
+	ListUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]User, error)
+	DeleteUserDueForDeletion(ctx context.Context, id uuid.UUID, now time.Time) (bool, error)
+	SuspendUser(ctx context.Context, id uuid.UUID) (bool, error)
+	UnsuspendUser(ctx context.Context, id uuid.UUID) (bool, error)
+	SearchUsers(ctx context.Context, arg SearchUsersParams) ([]UserSearchRow, int, error)
+}
+
+// UserTokenRepository is the persistence interface for single-use user tokens
+type UserTokenRepository interface {
+	CreateUserToken(ctx context.Context, arg CreateUserTokenParams) (UserToken, error)
+	ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (UserToken, error)
+	GetLatestUserToken(ctx context.Context, userID uuid.UUID, purpose string) (UserToken, error)
+	InvalidateUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error
+	ListUserTokens(ctx context.Context, userID uuid.UUID) ([]UserToken, error)
+}
+
+// SessionRepository is the persistence interface for refresh-token sessions
+type SessionRepository interface {
+	CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error)
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (Session, error)
+	RotateSession(ctx context.Context, id uuid.UUID, next CreateSessionParams) (Session, error)
+	RevokeSessionFamily(ctx context.Context, familyID uuid.UUID) error
+	IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error)
+	RevokeUserSessions(ctx context.Context, userID, exceptFamilyID uuid.UUID) error
+	ListUserSessions(ctx context.Context, userID uuid.UUID) ([]Session, error)
+	CountActiveSessions(ctx context.Context) (int, error)
+}
+
+// LoginAttemptRepository is the persistence interface for failed login counters
+type LoginAttemptRepository interface {
+	GetLoginAttempt(ctx context.Context, key string) (LoginAttempt, error)
+	IncrementLoginFailures(ctx context.Context, key string, now, resetBefore time.Time) (LoginAttempt, error)
+	SetLoginLockout(ctx context.Context, key string, until time.Time) error
+	DeleteLoginAttempt(ctx context.Context, key string) error
+	ListLoginLockouts(ctx context.Context, now time.Time) ([]LoginAttempt, error)
+}
+
+// RateLimitRepository is the persistence interface for rate limit counters
+type RateLimitRepository interface {
+	IncrementRateLimit(ctx context.Context, key string, windowStart, expiresAt time.Time) (int, error)
+	GetRateLimitHits(ctx context.Context, key string, windowStart time.Time) (int, error)
+	DeleteExpiredRateLimits(ctx context.Context, now time.Time) (int, error)
+}
+
+// MFARepository is the persistence interface for TOTP enrollments and recovery codes
+type MFARepository interface {
+	GetUserMFA(ctx context.Context, userID uuid.UUID) (UserMFA, error)
+	SaveUserMFA(ctx context.Context, userID uuid.UUID, encryptedSecret string) error
+	EnableUserMFA(ctx context.Context, userID uuid.UUID) error
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	DeleteUserMFA(ctx context.Context, userID uuid.UUID) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// IdentityRepository is the persistence interface for accounts linked through OpenID Connect
+type IdentityRepository interface {
+	GetUserIdentity(ctx context.Context, provider, subject string) (UserIdentity, error)
+	CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error)
+	ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]UserIdentity, error)
+}
+
+// ProfileRepository is the persistence interface for player profiles.
+// Implementations must report handles taken case-insensitively as apperrors duplicates.
+type ProfileRepository interface {
+	CreatePlayerProfile(ctx context.Context, arg CreatePlayerProfileParams) (PlayerProfile, error)
+	GetPlayerProfile(ctx context.Context, userID uuid.UUID) (PlayerProfile, error)
+	GetPlayerProfileByHandle(ctx context.Context, handle string) (PlayerProfile, error)
+	UpdatePlayerProfile(ctx context.Context, userID uuid.UUID, arg UpdatePlayerProfileParams) (PlayerProfile, error)
+}
+
+// AuditLogRepository is the persistence interface for the audit log of account operations.
+// Entries are kept after the account is deleted.
+type AuditLogRepository interface {
+	CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) (AuditLog, error)
+	ListAuditLogs(ctx context.Context, userID uuid.UUID) ([]AuditLog, error)
+	AnonymizeAuditLogs(ctx context.Context, userID uuid.UUID) error
+}
+
+// RoleRepository is the persistence interface for roles, their permissions and their assignment to users.
+// The player role every user has is never stored as an assignment.
+type RoleRepository interface {
+	ListRoles(ctx context.Context) ([]Role, error)
+	ListRolePermissions(ctx context.Context, roles []string) ([]RolePermission, error)
+	ListUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	SetUserRoles(ctx context.Context, userID uuid.UUID, roles []string) error
+	CountUsersWithRole(ctx context.Context, role string) (int, error)
+}
+
+var (
+	_ UserRepository         = (*DB)(nil)
+	_ SessionRepository      = (*DB)(nil)
+	_ UserTokenRepository    = (*DB)(nil)
+	_ LoginAttemptRepository = (*DB)(nil)
+	_ RateLimitRepository    = (*DB)(nil)
+	_ MFARepository          = (*DB)(nil)
+	_ IdentityRepository     = (*DB)(nil)
+	_ ProfileRepository      = (*DB)(nil)
+	_ AuditLogRepository     = (*DB)(nil)
+	_ RoleRepository         = (*DB)(nil)
+)
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// ListRoles returns every role, ordered by name
+func (d *DB) ListRoles(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := d.conn(ctx).NewSelect().
+		Model(&roles).
+		Order("name ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list roles")
+	}
+	return roles, nil
+}
+
+// ListRolePermissions returns the permissions granted to the given roles, ordered by role and permission
+func (d *DB) ListRolePermissions(ctx context.Context, roles []string) ([]RolePermission, error) {
+	permissions := []RolePermission{}
+	if len(roles) == 0 {
+		return permissions, nil
+	}
+	err := d.conn(ctx).NewSelect().
+		Model(&permissions).
+		Where("role IN (?)", bun.In(roles)).
+		Order("role ASC", "permission ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list role permissions")
+	}
+	return permissions, nil
+}
+
+// ListUserRoles returns the roles assigned to the user, ordered by name.
+// The implicit player role is not included.
+func (d *DB) ListUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	var roles []string
+	err := d.conn(ctx).NewSelect().
+		Model((*UserRole)(nil)).
+		Column("role").
+		Where("user_id = ?", userID).
+		Order("role ASC").
+		Scan(ctx, &roles)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list roles of user: %s", userID)
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles assigned to the user.
+// An unknown role fails with a foreign key violation.
+func (d *DB) SetUserRoles(ctx context.Context, userID uuid.UUID, roles []string) error {
+	q := d.conn(ctx).NewDelete().
+		Model((*UserRole)(nil)).
+		Where("user_id = ?", userID)
+	if len(roles) > 0 {
+		q = q.Where("role NOT IN (?)", bun.In(roles))
+	}
+	if _, err := q.Exec(ctx); err != nil {
+		return errors.Wrapf(err, "failed to remove roles of user: %s", userID)
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	rows := make([]UserRole, 0, len(roles))
+	for _, role := range roles {
+		rows = append(rows, UserRole{UserID: userID, Role: role})
+	}
+	_, err := d.conn(ctx).NewInsert().
+		Model(&rows).
+		On("CONFLICT (user_id, role) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to assign roles to user: %s", userID)
+	}
+	return nil
+}
+
+// CountUsersWithRole returns the number of users assigned the role
+func (d *DB) CountUsersWithRole(ctx context.Context, role string) (int, error) {
+	n, err := d.conn(ctx).NewSelect().
+		Model((*UserRole)(nil)).
+		Where("role = ?", role).
+		Count(ctx)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to count users with role: %s", role)
+	}
+	return n, nil
+}
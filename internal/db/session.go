@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+)
+
+// ErrSessionInactive is returned when a refresh token belongs to a session that
+// was already rotated, revoked or has expired
+var ErrSessionInactive = errors.New("session is not active")
+
+// CreateSessionParams contains the parameters for creating a session
+type CreateSessionParams struct {
+	FamilyID  uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	UserAgent string
+	IPAddress string
+	ExpiresAt time.Time
+}
+
+// CreateSession stores a new refresh token for the given session family
+func (d *DB) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	session := &Session{
+		FamilyID:  arg.FamilyID,
+		UserID:    arg.UserID,
+		TokenHash: arg.TokenHash,
+		UserAgent: arg.UserAgent,
+		IPAddress: arg.IPAddress,
+		ExpiresAt: arg.ExpiresAt,
+	}
+
+	_, err := d.conn(ctx).NewInsert().Model(session).Returning("*").Exec(ctx)
+	if err != nil {
+		return Session{}, errors.Wrap(err, "failed to create session")
+	}
+
+	return *session, nil
+}
+
+// GetSessionByTokenHash returns the session row that owns the given refresh token hash
+func (d *DB) GetSessionByTokenHash(ctx context.Context, tokenHash string) (Session, error) {
+	var session Session
+	err := d.conn(ctx).NewSelect().
+		Model(&session).
+		Where("token_hash = ?", tokenHash).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Session{}, errors.Wrap(err, "session not found by token hash")
+		}
+		return Session{}, errors.Wrap(err, "failed to get session by token hash")
+	}
+
+	return session, nil
+}
+
+// RotateSession marks the session identified by id as rotated and stores the
+// next refresh token of the same family. If the session was already rotated or
+// revoked, ErrSessionInactive is returned and nothing is written.
+func (d *DB) RotateSession(ctx context.Context, id uuid.UUID, next CreateSessionParams) (Session, error) {
+	var session Session
+	err := d.RunInTx(ctx, func(ctx context.Context) error {
+		res, err := d.conn(ctx).NewUpdate().
+			Model((*Session)(nil)).
+			Set("rotated_at = current_timestamp").
+			Where("id = ?", id).
+			Where("rotated_at IS NULL").
+			Where("revoked_at IS NULL").
+			Where("expires_at > current_timestamp").
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to mark session as rotated")
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return ErrSessionInactive
+		}
+
+		session, err = d.CreateSession(ctx, next)
+		return err
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// RevokeSessionFamily revokes every refresh token belonging to the session family
+func (d *DB) RevokeSessionFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*Session)(nil)).
+		Set("revoked_at = current_timestamp").
+		Where("family_id = ?", familyID).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to revoke session family: %s", familyID)
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of the user except the family exceptFamilyID.
+// Pass uuid.Nil to revoke all of them.
+func (d *DB) RevokeUserSessions(ctx context.Context, userID, exceptFamilyID uuid.UUID) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*Session)(nil)).
+		Set("revoked_at = current_timestamp").
+		Where("user_id = ?", userID).
+		Where("family_id <> ?", exceptFamilyID).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to revoke sessions of user: %s", userID)
+	}
+	return nil
+}
+
+// IsSessionActive reports whether the session family still has a usable refresh token
+func (d *DB) IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	exists, err := d.conn(ctx).NewSelect().
+		Model((*Session)(nil)).
+		Where("family_id = ?", familyID).
+		Where("rotated_at IS NULL").
+		Where("revoked_at IS NULL").
+		Where("expires_at > current_timestamp").
+		Exists(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to check session family: %s", familyID)
+	}
+	return exists, nil
+}
+
+// CountActiveSessions returns the number of session families that still have a usable refresh token
+func (d *DB) CountActiveSessions(ctx context.Context) (int, error) {
+	count, err := d.conn(ctx).NewSelect().
+		Model((*Session)(nil)).
+		Where("rotated_at IS NULL").
+		Where("revoked_at IS NULL").
+		Where("expires_at > current_timestamp").
+		Count(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count active sessions")
+	}
+	return count, nil
+}
+
+// ListUserSessions returns every stored refresh token of the user, oldest first
+func (d *DB) ListUserSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	var sessions []Session
+	err := d.conn(ctx).NewSelect().
+		Model(&sessions).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list sessions of user: %s", userID)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/my-deer/mydeer/internal/db"
+
+// queryTracer is a bun query hook that records a client span for every query.
+// The statement itself is not recorded: bun inlines the arguments, which include password hashes and token hashes.
+type queryTracer struct{}
+
+var _ bun.QueryHook = queryTracer{}
+
+// BeforeQuery starts the query span as a child of the span in ctx
+func (queryTracer) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	operation := event.Operation()
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation.name", operation),
+	}
+
+	name := operation
+	if table := queryTable(event); table != "" {
+		name += " " + table
+		attrs = append(attrs, attribute.String("db.collection.name", table))
+	}
+
+	ctx, _ = otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx
+}
+
+// AfterQuery ends the query span, marking it failed unless the query only found no rows
+func (queryTracer) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+	if event.Result != nil {
+		if n, err := event.Result.RowsAffected(); err == nil {
+			span.SetAttributes(attribute.Int64("db.response.rows_affected", n))
+		}
+	}
+}
+
+func queryTable(event *bun.QueryEvent) string {
+	if q, ok := event.IQuery.(interface{ GetTableName() string }); ok {
+		return q.GetTableName()
+	}
+	return ""
+}
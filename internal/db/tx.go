@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq"
+	"github.com/uptrace/bun"
+)
+
+// PostgreSQL error codes that mean the transaction can be retried as a whole
+const (
+	pqErrSerializationFailure = "40001"
+	pqErrDeadlockDetected     = "40P01"
+)
+
+const defaultMaxRetries = 3
+
+// TxRunner runs a function inside a transaction.
+// Repository methods called with the ctx passed to fn take part in that transaction.
+type TxRunner interface {
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error
+}
+
+var _ TxRunner = (*DB)(nil)
+
+// txOptions configures RunInTx
+type txOptions struct {
+	sqlOpts    sql.TxOptions
+	maxRetries int
+}
+
+// TxOption configures a transaction started by RunInTx
+type TxOption func(*txOptions)
+
+// WithIsolation sets the isolation level of the transaction
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.sqlOpts.Isolation = level
+	}
+}
+
+// WithMaxRetries sets how often a transaction is retried after a serialization failure or deadlock
+func WithMaxRetries(n int) TxOption {
+	return func(o *txOptions) {
+		o.maxRetries = n
+	}
+}
+
+// txKey is the context key of the ambient transaction
+type txKey struct{}
+
+// txState is the ambient transaction and its savepoint counter
+type txState struct {
+	tx         bun.Tx
+	savepoints int
+}
+
+// conn returns the ambient transaction of ctx, or the connection pool when there is none
+func (d *DB) conn(ctx context.Context) bun.IDB {
+	if st, ok := ctx.Value(txKey{}).(*txState); ok {
+		return st.tx
+	}
+	return d.db
+}
+
+// RunInTx runs fn in a transaction that is committed when fn returns nil and rolled back otherwise.
+//
+// Repository methods called with the ctx passed to fn use the transaction transparently.
+// Calling RunInTx again inside fn creates a savepoint, so an inner failure only rolls back
+// the inner work when the outer function handles the error.
+// The outermost transaction is retried on serialization failures and deadlocks, so fn must be
+// safe to run more than once.
+func (d *DB) RunInTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if st, ok := ctx.Value(txKey{}).(*txState); ok {
+		return runInSavepoint(ctx, st, fn)
+	}
+
+	o := txOptions{maxRetries: defaultMaxRetries}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return retryTx(ctx, o.maxRetries, func() error {
+		return d.db.RunInTx(ctx, &o.sqlOpts, func(ctx context.Context, tx bun.Tx) error {
+			return fn(context.WithValue(ctx, txKey{}, &txState{tx: tx}))
+		})
+	})
+}
+
+func runInSavepoint(ctx context.Context, st *txState, fn func(ctx context.Context) error) error {
+	st.savepoints++
+	name := fmt.Sprintf("sp_%d", st.savepoints)
+
+	if _, err := st.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrapf(err, "failed to create savepoint %s", name)
+	}
+
+	if err := fn(ctx); err != nil {
+		if _, rbErr := st.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return errors.CombineErrors(err, errors.Wrapf(rbErr, "failed to roll back to savepoint %s", name))
+		}
+		return err
+	}
+
+	if _, err := st.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.Wrapf(err, "failed to release savepoint %s", name)
+	}
+	return nil
+}
+
+// retryTx runs attempt until it succeeds, fails with a non-retryable error or maxRetries is exhausted
+func retryTx(ctx context.Context, maxRetries int, attempt func() error) error {
+	for i := 0; ; i++ {
+		err := attempt()
+		if err == nil || !isRetryableTxError(err) || i >= maxRetries {
+			return err
+		}
+
+		// ジッター付きの指数バックオフ
+		backoff := time.Duration(1<<i)*10*time.Millisecond + time.Duration(rand.IntN(10))*time.Millisecond
+		select {
+		case <-ctx.Done():
+			return errors.CombineErrors(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// isRetryableTxError reports whether err is a serialization failure or deadlock
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqErrSerializationFailure || pqErr.Code == pqErrDeadlockDetected
+	}
+	return false
+}
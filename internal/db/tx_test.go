@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTxRetriesSerializationFailures(t *testing.T) {
+	attempts := 0
+	err := retryTx(context.Background(), 3, func() error {
+		attempts++
+		if attempts < 3 {
+			return &pq.Error{Code: pqErrSerializationFailure}
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryTxGivesUpAfterMaxRetries(t *testing.T) {
+	attempts := 0
+	err := retryTx(context.Background(), 2, func() error {
+		attempts++
+		return &pq.Error{Code: pqErrDeadlockDetected}
+	})
+
+	assert.True(t, isRetryableTxError(err))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryTxDoesNotRetryOtherErrors(t *testing.T) {
+	attempts := 0
+	err := retryTx(context.Background(), 3, func() error {
+		attempts++
+		return errors.New("boom")
+	})
+
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 1, attempts)
+}
@@ -3,9 +3,12 @@ package db
 import (
 	"context"
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
 	"github.com/uptrace/bun"
 )
 
@@ -13,19 +16,24 @@ import (
 type UserTable struct {
 	bun.BaseModel `bun:"table:users,alias:u"`
 
-	ID        uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
-	Email     string       `bun:"email,notnull,unique" json:"email"`
-	Password  string       `bun:"password,notnull" json:"password"`
-	Name      string       `bun:"name,notnull" json:"name"`
-	CreatedAt sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
-	UpdatedAt sql.NullTime `bun:"updated_at,default:current_timestamp" json:"updated_at"`
+	ID              uuid.UUID    `bun:"id,pk,default:gen_random_uuid()" json:"id"`
+	Email           string       `bun:"email,notnull,unique" json:"email"`
+	Password        string       `bun:"password,notnull" json:"-"`
+	Name            string       `bun:"name,notnull" json:"name"`
+	Locale          string       `bun:"locale,notnull" json:"locale"`
+	EmailVerifiedAt sql.NullTime `bun:"email_verified_at" json:"email_verified_at"`
+	DeleteAfter     sql.NullTime `bun:"delete_after" json:"delete_after"`
+	SuspendedAt     sql.NullTime `bun:"suspended_at" json:"suspended_at"`
+	CreatedAt       sql.NullTime `bun:"created_at,default:current_timestamp" json:"created_at"`
+	UpdatedAt       sql.NullTime `bun:"updated_at,default:current_timestamp" json:"updated_at"`
 }
 
 // CreateUserParams contains the parameters for creating a user
 type CreateUserParams struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
 	Name     string `json:"name"`
+	Locale   string `json:"locale"`
 }
 
 // CreateUserRow represents the returned data from creating a user (without password)
@@ -33,6 +41,7 @@ type CreateUserRow struct {
 	ID        uuid.UUID    `json:"id"`
 	Email     string       `json:"email"`
 	Name      string       `json:"name"`
+	Locale    string       `json:"locale"`
 	CreatedAt sql.NullTime `json:"created_at"`
 	UpdatedAt sql.NullTime `json:"updated_at"`
 }
@@ -43,10 +52,15 @@ func (d *DB) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRo
 		Email:    arg.Email,
 		Password: arg.Password,
 		Name:     arg.Name,
+		Locale:   arg.Locale,
 	}
 
-	_, err := d.db.NewInsert().Model(user).Exec(ctx)
+	_, err := d.conn(ctx).NewInsert().Model(user).Exec(ctx)
 	if err != nil {
+		// 一意制約違反はapperrorsの重複エラーとして返す
+		if dbErr := apperrors.WrapDBError(err); apperrors.IsDuplicate(dbErr) {
+			return CreateUserRow{}, dbErr
+		}
 		return CreateUserRow{}, errors.Wrap(err, "failed to create user")
 	}
 
@@ -54,6 +68,7 @@ func (d *DB) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRo
 		ID:        user.ID,
 		Email:     user.Email,
 		Name:      user.Name,
+		Locale:    user.Locale,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 	}, nil
@@ -62,7 +77,7 @@ func (d *DB) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRo
 // GetUserByEmail returns a user by email
 func (d *DB) GetUserByEmail(ctx context.Context, email string) (User, error) {
 	var user User
-	err := d.db.NewSelect().
+	err := d.conn(ctx).NewSelect().
 		Model(&user).
 		Where("email = ?", email).
 		Scan(ctx)
@@ -76,3 +91,204 @@ func (d *DB) GetUserByEmail(ctx context.Context, email string) (User, error) {
 
 	return user, nil
 }
+
+// MarkEmailVerified records that the user verified the email address.
+// Verifying an already verified user keeps the original timestamp.
+func (d *DB) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*User)(nil)).
+		Set("email_verified_at = COALESCE(email_verified_at, current_timestamp)").
+		Set("updated_at = current_timestamp").
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to mark email verified: %s", id)
+	}
+	return nil
+}
+
+// UpdatePassword replaces the password hash of the user
+func (d *DB) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*User)(nil)).
+		Set("password = ?", passwordHash).
+		Set("updated_at = current_timestamp").
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update password: %s", id)
+	}
+	return nil
+}
+
+// GetUserByID returns a user by id
+func (d *DB) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	var user User
+	err := d.conn(ctx).NewSelect().
+		Model(&user).
+		Where("id = ?", id).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, errors.Wrapf(err, "user not found by id: %s", id)
+		}
+		return User{}, errors.Wrapf(err, "failed to get user by id: %s", id)
+	}
+
+	return user, nil
+}
+
+// ScheduleUserDeletion marks the user for deletion at the given time
+func (d *DB) ScheduleUserDeletion(ctx context.Context, id uuid.UUID, at time.Time) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*User)(nil)).
+		Set("delete_after = ?", at).
+		Set("updated_at = current_timestamp").
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to schedule user deletion: %s", id)
+	}
+	return nil
+}
+
+// CancelUserDeletion clears a scheduled deletion and reports whether there was one
+func (d *DB) CancelUserDeletion(ctx context.Context, id uuid.UUID) (bool, error) {
+	res, err := d.conn(ctx).NewUpdate().
+		Model((*User)(nil)).
+		Set("delete_after = NULL").
+		Set("updated_at = current_timestamp").
+		Where("id = ?", id).
+		Where("delete_after IS NOT NULL").
+		Exec(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to cancel user deletion: %s", id)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to cancel user deletion: %s", id)
+	}
+	return n > 0, nil
+}
+
+// ListUsersDueForDeletion returns up to limit users whose deletion is scheduled at or before now,
+// oldest first
+func (d *DB) ListUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]User, error) {
+	var users []User
+	err := d.conn(ctx).NewSelect().
+		Model(&users).
+		Where("delete_after <= ?", now).
+		Order("delete_after ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list users due for deletion")
+	}
+	return users, nil
+}
+
+// DeleteUserDueForDeletion deletes the user together with every row referencing it (ON DELETE CASCADE)
+// if its deletion is still scheduled at or before now, and reports whether it was deleted.
+// A deletion cancelled after ListUsersDueForDeletion is therefore not carried out.
+func (d *DB) DeleteUserDueForDeletion(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	res, err := d.conn(ctx).NewDelete().
+		Model((*User)(nil)).
+		Where("id = ?", id).
+		Where("delete_after IS NOT NULL").
+		Where("delete_after <= ?", now).
+		Exec(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to delete user: %s", id)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to delete user: %s", id)
+	}
+	return n > 0, nil
+}
+
+// SuspendUser marks the user as suspended and reports whether it was not suspended before
+func (d *DB) SuspendUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	res, err := d.conn(ctx).NewUpdate().
+		Model((*User)(nil)).
+		Set("suspended_at = current_timestamp").
+		Set("updated_at = current_timestamp").
+		Where("id = ?", id).
+		Where("suspended_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to suspend user: %s", id)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to suspend user: %s", id)
+	}
+	return n > 0, nil
+}
+
+// UnsuspendUser lifts the suspension of the user and reports whether it was suspended
+func (d *DB) UnsuspendUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	res, err := d.conn(ctx).NewUpdate().
+		Model((*User)(nil)).
+		Set("suspended_at = NULL").
+		Set("updated_at = current_timestamp").
+		Where("id = ?", id).
+		Where("suspended_at IS NOT NULL").
+		Exec(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to unsuspend user: %s", id)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to unsuspend user: %s", id)
+	}
+	return n > 0, nil
+}
+
+// SearchUsersParams contains the parameters for searching users
+type SearchUsersParams struct {
+	// Query is matched case-insensitively against the email, the name and the handle.
+	// An empty query matches every user.
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// UserSearchRow is a user found by SearchUsers together with the handle of the profile
+type UserSearchRow struct {
+	User   `bun:",extend"`
+	Handle string `bun:"handle"`
+}
+
+// SearchUsers returns a page of the users matching the query, oldest first, and the number of matches
+func (d *DB) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]UserSearchRow, int, error) {
+	rows := []UserSearchRow{}
+	q := d.conn(ctx).NewSelect().
+		Model(&rows).
+		ColumnExpr("u.*").
+		ColumnExpr("COALESCE(pp.handle, '') AS handle").
+		Join("LEFT JOIN player_profiles AS pp ON pp.user_id = u.id")
+	if arg.Query != "" {
+		pattern := "%" + escapeLike(arg.Query) + "%"
+		q = q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("u.email ILIKE ?", pattern).
+				WhereOr("u.name ILIKE ?", pattern).
+				WhereOr("pp.handle ILIKE ?", pattern)
+		})
+	}
+	total, err := q.
+		Order("u.created_at ASC", "u.id ASC").
+		Limit(arg.Limit).
+		Offset(arg.Offset).
+		ScanAndCount(ctx)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to search users")
+	}
+	return rows, total, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern so the text is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+)
+
+// Purposes of user tokens
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
+
+// ErrUserTokenInvalid is returned when a token does not exist, was already used or has expired
+var ErrUserTokenInvalid = errors.New("user token is invalid")
+
+// CreateUserTokenParams contains the parameters for creating a user token
+type CreateUserTokenParams struct {
+	UserID    uuid.UUID
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+// CreateUserToken stores a new single-use token for the user
+func (d *DB) CreateUserToken(ctx context.Context, arg CreateUserTokenParams) (UserToken, error) {
+	token := &UserToken{
+		UserID:    arg.UserID,
+		Purpose:   arg.Purpose,
+		TokenHash: arg.TokenHash,
+		ExpiresAt: arg.ExpiresAt,
+	}
+
+	_, err := d.conn(ctx).NewInsert().Model(token).Returning("*").Exec(ctx)
+	if err != nil {
+		return UserToken{}, errors.Wrap(err, "failed to create user token")
+	}
+
+	return *token, nil
+}
+
+// ConsumeUserToken marks the token as used and returns it.
+// A token can only be consumed once; ErrUserTokenInvalid is returned afterwards,
+// for unknown hashes, other purposes and expired tokens.
+func (d *DB) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (UserToken, error) {
+	var token UserToken
+	err := d.conn(ctx).NewUpdate().
+		Model(&token).
+		Set("used_at = current_timestamp").
+		Where("token_hash = ?", tokenHash).
+		Where("purpose = ?", purpose).
+		Where("used_at IS NULL").
+		Where("expires_at > current_timestamp").
+		Returning("*").
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UserToken{}, ErrUserTokenInvalid
+		}
+		return UserToken{}, errors.Wrap(err, "failed to consume user token")
+	}
+
+	return token, nil
+}
+
+// GetLatestUserToken returns the most recently created token of the user for the purpose
+func (d *DB) GetLatestUserToken(ctx context.Context, userID uuid.UUID, purpose string) (UserToken, error) {
+	var token UserToken
+	err := d.conn(ctx).NewSelect().
+		Model(&token).
+		Where("user_id = ?", userID).
+		Where("purpose = ?", purpose).
+		Order("created_at DESC").
+		Limit(1).
+		Scan(ctx)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UserToken{}, errors.Wrapf(err, "no %s token for user: %s", purpose, userID)
+		}
+		return UserToken{}, errors.Wrapf(err, "failed to get latest %s token", purpose)
+	}
+
+	return token, nil
+}
+
+// InvalidateUserTokens marks every unused token of the user for the purpose as used
+func (d *DB) InvalidateUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error {
+	_, err := d.conn(ctx).NewUpdate().
+		Model((*UserToken)(nil)).
+		Set("used_at = current_timestamp").
+		Where("user_id = ?", userID).
+		Where("purpose = ?", purpose).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to invalidate %s tokens of user: %s", purpose, userID)
+	}
+	return nil
+}
+
+// ListUserTokens returns every single-use token of the user, used or not, oldest first
+func (d *DB) ListUserTokens(ctx context.Context, userID uuid.UUID) ([]UserToken, error) {
+	var tokens []UserToken
+	err := d.conn(ctx).NewSelect().
+		Model(&tokens).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list tokens of user: %s", userID)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,37 @@
+package errors
+
+import "net/http"
+
+// CatalogEntry documents one stable error code returned in the "error" field of error responses
+type CatalogEntry struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// Catalog lists every error code the API returns. Codes are stable and may be used by clients
+// to pick a message; the HTTP status is the usual one for the code.
+// Keep docs/エラーコード.txt in sync when adding codes.
+var Catalog = []CatalogEntry{
+	{ErrDBNotFound, http.StatusNotFound, "The requested resource does not exist"},
+	{ErrDBDuplicate, http.StatusConflict, "A resource with the same unique value already exists (details.field names it)"},
+	{ErrDBConnection, http.StatusInternalServerError, "The database is unreachable"},
+	{ErrDBExecution, http.StatusInternalServerError, "A database operation failed (400 when a referenced resource is missing)"},
+	{ErrDBTransaction, http.StatusInternalServerError, "A database transaction could not be completed"},
+	{ErrAuthInvalid, http.StatusUnauthorized, "Credentials, access token or refresh token are invalid"},
+	{ErrAuthExpired, http.StatusUnauthorized, "The access token has expired; refresh it"},
+	{ErrAuthRequired, http.StatusUnauthorized, "The endpoint requires authentication"},
+	{ErrAuthRevoked, http.StatusUnauthorized, "The session was logged out or revoked; log in again"},
+	{ErrAuthUnverified, http.StatusForbidden, "The email address must be verified before logging in"},
+	{ErrAuthLocked, http.StatusTooManyRequests, "Login is temporarily locked after repeated failures; retry after the Retry-After header"},
+	{ErrAuthSuspended, http.StatusForbidden, "The account was suspended by a moderator and cannot log in"},
+	{ErrAuthForbidden, http.StatusForbidden, "The authenticated account lacks the permission the endpoint requires"},
+	{ErrCSRFInvalid, http.StatusForbidden, "A request authenticated by cookie did not echo the csrf_token cookie in the X-CSRF-Token header"},
+	{ErrMFAAlreadyEnabled, http.StatusConflict, "Two-factor authentication is already enabled; disable it before enrolling again"},
+	{ErrMFANotEnrolled, http.StatusConflict, "Two-factor authentication is not set up (or the setup was not started) for the account"},
+	{ErrSSOUnavailable, http.StatusBadGateway, "The OpenID Connect provider could not be reached; retry later or log in with a password"},
+	{ErrValidation, http.StatusBadRequest, "Input failed validation (details lists field, rule and param)"},
+	{ErrInternal, http.StatusInternalServerError, "Unexpected server error"},
+	{ErrBadRequest, http.StatusBadRequest, "The request body is missing or malformed"},
+	{ErrRateLimited, http.StatusTooManyRequests, "Too many requests; retry after the Retry-After header"},
+}
@@ -20,6 +20,12 @@ func WrapDBError(err error) error {
 		return nil
 	}
 
+	// Already mapped (e.g. by a repository)
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return err
+	}
+
 	// Check for common errors
 	if errors.Is(err, sql.ErrNoRows) {
 		return ErrNotFound
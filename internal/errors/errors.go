@@ -17,16 +17,32 @@ const (
 	ErrDBTransaction = "DB_TRANSACTION"
 
 	// Authentication error codes
-	ErrAuthInvalid  = "AUTH_INVALID"
-	ErrAuthExpired  = "AUTH_EXPIRED"
-	ErrAuthRequired = "AUTH_REQUIRED"
+	ErrAuthInvalid    = "AUTH_INVALID"
+	ErrAuthExpired    = "AUTH_EXPIRED"
+	ErrAuthRequired   = "AUTH_REQUIRED"
+	ErrAuthRevoked    = "AUTH_REVOKED"
+	ErrAuthUnverified = "AUTH_UNVERIFIED"
+	ErrAuthLocked     = "AUTH_LOCKED"
+	ErrAuthSuspended  = "AUTH_SUSPENDED"
+	ErrAuthForbidden  = "AUTH_FORBIDDEN"
+
+	// Cross-site request forgery error codes
+	ErrCSRFInvalid = "CSRF_INVALID"
+
+	// Two-factor authentication error codes
+	ErrMFAAlreadyEnabled = "MFA_ALREADY_ENABLED"
+	ErrMFANotEnrolled    = "MFA_NOT_ENROLLED"
+
+	// Single sign-on error codes
+	ErrSSOUnavailable = "SSO_UNAVAILABLE"
 
 	// Validation error codes
 	ErrValidation = "VALIDATION_ERROR"
 
 	// General error codes
-	ErrInternal   = "INTERNAL_ERROR"
-	ErrBadRequest = "BAD_REQUEST"
+	ErrInternal    = "INTERNAL_ERROR"
+	ErrBadRequest  = "BAD_REQUEST"
+	ErrRateLimited = "RATE_LIMITED"
 )
 
 // AppError represents an application error with code, message, and HTTP status
@@ -48,10 +64,12 @@ func (e *AppError) Unwrap() error {
 	return e.err
 }
 
-// WithDetails adds details to the error
+// WithDetails returns a copy of the error with details attached.
+// The receiver is not modified, so it is safe to call on the shared errors below.
 func (e *AppError) WithDetails(details interface{}) *AppError {
-	e.Details = details
-	return e
+	clone := *e
+	clone.Details = details
+	return &clone
 }
 
 // New creates a new AppError with the given code and message
@@ -85,6 +103,28 @@ var (
 	ErrInternalServer     = New(ErrInternal, "Internal server error", http.StatusInternalServerError)
 	ErrDuplicateEntry     = New(ErrDBDuplicate, "Resource already exists", http.StatusConflict)
 	ErrInvalidInput       = New(ErrValidation, "Invalid input parameters", http.StatusBadRequest)
+	ErrInvalidRefresh     = New(ErrAuthInvalid, "Invalid refresh token", http.StatusUnauthorized)
+	ErrInvalidToken       = New(ErrAuthInvalid, "Invalid token", http.StatusUnauthorized)
+	ErrTokenExpired       = New(ErrAuthExpired, "Token has expired", http.StatusUnauthorized)
+	ErrSessionRevoked     = New(ErrAuthRevoked, "Session has been revoked", http.StatusUnauthorized)
+	ErrUnauthenticated    = New(ErrAuthRequired, "Authentication required", http.StatusUnauthorized)
+	ErrEmailNotVerified   = New(ErrAuthUnverified, "Email address has not been verified", http.StatusForbidden)
+	ErrInvalidEmailToken  = New(ErrAuthInvalid, "Verification token is invalid or expired", http.StatusBadRequest)
+	ErrInvalidResetToken  = New(ErrAuthInvalid, "Password reset token is invalid or expired", http.StatusBadRequest)
+	ErrWrongPassword      = New(ErrAuthInvalid, "Current password is incorrect", http.StatusForbidden)
+	ErrLoginLocked        = New(ErrAuthLocked, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+	ErrTooManyRequests    = New(ErrRateLimited, "Too many requests, try again later", http.StatusTooManyRequests)
+	ErrInvalidMFACode     = New(ErrAuthInvalid, "Authentication code is invalid", http.StatusUnauthorized)
+	ErrInvalidMFAToken    = New(ErrAuthInvalid, "MFA challenge is invalid or expired", http.StatusUnauthorized)
+	ErrMFAEnabled         = New(ErrMFAAlreadyEnabled, "Two-factor authentication is already enabled", http.StatusConflict)
+	ErrMFANotEnabled      = New(ErrMFANotEnrolled, "Two-factor authentication is not set up", http.StatusConflict)
+	ErrSSOFailed          = New(ErrAuthInvalid, "Single sign-on failed", http.StatusUnauthorized)
+	ErrInvalidSSOState    = New(ErrAuthInvalid, "Single sign-on request is invalid or expired", http.StatusBadRequest)
+	ErrSSOEmailUnverified = New(ErrAuthUnverified, "The provider did not confirm the email address", http.StatusForbidden)
+	ErrSSOProviderDown    = New(ErrSSOUnavailable, "The single sign-on provider is unavailable", http.StatusBadGateway)
+	ErrAccountSuspended   = New(ErrAuthSuspended, "The account has been suspended", http.StatusForbidden)
+	ErrPermissionDenied   = New(ErrAuthForbidden, "You do not have permission to perform this operation", http.StatusForbidden)
+	ErrInvalidCSRFToken   = New(ErrCSRFInvalid, "CSRF token is missing or invalid", http.StatusForbidden)
 )
 
 // IsNotFound checks if the error is a not found error
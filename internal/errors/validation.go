@@ -0,0 +1,56 @@
+package errors
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldViolation describes a single field that failed validation
+type FieldViolation struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+	// Message is the human readable message, filled in the locale of the response by ErrorHandler
+	Message string `json:"message,omitempty"`
+}
+
+// FromBindingError converts an error returned by gin's ShouldBind* into an AppError.
+// Validation failures become ErrValidation with one FieldViolation per field,
+// malformed bodies become ErrBadRequest.
+func FromBindingError(err error) *AppError {
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		violations := make([]FieldViolation, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			violations = append(violations, FieldViolation{
+				Field: fe.Field(),
+				Rule:  fe.Tag(),
+				Param: fe.Param(),
+			})
+		}
+		return Wrap(err, ErrValidation, "Invalid input parameters", http.StatusBadRequest).WithDetails(violations)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return Wrap(err, ErrValidation, "Invalid input parameters", http.StatusBadRequest).WithDetails([]FieldViolation{{
+			Field: typeErr.Field,
+			Rule:  "type",
+			Param: typeErr.Type.String(),
+		}})
+	}
+
+	if errors.Is(err, io.EOF) {
+		return Wrap(err, ErrBadRequest, "Request body is required", http.StatusBadRequest)
+	}
+
+	return Wrap(err, ErrBadRequest, "Malformed request body", http.StatusBadRequest)
+}
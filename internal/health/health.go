@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status values reported by the readiness check
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	StatusDraining    = "draining"
+)
+
+// CheckFunc reports whether a dependency is usable
+type CheckFunc func(ctx context.Context) error
+
+// Report is the result of running every registered check
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Checker runs the readiness checks of the registered dependencies (database, caches, ...)
+type Checker struct {
+	mu       sync.RWMutex
+	checks   map[string]CheckFunc
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// NewChecker creates a Checker whose checks are cancelled after timeout
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		checks:  make(map[string]CheckFunc),
+		timeout: timeout,
+	}
+}
+
+// Register adds a named dependency check
+func (h *Checker) Register(name string, check CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// SetDraining marks the server as shutting down so readiness fails
+// and the load balancer stops routing new requests here
+func (h *Checker) SetDraining() {
+	h.draining.Store(true)
+}
+
+// Check runs every registered check concurrently
+func (h *Checker) Check(ctx context.Context) Report {
+	if h.draining.Load() {
+		return Report{Status: StatusDraining}
+	}
+
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		h.mu.RLock()
+		check := h.checks[name]
+		h.mu.RUnlock()
+
+		wg.Add(1)
+		go func(i int, check CheckFunc) {
+			defer wg.Done()
+			results[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]string, len(names))}
+	for i, name := range names {
+		if results[i] != nil {
+			report.Status = StatusUnavailable
+			report.Checks[name] = results[i].Error()
+			continue
+		}
+		report.Checks[name] = StatusOK
+	}
+	return report
+}
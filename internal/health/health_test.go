@@ -0,0 +1,40 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckerReportsFailingDependency(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Register("database", func(ctx context.Context) error { return nil })
+	checker.Register("cache", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	report := checker.Check(context.Background())
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["database"])
+	assert.Equal(t, "connection refused", report.Checks["cache"])
+}
+
+func TestCheckerTimesOutSlowDependency(t *testing.T) {
+	checker := NewChecker(10 * time.Millisecond)
+	checker.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := checker.Check(context.Background())
+	assert.Equal(t, StatusUnavailable, report.Status)
+}
+
+func TestCheckerDraining(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Register("database", func(ctx context.Context) error { return nil })
+	checker.SetDraining()
+
+	assert.Equal(t, StatusDraining, checker.Check(context.Background()).Status)
+}
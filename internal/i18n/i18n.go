@@ -0,0 +1,84 @@
+// Package i18n localizes the messages of error responses.
+//
+// English is the source language: apperrors.AppError.Message is written in English and
+// used as is for English responses, other locales look the message up by error code.
+// Validation messages are looked up by validator tag for every locale.
+package i18n
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Locale is a supported language of the API messages
+type Locale string
+
+const (
+	Japanese Locale = "ja"
+	English  Locale = "en"
+)
+
+// Default is the locale used when neither the user nor the request asks for one
+const Default = Japanese
+
+// Supported lists every locale with a message catalog
+var Supported = []Locale{Japanese, English}
+
+var matcher = language.NewMatcher([]language.Tag{language.Japanese, language.English})
+
+// Parse returns the supported locale for a value like "ja" or "en-US"
+func Parse(s string) (Locale, bool) {
+	tag, err := language.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", false
+	}
+	return match(tag)
+}
+
+// Negotiate picks the best supported locale for an Accept-Language header value,
+// falling back to fallback when the header is empty or nothing matches
+func Negotiate(acceptLanguage string, fallback Locale) Locale {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return fallback
+	}
+	if loc, ok := match(tags...); ok {
+		return loc
+	}
+	return fallback
+}
+
+func match(tags ...language.Tag) (Locale, bool) {
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return "", false
+	}
+	return Supported[index], true
+}
+
+// Message returns the localized message of an error code.
+// For English, and for codes without a translation, fallback is returned.
+func Message(loc Locale, code, fallback string) string {
+	if loc == English {
+		return fallback
+	}
+	if msg, ok := codeMessages[loc][code]; ok {
+		return msg
+	}
+	return fallback
+}
+
+// ValidationMessage returns the localized message of a failed validator rule.
+// "{param}" in the message is replaced by the rule parameter, like 12 in min=12.
+func ValidationMessage(loc Locale, rule, param string) string {
+	messages, ok := ruleMessages[loc]
+	if !ok {
+		messages = ruleMessages[English]
+	}
+	msg, ok := messages[rule]
+	if !ok {
+		msg = messages[""]
+	}
+	return strings.ReplaceAll(msg, "{param}", param)
+}
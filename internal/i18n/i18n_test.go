@@ -0,0 +1,46 @@
+package i18n
+
+import (
+	"testing"
+
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Locale
+	}{
+		{"", Japanese},
+		{"en-US,en;q=0.9", English},
+		{"ja-JP", Japanese},
+		{"fr-FR, en;q=0.5", English},
+		{"de", Japanese},
+		{"en;q=0.3, ja;q=0.8", Japanese},
+		{"!!invalid", Japanese},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.header, Japanese))
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	assert.Equal(t, "認証に失敗しました", Message(Japanese, "AUTH_INVALID", "Invalid token"))
+	assert.Equal(t, "Invalid token", Message(English, "AUTH_INVALID", "Invalid token"))
+	assert.Equal(t, "Something new", Message(Japanese, "UNKNOWN_CODE", "Something new"))
+}
+
+func TestValidationMessage(t *testing.T) {
+	assert.Equal(t, "12文字以上で入力してください", ValidationMessage(Japanese, "min", "12"))
+	assert.Equal(t, "must be at least 12 characters", ValidationMessage(English, "min", "12"))
+	assert.Equal(t, "is invalid", ValidationMessage(English, "unknown", ""))
+}
+
+func TestCatalogIsTranslated(t *testing.T) {
+	for _, entry := range apperrors.Catalog {
+		assert.Contains(t, codeMessages[Japanese], entry.Code)
+	}
+}
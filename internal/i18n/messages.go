@@ -0,0 +1,67 @@
+package i18n
+
+// codeMessages are the translations of the apperrors codes.
+// Keep in sync with internal/errors/catalog.go.
+var codeMessages = map[Locale]map[string]string{
+	Japanese: {
+		"DB_NOT_FOUND":        "指定されたデータが見つかりません",
+		"DB_DUPLICATE":        "既に登録されています",
+		"DB_CONNECTION":       "現在サービスを利用できません。しばらくしてから再度お試しください",
+		"DB_EXECUTION":        "処理に失敗しました",
+		"DB_TRANSACTION":      "処理に失敗しました。もう一度お試しください",
+		"AUTH_INVALID":        "認証に失敗しました",
+		"AUTH_EXPIRED":        "認証の有効期限が切れました",
+		"AUTH_REQUIRED":       "ログインが必要です",
+		"AUTH_REVOKED":        "セッションが無効になりました。再度ログインしてください",
+		"AUTH_UNVERIFIED":     "メールアドレスの確認が完了していません",
+		"AUTH_LOCKED":         "ログインの失敗が続いたため、一時的にログインできません",
+		"AUTH_SUSPENDED":      "このアカウントは利用停止されています",
+		"AUTH_FORBIDDEN":      "この操作を行う権限がありません",
+		"CSRF_INVALID":        "リクエストを確認できませんでした。ページを再読み込みしてください",
+		"MFA_ALREADY_ENABLED": "2段階認証は既に有効です",
+		"MFA_NOT_ENROLLED":    "2段階認証が設定されていません",
+		"SSO_UNAVAILABLE":     "外部サービスでのログインが現在利用できません",
+		"VALIDATION_ERROR":    "入力内容に誤りがあります",
+		"INTERNAL_ERROR":      "サーバーでエラーが発生しました",
+		"BAD_REQUEST":         "リクエストの形式が正しくありません",
+		"RATE_LIMITED":        "リクエストが多すぎます。しばらくしてから再度お試しください",
+	},
+}
+
+// ruleMessages are the messages of the validator tags. The "" key is used for unknown tags.
+var ruleMessages = map[Locale]map[string]string{
+	Japanese: {
+		"":                 "入力値が正しくありません",
+		"required":         "必須項目です",
+		"email":            "メールアドレスの形式が正しくありません",
+		"min":              "{param}文字以上で入力してください",
+		"max":              "{param}文字以内で入力してください",
+		"len":              "{param}文字で入力してください",
+		"oneof":            "次のいずれかを指定してください: {param}",
+		"complexpassword":  "大文字・小文字・数字・記号をそれぞれ1文字以上含めてください",
+		"type":             "値の型が正しくありません（{param}）",
+		"nefield":          "{param}と異なる値を入力してください",
+		"breachedpassword": "過去に流出したことのあるパスワードです。別のパスワードを入力してください",
+		"weakpassword":     "推測されやすいパスワードです。よくある単語・並び・繰り返しを避けてください",
+		"handle":           "英字で始まる3〜20文字の英数字・アンダースコアで入力してください",
+		"reservedhandle":   "このIDは使用できません",
+		"url":              "URLの形式が正しくありません",
+	},
+	English: {
+		"":                 "is invalid",
+		"required":         "is required",
+		"email":            "must be a valid email address",
+		"min":              "must be at least {param} characters",
+		"max":              "must be at most {param} characters",
+		"len":              "must be exactly {param} characters",
+		"oneof":            "must be one of: {param}",
+		"complexpassword":  "must contain an uppercase letter, a lowercase letter, a digit and a symbol",
+		"type":             "must be of type {param}",
+		"nefield":          "must be different from {param}",
+		"breachedpassword": "has appeared in a data breach; choose a different password",
+		"weakpassword":     "is too easy to guess; avoid common words, sequences and repeats",
+		"handle":           "must be 3 to 20 letters, digits or underscores starting with a letter",
+		"reservedhandle":   "is reserved and cannot be used",
+		"url":              "must be a valid URL",
+	},
+}
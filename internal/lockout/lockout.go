@@ -0,0 +1,122 @@
+// Package lockout slows down password guessing by locking accounts and client IPs
+// with an exponentially growing delay after repeated failed logins.
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+// Policy configures the lockout of one kind of key (account or client IP)
+type Policy struct {
+	// Threshold is the number of failures allowed before the first lockout. 0 disables the policy.
+	Threshold int
+	// BaseDelay is the length of the first lockout; every further failure doubles it
+	BaseDelay time.Duration
+	// MaxDelay caps the lockout length
+	MaxDelay time.Duration
+	// ResetAfter forgets the failures when there was none for this long
+	ResetAfter time.Duration
+}
+
+// lockDuration returns how long the key is locked after the given number of failures
+func (p Policy) lockDuration(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// AccountKey returns the key that tracks failures for an email address
+func AccountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the key that tracks failures for a client IP
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Tracker records login failures and reports lockouts
+type Tracker struct {
+	store   db.LoginAttemptRepository
+	account Policy
+	ip      Policy
+	now     func() time.Time
+}
+
+// NewTracker creates a Tracker with separate policies for accounts and client IPs
+func NewTracker(store db.LoginAttemptRepository, account, ip Policy) *Tracker {
+	return &Tracker{
+		store:   store,
+		account: account,
+		ip:      ip,
+		now:     time.Now,
+	}
+}
+
+// Check returns how long the caller must wait before trying to log in to the account from ip.
+// Zero means the login may proceed.
+func (t *Tracker) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := t.now()
+	var wait time.Duration
+	for key := range t.policies(email, ip) {
+		attempt, err := t.store.GetLoginAttempt(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if attempt.LockedUntil.Valid {
+			wait = max(wait, attempt.LockedUntil.Time.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// RecordFailure counts a failed login for the account and ip and locks them once the policy threshold is reached
+func (t *Tracker) RecordFailure(ctx context.Context, email, ip string) error {
+	now := t.now()
+	for key, policy := range t.policies(email, ip) {
+		attempt, err := t.store.IncrementLoginFailures(ctx, key, now, now.Add(-policy.ResetAfter))
+		if err != nil {
+			return err
+		}
+		if d := policy.lockDuration(attempt.Failures); d > 0 {
+			if err := t.store.SetLoginLockout(ctx, key, now.Add(d)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RecordSuccess clears the failures of the account.
+// The client IP counter is kept, so logging in to one's own account does not reset guessing on others.
+func (t *Tracker) RecordSuccess(ctx context.Context, email string) error {
+	if t.account.Threshold <= 0 {
+		return nil
+	}
+	return t.store.DeleteLoginAttempt(ctx, AccountKey(email))
+}
+
+func (t *Tracker) policies(email, ip string) map[string]Policy {
+	policies := make(map[string]Policy, 2)
+	if t.account.Threshold > 0 {
+		policies[AccountKey(email)] = t.account
+	}
+	if t.ip.Threshold > 0 && ip != "" {
+		policies[IPKey(ip)] = t.ip
+	}
+	return policies
+}
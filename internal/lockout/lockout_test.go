@@ -0,0 +1,126 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTracker(now *time.Time) *Tracker {
+	t := NewTracker(NewMemoryStore(time.Hour),
+		Policy{Threshold: 3, BaseDelay: time.Second, MaxDelay: 8 * time.Second, ResetAfter: time.Hour},
+		Policy{Threshold: 10, BaseDelay: time.Second, MaxDelay: time.Minute, ResetAfter: time.Hour},
+	)
+	t.now = func() time.Time { return *now }
+	return t
+}
+
+func TestPolicyLockDuration(t *testing.T) {
+	p := Policy{Threshold: 3, BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+
+	assert.Equal(t, time.Duration(0), p.lockDuration(2))
+	assert.Equal(t, time.Second, p.lockDuration(3))
+	assert.Equal(t, 2*time.Second, p.lockDuration(4))
+	assert.Equal(t, 4*time.Second, p.lockDuration(5))
+	assert.Equal(t, 8*time.Second, p.lockDuration(6))
+	assert.Equal(t, 8*time.Second, p.lockDuration(100))
+	assert.Equal(t, time.Duration(0), Policy{}.lockDuration(100))
+}
+
+func TestTrackerLocksAccountWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tracker := newTestTracker(&now)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, tracker.RecordFailure(ctx, "Player@example.com", "192.0.2.1"))
+	}
+	wait, err := tracker.Check(ctx, "player@example.com", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	require.NoError(t, tracker.RecordFailure(ctx, "player@example.com", "192.0.2.1"))
+	wait, err = tracker.Check(ctx, "player@example.com", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+
+	require.NoError(t, tracker.RecordFailure(ctx, "player@example.com", "192.0.2.1"))
+	wait, err = tracker.Check(ctx, "player@example.com", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// ロックが明けたら再びログインできる
+	now = now.Add(3 * time.Second)
+	wait, err = tracker.Check(ctx, "player@example.com", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	// 成功でアカウントのカウンターは消える
+	require.NoError(t, tracker.RecordSuccess(ctx, "player@example.com"))
+	require.NoError(t, tracker.RecordFailure(ctx, "player@example.com", "192.0.2.1"))
+	wait, err = tracker.Check(ctx, "player@example.com", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestTrackerLocksIPAcrossAccounts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tracker := newTestTracker(&now)
+
+	// 1アカウントあたりの試行は少なくても、同じIPからの失敗が閾値を超えればロック
+	for i := 0; i < 10; i++ {
+		require.NoError(t, tracker.RecordFailure(ctx, "user"+string(rune('a'+i))+"@example.com", "198.51.100.7"))
+	}
+	wait, err := tracker.Check(ctx, "fresh@example.com", "198.51.100.7")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+
+	wait, err = tracker.Check(ctx, "fresh@example.com", "198.51.100.8")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestTrackerForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tracker := newTestTracker(&now)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, tracker.RecordFailure(ctx, "slow@example.com", "192.0.2.1"))
+	}
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, tracker.RecordFailure(ctx, "slow@example.com", "192.0.2.1"))
+
+	wait, err := tracker.Check(ctx, "slow@example.com", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestMemoryStoreSweepsStaleCounters(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore(time.Hour)
+
+	_, err := store.IncrementLoginFailures(ctx, "ip:192.0.2.1", now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = store.IncrementLoginFailures(ctx, "ip:192.0.2.2", now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, store.SetLoginLockout(ctx, "ip:192.0.2.2", now.Add(3*time.Hour)))
+
+	// 保持期間を過ぎた次の失敗の記録で、ロック中でない古いカウンターだけが消える
+	later := now.Add(2 * time.Hour)
+	_, err = store.IncrementLoginFailures(ctx, "ip:192.0.2.3", later, later.Add(-time.Hour))
+	require.NoError(t, err)
+
+	_, err = store.GetLoginAttempt(ctx, "ip:192.0.2.1")
+	assert.Error(t, err)
+	locked, err := store.GetLoginAttempt(ctx, "ip:192.0.2.2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, locked.Failures)
+	_, err = store.GetLoginAttempt(ctx, "ip:192.0.2.3")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,112 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+// MemoryStore keeps the failed login counters in the process; each replica locks on its own
+// and the counters are lost on restart. Counters whose last failure is older than the retention
+// and that are not locked are swept while new failures are recorded.
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]db.LoginAttempt
+	retention time.Duration
+	nextSweep time.Time
+}
+
+var _ db.LoginAttemptRepository = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty MemoryStore. retention should be at least the longest reset_after
+// of the policies, after which a counter starts again from 1 anyway.
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]db.LoginAttempt), retention: retention}
+}
+
+// GetLoginAttempt returns the failure counter of the key
+func (s *MemoryStore) GetLoginAttempt(ctx context.Context, key string) (db.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return db.LoginAttempt{}, errors.Wrapf(sql.ErrNoRows, "login attempt not found: %s", key)
+	}
+	return attempt, nil
+}
+
+// IncrementLoginFailures records a failed login for the key and returns the updated counter.
+// Counters whose last failure is before resetBefore start again from 1.
+func (s *MemoryStore) IncrementLoginFailures(ctx context.Context, key string, now, resetBefore time.Time) (db.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !now.Before(s.nextSweep) {
+		s.sweep(now)
+	}
+
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(resetBefore) {
+		attempt = db.LoginAttempt{Key: key}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	s.attempts[key] = attempt
+	return attempt, nil
+}
+
+// SetLoginLockout locks the key until the given time
+func (s *MemoryStore) SetLoginLockout(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.LockedUntil = sql.NullTime{Time: until, Valid: true}
+		s.attempts[key] = attempt
+	}
+	return nil
+}
+
+// DeleteLoginAttempt forgets the failures of the key, lifting any lockout
+func (s *MemoryStore) DeleteLoginAttempt(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// ListLoginLockouts returns every key that is locked at now, longest lockout first
+func (s *MemoryStore) ListLoginLockouts(ctx context.Context, now time.Time) ([]db.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var locked []db.LoginAttempt
+	for _, attempt := range s.attempts {
+		if attempt.LockedUntil.Valid && attempt.LockedUntil.Time.After(now) {
+			locked = append(locked, attempt)
+		}
+	}
+	slices.SortFunc(locked, func(a, b db.LoginAttempt) int {
+		return b.LockedUntil.Time.Compare(a.LockedUntil.Time)
+	})
+	return locked, nil
+}
+
+// sweep deletes the counters that no longer affect logins. The caller holds s.mu.
+func (s *MemoryStore) sweep(now time.Time) {
+	cutoff := now.Add(-s.retention)
+	for key, attempt := range s.attempts {
+		// ロック中のカウンターは最後の失敗が古くても残す
+		if attempt.LastFailureAt.Before(cutoff) && !(attempt.LockedUntil.Valid && attempt.LockedUntil.Time.After(now)) {
+			delete(s.attempts, key)
+		}
+	}
+	s.nextSweep = now.Add(s.retention)
+}
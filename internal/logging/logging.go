@@ -0,0 +1,164 @@
+// Package logging builds the logger of the server from the logging configuration: the level and
+// format, sampling of repeated lines and redaction of secrets and email addresses by attribute key.
+package logging
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/my-deer/mydeer/internal/config"
+	"golang.org/x/exp/slog"
+)
+
+// Redacted replaces the value of secret attributes
+const Redacted = "[REDACTED]"
+
+// redactKeys are always redacted, as is any key ending in _<key>, e.g. refresh_token or new_password
+var redactKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+// New builds a logger writing to w
+func New(cfg config.LoggingConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, errors.Wrapf(err, "logging: invalid level %q", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: newRedactor(cfg.RedactKeys, cfg.MaskKeys).replace,
+	}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case config.LogFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case config.LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, errors.Newf("logging: unknown format %q", cfg.Format)
+	}
+
+	if cfg.Sampling.Initial > 0 {
+		handler = &samplingHandler{next: handler, sampler: newSampler(cfg.Sampling.Initial, cfg.Sampling.Thereafter)}
+	}
+	return slog.New(handler), nil
+}
+
+// redactor replaces the values of attributes by key. Keys are compared case-insensitively.
+type redactor struct {
+	redact map[string]bool
+	mask   map[string]bool
+}
+
+func newRedactor(redactExtra, maskExtra []string) *redactor {
+	r := &redactor{redact: map[string]bool{}, mask: map[string]bool{"email": true}}
+	for _, key := range append(redactKeys, redactExtra...) {
+		r.redact[strings.ToLower(key)] = true
+	}
+	for _, key := range maskExtra {
+		r.mask[strings.ToLower(key)] = true
+	}
+	return r
+}
+
+func (r *redactor) replace(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	key := strings.ToLower(a.Key)
+	switch {
+	case r.matches(r.redact, key):
+		return slog.String(a.Key, Redacted)
+	case r.matches(r.mask, key):
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	}
+	return a
+}
+
+// matches reports whether key is one of keys or ends in _<one of keys>
+func (r *redactor) matches(keys map[string]bool, key string) bool {
+	if keys[key] {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '_'); i >= 0 {
+		return keys[key[i+1:]]
+	}
+	return false
+}
+
+// MaskEmail keeps the first character of the local part and the domain, e.g. d***@example.com,
+// so support can still tell accounts apart without the log holding the address
+func MaskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// samplingHandler drops debug and info lines the sampler rejects
+type samplingHandler struct {
+	next    slog.Handler
+	sampler *sampler
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn && !h.sampler.allow(r.Level, r.Message, r.Time) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs and WithGroup share the sampler, so loggers derived per request are sampled together
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), sampler: h.sampler}
+}
+
+type samplingKey struct {
+	level   slog.Level
+	message string
+}
+
+// sampler counts the lines per level and message within the current second
+type sampler struct {
+	initial    int
+	thereafter int
+
+	mu     sync.Mutex
+	second int64
+	counts map[samplingKey]int
+}
+
+func newSampler(initial, thereafter int) *sampler {
+	return &sampler{initial: initial, thereafter: thereafter, counts: map[samplingKey]int{}}
+}
+
+func (s *sampler) allow(level slog.Level, message string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if second := at.Unix(); second != s.second {
+		s.second = second
+		clear(s.counts)
+	}
+
+	key := samplingKey{level: level, message: message}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+)
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "info", Format: config.LogFormatJSON, RedactKeys: []string{"otp"}, MaskKeys: []string{"to"}}, &buf)
+	require.NoError(t, err)
+
+	logger.With("email", "deer@example.com").Info("login",
+		"password", "hunter2",
+		"refresh_token", "abc",
+		"Authorization", "Bearer xyz",
+		"otp", "123456",
+		"to", "fox@example.com",
+		"user_id", "42",
+		slog.Group("request", "csrf_token", "def"),
+	)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "d***@example.com", line["email"])
+	assert.Equal(t, Redacted, line["password"])
+	assert.Equal(t, Redacted, line["refresh_token"])
+	assert.Equal(t, Redacted, line["Authorization"])
+	assert.Equal(t, Redacted, line["otp"])
+	assert.Equal(t, "f***@example.com", line["to"])
+	assert.Equal(t, "42", line["user_id"])
+	assert.Equal(t, map[string]any{"csrf_token": Redacted}, line["request"])
+}
+
+func TestMaskEmail(t *testing.T) {
+	assert.Equal(t, "d***@example.com", MaskEmail("deer@example.com"))
+	assert.Equal(t, "***", MaskEmail("not-an-email"))
+	assert.Equal(t, "***", MaskEmail("@example.com"))
+}
+
+func TestLevelAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "warn", Format: config.LogFormatText}, &buf)
+	require.NoError(t, err)
+
+	logger.Info("hidden")
+	logger.Warn("shown", "email", "deer@example.com")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=WARN msg=shown email=d***@example.com")
+
+	_, err = New(config.LoggingConfig{Level: "verbose", Format: config.LogFormatJSON}, &buf)
+	assert.Error(t, err)
+	_, err = New(config.LoggingConfig{Level: "info", Format: "xml"}, &buf)
+	assert.Error(t, err)
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "info", Format: config.LogFormatJSON, Sampling: config.LogSamplingConfig{Initial: 2, Thereafter: 3}}, &buf)
+	require.NoError(t, err)
+
+	// 同じ秒の中で 1, 2 件目と、それ以降は3件ごと（5, 8 件目）だけ出力される
+	requestLogger := logger.With("request_id", "r")
+	for i := 0; i < 9; i++ {
+		requestLogger.Info("repeated")
+	}
+	// 警告以上は間引かない
+	for i := 0; i < 5; i++ {
+		logger.Warn("warning")
+	}
+	assert.Equal(t, 4, strings.Count(buf.String(), `"msg":"repeated"`))
+	assert.Equal(t, 5, strings.Count(buf.String(), `"msg":"warning"`))
+}
+
+func TestSamplerResetsEverySecond(t *testing.T) {
+	s := newSampler(1, 0)
+	now := time.Unix(1700000000, 0)
+
+	assert.True(t, s.allow(slog.LevelInfo, "m", now))
+	assert.False(t, s.allow(slog.LevelInfo, "m", now.Add(100*time.Millisecond)))
+	assert.True(t, s.allow(slog.LevelDebug, "m", now))
+	assert.True(t, s.allow(slog.LevelInfo, "m", now.Add(time.Second)))
+}
@@ -0,0 +1,173 @@
+// Package mail sends transactional emails (verification links, ...) through a pluggable Mailer.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Drivers selectable with Config.Driver
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverStdout = "stdout"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures the Mailer
+type Config struct {
+	// Driver is one of smtp, file, stdout
+	Driver string `yaml:"driver" toml:"driver" json:"driver"`
+	// From is the sender address of every email
+	From string `yaml:"from" toml:"from" json:"from"`
+	// Path is the file emails are appended to with the file driver
+	Path string     `yaml:"path" toml:"path" json:"path"`
+	SMTP SMTPConfig `yaml:"smtp" toml:"smtp" json:"smtp"`
+}
+
+// SMTPConfig configures the SMTP driver. Authentication is skipped when Username is empty.
+type SMTPConfig struct {
+	Host     string `yaml:"host" toml:"host" json:"host"`
+	Port     int    `yaml:"port" toml:"port" json:"port"`
+	Username string `yaml:"username" toml:"username" json:"username"`
+	Password string `yaml:"password" toml:"password" json:"password"`
+}
+
+// New creates the Mailer selected by cfg.Driver
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case DriverSMTP:
+		if cfg.SMTP.Host == "" {
+			return nil, errors.New("mail: smtp driver requires a host")
+		}
+		return NewSMTPMailer(cfg.From, cfg.SMTP), nil
+	case DriverFile:
+		if cfg.Path == "" {
+			return nil, errors.New("mail: file driver requires a path")
+		}
+		return NewFileMailer(cfg.From, cfg.Path), nil
+	case DriverStdout, "":
+		return NewWriterMailer(cfg.From, os.Stdout), nil
+	default:
+		return nil, errors.Newf("mail: unknown driver %q (use smtp, file or stdout)", cfg.Driver)
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	from string
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates an SMTPMailer
+func NewSMTPMailer(from string, cfg SMTPConfig) *SMTPMailer {
+	m := &SMTPMailer{
+		from: from,
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+	}
+	if cfg.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return m
+}
+
+// Send sends msg. net/smtp has no context support, so ctx is only checked before sending.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg)); err != nil {
+		return errors.Wrapf(err, "mail: failed to send to %s via %s", msg.To, m.addr)
+	}
+	return nil
+}
+
+// WriterMailer writes emails to an io.Writer instead of sending them.
+// It is meant for development and tests.
+type WriterMailer struct {
+	mu   sync.Mutex
+	from string
+	w    io.Writer
+}
+
+// NewWriterMailer creates a WriterMailer writing to w
+func NewWriterMailer(from string, w io.Writer) *WriterMailer {
+	return &WriterMailer{from: from, w: w}
+}
+
+// Send writes msg followed by a separator line
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := fmt.Fprintf(m.w, "%s\n----\n", format(m.from, msg)); err != nil {
+		return errors.Wrap(err, "mail: failed to write message")
+	}
+	return nil
+}
+
+// FileMailer appends emails to a file
+type FileMailer struct {
+	mu   sync.Mutex
+	from string
+	path string
+}
+
+// NewFileMailer creates a FileMailer appending to path
+func NewFileMailer(from, path string) *FileMailer {
+	return &FileMailer{from: from, path: path}
+}
+
+// Send appends msg to the file
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "mail: failed to open %s", m.path)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%s\n----\n", format(m.from, msg)); err != nil {
+		return errors.Wrapf(err, "mail: failed to write %s", m.path)
+	}
+	return nil
+}
+
+// format renders msg as an RFC 5322 message with a UTF-8 plain text body
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewWriterMailer("no-reply@example.com", &buf)
+
+	err := m.Send(context.Background(), Message{To: "player@example.com", Subject: "メールアドレスの確認", Body: "line1\nline2"})
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "From: no-reply@example.com\r\n")
+	assert.Contains(t, out, "To: player@example.com\r\n")
+	assert.Contains(t, out, "Subject: =?utf-8?q?")
+	assert.Contains(t, out, "line1\r\nline2")
+}
+
+func TestFileMailerAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m, err := New(Config{Driver: DriverFile, From: "no-reply@example.com", Path: path})
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "first"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "b@example.com", Subject: "second"}))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "To: a@example.com")
+	assert.Contains(t, string(raw), "To: b@example.com")
+}
+
+func TestNewRejectsUnknownDriver(t *testing.T) {
+	_, err := New(Config{Driver: "pigeon"})
+	assert.Error(t, err)
+
+	_, err = New(Config{Driver: DriverSMTP})
+	assert.Error(t, err)
+}
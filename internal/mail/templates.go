@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/my-deer/mydeer/internal/i18n"
+)
+
+// VerificationMessage builds the email asking the player to confirm the address, in the player's locale
+func VerificationMessage(loc i18n.Locale, to, link string, ttl time.Duration) Message {
+	if loc == i18n.English {
+		return Message{
+			To:      to,
+			Subject: "Confirm your email address",
+			Body: fmt.Sprintf("Thanks for signing up for mydeer.\n\n"+
+				"Open the link below to confirm your email address:\n%s\n\n"+
+				"The link expires in %s. If you did not sign up, you can ignore this email.\n", link, formatTTL(ttl)),
+		}
+	}
+	return Message{
+		To:      to,
+		Subject: "メールアドレスの確認",
+		Body: fmt.Sprintf("mydeer へのご登録ありがとうございます。\n\n"+
+			"以下のリンクを開いてメールアドレスを確認してください。\n%s\n\n"+
+			"リンクの有効期限は%sです。心当たりがない場合はこのメールを破棄してください。\n", link, formatTTL(ttl)),
+	}
+}
+
+// PasswordResetMessage builds the email with the password reset link, in the player's locale
+func PasswordResetMessage(loc i18n.Locale, to, link string, ttl time.Duration) Message {
+	if loc == i18n.English {
+		return Message{
+			To:      to,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("A password reset was requested for your mydeer account.\n\n"+
+				"Open the link below to choose a new password:\n%s\n\n"+
+				"The link expires in %s and can be used once. If you did not request this, you can ignore this email.\n", link, formatTTL(ttl)),
+		}
+	}
+	return Message{
+		To:      to,
+		Subject: "パスワードの再設定",
+		Body: fmt.Sprintf("mydeer アカウントのパスワード再設定が要求されました。\n\n"+
+			"以下のリンクを開いて新しいパスワードを設定してください。\n%s\n\n"+
+			"リンクの有効期限は%sで、一度だけ使用できます。心当たりがない場合はこのメールを破棄してください。\n", link, formatTTL(ttl)),
+	}
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int(ttl.Hours()))
+	}
+	return ttl.String()
+}
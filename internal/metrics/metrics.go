@@ -0,0 +1,169 @@
+// Package metrics collects the Prometheus metrics of the server: HTTP requests, the database pool
+// and domain counters such as signups and logins. A nil *Metrics records nothing.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mydeer"
+
+// Login methods
+const (
+	MethodPassword = "password"
+	MethodMFA      = "mfa"
+	MethodOIDC     = "oidc"
+)
+
+// Login failure reasons
+const (
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonLocked             = "locked"
+	ReasonUnverified         = "unverified"
+	ReasonSuspended          = "suspended"
+	ReasonInvalidMFAToken    = "invalid_mfa_token"
+	ReasonInvalidMFACode     = "invalid_mfa_code"
+)
+
+// UnmatchedRoute labels requests that matched no route, so unknown paths do not create new series
+const UnmatchedRoute = "unmatched"
+
+// SessionCounter counts the active sessions
+type SessionCounter interface {
+	CountActiveSessions(ctx context.Context) (int, error)
+}
+
+// Metrics holds the collectors of the server in its own registry
+type Metrics struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.HistogramVec
+	signups       *prometheus.CounterVec
+	loginSuccess  *prometheus.CounterVec
+	loginFailures *prometheus.CounterVec
+}
+
+// New creates the metrics with the Go runtime and process collectors registered
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		signups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signups_total",
+			Help:      "Accounts created, by signup method.",
+		}, []string{"method"}),
+		loginSuccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_success_total",
+			Help:      "Sessions started, by login method.",
+		}, []string{"method"}),
+		loginFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_failures_total",
+			Help:      "Rejected logins, by login method and reason.",
+		}, []string{"method", "reason"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.signups,
+		m.loginSuccess,
+		m.loginFailures,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDB exports the connection pool statistics of db, labelled with name
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterSessions exports the number of active sessions, counted on every scrape within timeout
+func (m *Metrics) RegisterSessions(sessions SessionCounter, timeout time.Duration) {
+	m.registry.MustRegister(&sessionCollector{
+		sessions: sessions,
+		timeout:  timeout,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "active_sessions"),
+			"Session families with a usable refresh token.",
+			nil, nil,
+		),
+	})
+}
+
+// ObserveRequest records a handled HTTP request. route is the registered pattern, e.g. "/players/:handle".
+func (m *Metrics) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Observe(elapsed.Seconds())
+}
+
+// Signup counts an account created with the method
+func (m *Metrics) Signup(method string) {
+	if m == nil {
+		return
+	}
+	m.signups.WithLabelValues(method).Inc()
+}
+
+// LoginSucceeded counts a session started with the method
+func (m *Metrics) LoginSucceeded(method string) {
+	if m == nil {
+		return
+	}
+	m.loginSuccess.WithLabelValues(method).Inc()
+}
+
+// LoginFailed counts a login with the method rejected for the reason
+func (m *Metrics) LoginFailed(method, reason string) {
+	if m == nil {
+		return
+	}
+	m.loginFailures.WithLabelValues(method, reason).Inc()
+}
+
+// sessionCollector queries the active sessions when scraped, so every replica reports the shared total
+type sessionCollector struct {
+	sessions SessionCounter
+	timeout  time.Duration
+	desc     *prometheus.Desc
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	count, err := c.sessions.CountActiveSessions(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
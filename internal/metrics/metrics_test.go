@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSessions struct {
+	count int
+	err   error
+}
+
+func (f fakeSessions) CountActiveSessions(ctx context.Context) (int, error) {
+	return f.count, f.err
+}
+
+func TestCounters(t *testing.T) {
+	m := New()
+
+	m.Signup(MethodPassword)
+	m.LoginSucceeded(MethodPassword)
+	m.LoginSucceeded(MethodPassword)
+	m.LoginFailed(MethodPassword, ReasonInvalidCredentials)
+	m.LoginFailed(MethodMFA, ReasonInvalidMFACode)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.signups.WithLabelValues(MethodPassword)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.loginSuccess.WithLabelValues(MethodPassword)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.loginFailures.WithLabelValues(MethodPassword, ReasonInvalidCredentials)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.loginFailures.WithLabelValues(MethodMFA, ReasonInvalidMFACode)))
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+
+	m.ObserveRequest(http.MethodGet, "/players/:handle", http.StatusOK, 20*time.Millisecond)
+	m.ObserveRequest(http.MethodGet, "/players/:handle", http.StatusNotFound, time.Millisecond)
+	m.ObserveRequest(http.MethodGet, "", http.StatusNotFound, time.Millisecond)
+
+	// 一致しなかったリクエストは1つのラベルにまとめる
+	assert.Equal(t, 3, testutil.CollectAndCount(m.requests))
+	body := gather(t, m)
+	assert.Contains(t, body, `mydeer_http_request_duration_seconds_count{method="GET",route="/players/:handle",status="200"} 1`)
+	assert.Contains(t, body, `mydeer_http_request_duration_seconds_count{method="GET",route="/players/:handle",status="404"} 1`)
+	assert.Contains(t, body, `mydeer_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+}
+
+func TestActiveSessions(t *testing.T) {
+	m := New()
+	m.RegisterSessions(fakeSessions{count: 7}, time.Second)
+	assert.Contains(t, gather(t, m), "mydeer_active_sessions 7")
+
+	failing := New()
+	failing.RegisterSessions(fakeSessions{err: errors.New("database down")}, time.Second)
+	w := httptest.NewRecorder()
+	failing.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestNilMetricsRecordsNothing(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.ObserveRequest(http.MethodGet, "/", http.StatusOK, time.Millisecond)
+		m.Signup(MethodOIDC)
+		m.LoginSucceeded(MethodOIDC)
+		m.LoginFailed(MethodOIDC, ReasonSuspended)
+	})
+}
+
+func gather(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
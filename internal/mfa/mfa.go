@@ -0,0 +1,302 @@
+// Package mfa implements optional TOTP two-factor authentication with recovery codes.
+package mfa
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+
+	// Prefixes of the stored secret format
+	secretPrefixAESGCM = "v1:"
+	secretPrefixPlain  = "plain:"
+)
+
+var (
+	// ErrAlreadyEnabled is returned when enrolling while 2FA is already enabled
+	ErrAlreadyEnabled = errors.New("mfa: already enabled")
+	// ErrNotEnrolled is returned when there is no (pending or enabled) enrollment for the operation
+	ErrNotEnrolled = errors.New("mfa: not enrolled")
+	// ErrInvalidCode is returned when a TOTP or recovery code does not match
+	ErrInvalidCode = errors.New("mfa: invalid code")
+)
+
+// Status is the 2FA state of a user
+type Status struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// Enrollment is returned when a player starts the TOTP setup
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// Service manages TOTP enrollments. Secrets are encrypted with AES-GCM when a key is configured.
+type Service struct {
+	repo   db.MFARepository
+	issuer string
+	aead   cipher.AEAD
+	now    func() time.Time
+}
+
+// NewService creates a Service. key must be empty (secrets stored unencrypted, for development)
+// or 32 bytes for AES-256-GCM.
+func NewService(repo db.MFARepository, issuer string, key []byte) (*Service, error) {
+	s := &Service{repo: repo, issuer: issuer, now: time.Now}
+	if len(key) == 0 {
+		return s, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "mfa: invalid encryption key")
+	}
+	s.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "mfa: failed to create cipher")
+	}
+	return s, nil
+}
+
+// Status returns the 2FA state of the user
+func (s *Service) Status(ctx context.Context, userID uuid.UUID) (Status, error) {
+	m, err := s.repo.GetUserMFA(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !m.EnabledAt.Valid) {
+		return Status{}, nil
+	}
+	if err != nil {
+		return Status{}, err
+	}
+
+	n, err := s.repo.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Enabled: true, RecoveryCodesRemaining: n}, nil
+}
+
+// Enabled reports whether login requires a second factor for the user
+func (s *Service) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	m, err := s.repo.GetUserMFA(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.EnabledAt.Valid, nil
+}
+
+// BeginEnrollment generates a new TOTP secret for the user. 2FA is not enabled until
+// ConfirmEnrollment is called with a code from the authenticator app.
+func (s *Service) BeginEnrollment(ctx context.Context, userID uuid.UUID, account string) (Enrollment, error) {
+	if enabled, err := s.Enabled(ctx, userID); err != nil {
+		return Enrollment{}, err
+	} else if enabled {
+		return Enrollment{}, ErrAlreadyEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return Enrollment{}, err
+	}
+	sealed, err := s.seal(secret)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if err := s.repo.SaveUserMFA(ctx, userID, sealed); err != nil {
+		return Enrollment{}, err
+	}
+
+	return Enrollment{Secret: secret, URI: totpURI(s.issuer, account, secret)}, nil
+}
+
+// ConfirmEnrollment enables 2FA when code matches the pending secret and returns the recovery codes.
+// The codes are only shown here; the database keeps their hashes.
+func (s *Service) ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	m, err := s.repo.GetUserMFA(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.EnabledAt.Valid {
+		return nil, ErrAlreadyEnabled
+	}
+
+	if err := s.verifyTOTP(ctx, m, code); err != nil {
+		return nil, err
+	}
+	if err := s.repo.EnableUserMFA(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.RegenerateRecoveryCodes(ctx, userID)
+}
+
+// Verify checks a TOTP code or, failing that, an unused recovery code of a user with 2FA enabled.
+// Both kinds of code can be used only once.
+func (s *Service) Verify(ctx context.Context, userID uuid.UUID, code string) error {
+	m, err := s.repo.GetUserMFA(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !m.EnabledAt.Valid) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.verifyTOTP(ctx, m, code)
+	}
+
+	ok, err := s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Disable removes the enrollment and recovery codes of the user
+func (s *Service) Disable(ctx context.Context, userID uuid.UUID) error {
+	if enabled, err := s.Enabled(ctx, userID); err != nil {
+		return err
+	} else if !enabled {
+		return ErrNotEnrolled
+	}
+	return s.repo.DeleteUserMFA(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the user with new ones
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	if enabled, err := s.Enabled(ctx, userID); err != nil {
+		return nil, err
+	} else if !enabled {
+		return nil, ErrNotEnrolled
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i], hashes[i] = code, hashRecoveryCode(code)
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *Service) verifyTOTP(ctx context.Context, m db.UserMFA, code string) error {
+	secret, err := s.open(m.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := validateTOTP(secret, code, s.now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	// 一度使われたコードは（有効期間内でも）再利用させない
+	fresh, err := s.repo.UseTOTPStep(ctx, m.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// seal encrypts the secret for storage
+func (s *Service) seal(secret string) (string, error) {
+	if s.aead == nil {
+		return secretPrefixPlain + secret, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "mfa: failed to generate nonce")
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return secretPrefixAESGCM + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a secret stored by seal
+func (s *Service) open(stored string) (string, error) {
+	if secret, ok := strings.CutPrefix(stored, secretPrefixPlain); ok {
+		return secret, nil
+	}
+	encoded, ok := strings.CutPrefix(stored, secretPrefixAESGCM)
+	if !ok {
+		return "", errors.New("mfa: unknown secret format")
+	}
+	if s.aead == nil {
+		return "", errors.New("mfa: secret is encrypted but no encryption key is configured")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("mfa: malformed secret")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "mfa: failed to decrypt secret")
+	}
+	return string(plain), nil
+}
+
+// newRecoveryCode returns a random code formatted like "abcd-efgh-ijkl-mnop"
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "mfa: failed to generate recovery code")
+	}
+	raw := strings.ToLower(base32NoPadding.EncodeToString(buf))
+	return raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16], nil
+}
+
+// hashRecoveryCode normalizes the code (case, separators) and hashes it.
+// Recovery codes have 80 bits of entropy, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// CurrentCode returns the TOTP code of a base32 secret at t. It is meant for tests and tooling.
+func CurrentCode(secret string, t time.Time) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "mfa: invalid secret")
+	}
+	return totpCode(key, totpStep(t)), nil
+}
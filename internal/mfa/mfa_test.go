@@ -0,0 +1,50 @@
+package mfa
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretIsEncryptedAtRest(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	user, err := store.CreateUser(ctx, db.CreateUserParams{Email: "mfa@example.com", Password: "x", Name: "mfa"})
+	require.NoError(t, err)
+
+	service, err := NewService(store, "mydeer", []byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	enrollment, err := service.BeginEnrollment(ctx, user.ID, user.Email)
+	require.NoError(t, err)
+
+	stored, err := store.GetUserMFA(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.TOTPSecret, secretPrefixAESGCM))
+	assert.NotContains(t, stored.TOTPSecret, enrollment.Secret)
+
+	secret, err := service.open(stored.TOTPSecret)
+	require.NoError(t, err)
+	assert.Equal(t, enrollment.Secret, secret)
+
+	// 別の鍵では復号できない
+	other, err := NewService(store, "mydeer", []byte(strings.Repeat("o", 32)))
+	require.NoError(t, err)
+	_, err = other.open(stored.TOTPSecret)
+	assert.Error(t, err)
+}
+
+func TestRecoveryCodeNormalization(t *testing.T) {
+	code, err := newRecoveryCode()
+	require.NoError(t, err)
+	assert.Regexp(t, `^[a-z2-7]{4}(-[a-z2-7]{4}){3}$`, code)
+
+	compact := strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+	assert.Equal(t, hashRecoveryCode(code), hashRecoveryCode(compact))
+	assert.NotEqual(t, hashRecoveryCode(code), hashRecoveryCode(uuid.NewString()))
+}
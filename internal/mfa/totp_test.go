@@ -0,0 +1,55 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 Appendix B (SHA1), truncated to 6 digits
+func TestTOTPCodeRFCVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, totpCode(key, totpStep(time.Unix(tt.unix, 0))), "t=%d", tt.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	step, ok := validateTOTP(secret, "081804", now)
+	assert.True(t, ok)
+	assert.Equal(t, totpStep(now), step)
+
+	// 前後1ステップの時計ずれは許容
+	_, ok = validateTOTP(secret, "081804", now.Add(30*time.Second))
+	assert.True(t, ok)
+	_, ok = validateTOTP(secret, "081804", now.Add(90*time.Second))
+	assert.False(t, ok)
+
+	_, ok = validateTOTP(strings.ToLower(secret), "000000", now)
+	assert.False(t, ok)
+	_, ok = validateTOTP("not base32!", "081804", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("mydeer", "player@example.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/mydeer:player@example.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=mydeer")
+}
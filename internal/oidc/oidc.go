@@ -0,0 +1,349 @@
+// Package oidc is a minimal OpenID Connect relying party: authorization code flow with PKCE,
+// discovery, and ID token validation against the provider JWKS. It works with any compliant provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// discoveryPath is appended to the issuer to find the provider metadata
+const discoveryPath = "/.well-known/openid-configuration"
+
+// clockSkew is the leeway allowed when checking exp and iat of ID tokens
+const clockSkew = time.Minute
+
+var (
+	// ErrProviderUnavailable is returned when the provider cannot be reached or answers with a server error
+	ErrProviderUnavailable = errors.New("oidc: provider unavailable")
+	// ErrExchangeRejected is returned when the token endpoint refuses the authorization code
+	ErrExchangeRejected = errors.New("oidc: authorization code rejected")
+	// ErrInvalidIDToken is returned when the ID token fails validation
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+)
+
+// ProviderConfig configures one OpenID Connect provider
+type ProviderConfig struct {
+	// Name identifies the provider in URLs (/auth/oidc/<name>/login) and linked identities
+	Name         string `yaml:"name" toml:"name"`
+	Issuer       string `yaml:"issuer" toml:"issuer"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	// Scopes requested in addition to "openid". Defaults to email and profile.
+	Scopes []string `yaml:"scopes" toml:"scopes"`
+}
+
+// Identity is the verified account returned by a provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// metadata is the part of the discovery document used by the client
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is the client of one provider. The discovery document is fetched on first use
+// and cached, so the application starts even when the provider is down.
+type Provider struct {
+	cfg         ProviderConfig
+	redirectURL string
+	client      *http.Client
+	now         func() time.Time
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// NewProvider creates the client of a provider. redirectURL is the callback registered at the provider.
+func NewProvider(cfg ProviderConfig, redirectURL string, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	return &Provider{cfg: cfg, redirectURL: redirectURL, client: client, now: time.Now}
+}
+
+// Name returns the configured provider name
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL returns the authorization endpoint URL the browser is redirected to.
+// state and nonce must be random per login; the PKCE challenge is derived from verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the identity of the validated ID token.
+// nonce must be the value sent in AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "oidc: failed to build token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Identity{}, errors.Mark(errors.Wrap(err, "oidc: token request failed"), ErrProviderUnavailable)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Identity{}, errors.Mark(errors.Wrap(err, "oidc: failed to read token response"), ErrProviderUnavailable)
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		return Identity{}, errors.Wrapf(ErrProviderUnavailable, "token endpoint returned %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return Identity{}, errors.Wrapf(ErrExchangeRejected, "token endpoint returned %d: %s", resp.StatusCode, truncate(body))
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "token response has no id_token")
+	}
+
+	return p.verifyIDToken(ctx, meta, token.IDToken, nonce)
+}
+
+// idTokenClaims are the standard claims read from ID tokens
+type idTokenClaims struct {
+	Nonce             string   `json:"nonce"`
+	AuthorizedParty   string   `json:"azp"`
+	Email             string   `json:"email"`
+	EmailVerified     flexBool `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// flexBool accepts true and "true"; some providers send email_verified as a string
+type flexBool bool
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = flexBool(v)
+	case string:
+		*b = flexBool(v == "true")
+	}
+	return nil
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, meta *metadata, raw, nonce string) (Identity, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithTimeFunc(p.now),
+	)
+
+	claims := &idTokenClaims{}
+	if _, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(ctx, kid)
+	}); err != nil {
+		if errors.Is(err, ErrProviderUnavailable) {
+			return Identity{}, err
+		}
+		return Identity{}, errors.Mark(errors.Wrap(err, "oidc: id token validation failed"), ErrInvalidIDToken)
+	}
+
+	// 複数のaudienceを持つトークンは azp が自分宛てであることも確認する（OIDC Core 3.1.3.7）
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "azp does not match the client id")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "sub claim is missing")
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	return Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          name,
+	}, nil
+}
+
+// metadata returns the cached discovery document, fetching it when needed
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.cfg.Issuer, "/")+discoveryPath, &meta); err != nil {
+		return nil, err
+	}
+	// 発行者が設定と一致しないメタデータは信用しない（OIDC Discovery 4.3）
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, errors.Wrapf(ErrProviderUnavailable, "issuer mismatch: configured %q, discovered %q", p.cfg.Issuer, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.Wrap(ErrProviderUnavailable, "discovery document is incomplete")
+	}
+
+	p.meta = &meta
+	p.keys = newKeySet(meta.JWKSURI, p)
+	return p.meta, nil
+}
+
+// verificationKey returns the provider key that signed an ID token. metadata must have been loaded.
+func (p *Provider) verificationKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+	return keys.get(ctx, kid)
+}
+
+// getJSON fetches url and decodes the JSON body. Failures are marked ErrProviderUnavailable.
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "oidc: failed to build request")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Mark(errors.Wrapf(err, "oidc: GET %s failed", url), ErrProviderUnavailable)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrapf(ErrProviderUnavailable, "GET %s returned %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return errors.Mark(errors.Wrapf(err, "oidc: invalid JSON from %s", url), ErrProviderUnavailable)
+	}
+	return nil
+}
+
+// NewRandomValue returns a random URL-safe value for state, nonce and the PKCE verifier
+func NewRandomValue() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "oidc: failed to generate random value")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of verifier (RFC 7636)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func truncate(body []byte) string {
+	if len(body) > 200 {
+		body = body[:200]
+	}
+	return string(body)
+}
+
+// Registry holds the configured providers by name
+type Registry struct {
+	providers map[string]*Provider
+	names     []string
+}
+
+// NewRegistry creates the clients of the configured providers.
+// redirectURL returns the callback URL of a provider name.
+func NewRegistry(cfgs []ProviderConfig, redirectURL func(name string) string, client *http.Client) *Registry {
+	r := &Registry{providers: make(map[string]*Provider, len(cfgs))}
+	for _, cfg := range cfgs {
+		r.providers[cfg.Name] = NewProvider(cfg, redirectURL(cfg.Name), client)
+		r.names = append(r.names, cfg.Name)
+	}
+	return r
+}
+
+// Get returns the provider named name
+func (r *Registry) Get(name string) (*Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names returns the provider names in configuration order
+func (r *Registry) Names() []string {
+	return r.names
+}
@@ -0,0 +1,113 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/my-deer/mydeer/internal/oidc"
+	"github.com/my-deer/mydeer/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/mock/callback"
+
+// authorize runs the browser part of the flow and returns the code and state sent to the callback
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) (string, string) {
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	require.NoError(t, err)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server := oidctest.NewServer("mydeer", "s3cret")
+	defer server.Close()
+	server.SetUser(oidctest.User{Subject: "user-1", Email: "sso@example.com", EmailVerified: true, Name: "SSO User"})
+
+	p := oidc.NewProvider(server.Config("mock"), redirectURL, nil)
+	verifier, _ := oidc.NewRandomValue()
+
+	code, state := authorize(t, p, "state-1", "nonce-1", verifier)
+	assert.Equal(t, "state-1", state)
+
+	identity, err := p.Exchange(context.Background(), code, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, oidc.Identity{Subject: "user-1", Email: "sso@example.com", EmailVerified: true, Name: "SSO User"}, identity)
+
+	// 認可コードは1回だけ
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce-1")
+	assert.True(t, errors.Is(err, oidc.ErrExchangeRejected))
+}
+
+func TestExchangeRejectsInvalidResponses(t *testing.T) {
+	server := oidctest.NewServer("mydeer", "s3cret")
+	defer server.Close()
+	server.SetUser(oidctest.User{Subject: "user-1", Email: "sso@example.com"})
+
+	p := oidc.NewProvider(server.Config("mock"), redirectURL, nil)
+	verifier, _ := oidc.NewRandomValue()
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		code, _ := authorize(t, p, "s", "n", verifier)
+		_, err := p.Exchange(context.Background(), code, verifier+"x", "n")
+		assert.True(t, errors.Is(err, oidc.ErrExchangeRejected))
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		code, _ := authorize(t, p, "s", "n", verifier)
+		_, err := p.Exchange(context.Background(), code, verifier, "other")
+		assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken))
+	})
+
+	tampered := map[string]func(jwt.MapClaims){
+		"audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":  func(c jwt.MapClaims) { c["exp"] = 1 },
+		"azp":      func(c jwt.MapClaims) { c["aud"] = []string{"mydeer", "other"}; c["azp"] = "other" },
+	}
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			code, _ := authorize(t, p, "s", "n", verifier)
+			server.TamperNextIDToken(tamper)
+			_, err := p.Exchange(context.Background(), code, verifier, "n")
+			assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken), "%v", err)
+		})
+	}
+}
+
+func TestWrongClientSecret(t *testing.T) {
+	server := oidctest.NewServer("mydeer", "s3cret")
+	defer server.Close()
+
+	cfg := server.Config("mock")
+	cfg.ClientSecret = "wrong"
+	p := oidc.NewProvider(cfg, redirectURL, nil)
+	verifier, _ := oidc.NewRandomValue()
+
+	code, _ := authorize(t, p, "s", "n", verifier)
+	_, err := p.Exchange(context.Background(), code, verifier, "n")
+	assert.True(t, errors.Is(err, oidc.ErrExchangeRejected))
+}
+
+func TestUnreachableProvider(t *testing.T) {
+	server := oidctest.NewServer("mydeer", "s3cret")
+	cfg := server.Config("mock")
+	server.Close()
+
+	p := oidc.NewProvider(cfg, redirectURL, nil)
+	_, err := p.AuthCodeURL(context.Background(), "s", "n", "v")
+	assert.True(t, errors.Is(err, oidc.ErrProviderUnavailable))
+}
@@ -0,0 +1,154 @@
+package passcheck
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// prefixLength is the length of the SHA-1 prefix of the k-anonymity range files
+const prefixLength = 5
+
+// BreachList looks up passwords known from data breaches by their SHA-1 hash
+type BreachList interface {
+	// Occurrences returns how many times the password with the upper case hex SHA-1 hash
+	// was seen in breaches, or 0 when it is not listed
+	Occurrences(hash string) (int, error)
+}
+
+// HashPassword returns the upper case hex SHA-1 hash of password, as used by Have I Been Pwned
+func HashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// bundledList is the built-in list of common passwords. Entries have no breach count and
+// are reported as seen once.
+type bundledList map[string]bool
+
+var bundled = func() bundledList {
+	list := make(bundledList, len(commonPasswords))
+	for password := range commonPasswords {
+		list[HashPassword(password)] = true
+	}
+	return list
+}()
+
+// Bundled returns the built-in list of the most common passwords
+func Bundled() BreachList {
+	return bundled
+}
+
+func (l bundledList) Occurrences(hash string) (int, error) {
+	if l[hash] {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Open loads a breached password list in the Have I Been Pwned Pwned Passwords format.
+//
+// A directory holds one k-anonymity range file per SHA-1 prefix, as written by the
+// PwnedPasswordsDownloader: <PREFIX>.txt with "SUFFIX:COUNT" lines, the same body the range API
+// returns. Only the file of the looked up prefix is read, so the full dump can be used.
+//
+// A file holds "HASH:COUNT" lines (the downloader's single file output) and is loaded into
+// memory, so it suits curated subsets.
+//
+// Entries seen fewer than minCount times are ignored.
+func Open(path string, minCount int) (BreachList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "passcheck: failed to open breached password list")
+	}
+	if info.IsDir() {
+		return &rangeDir{dir: path, minCount: minCount}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "passcheck: failed to open breached password list")
+	}
+	defer f.Close()
+
+	list := make(hashList)
+	err = scanEntries(f, func(hash string, count int) {
+		if len(hash) == sha1.Size*2 && count >= minCount {
+			list[hash] = count
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "passcheck: failed to read %s", path)
+	}
+	return list, nil
+}
+
+// hashList is a breached password list loaded into memory, keyed by full hash
+type hashList map[string]int
+
+func (l hashList) Occurrences(hash string) (int, error) {
+	return l[hash], nil
+}
+
+// rangeDir is a directory of k-anonymity range files
+type rangeDir struct {
+	dir      string
+	minCount int
+}
+
+func (d *rangeDir) Occurrences(hash string) (int, error) {
+	if len(hash) != sha1.Size*2 {
+		return 0, errors.Newf("passcheck: invalid SHA-1 hash %q", hash)
+	}
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	f, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "passcheck: failed to open range file")
+	}
+	defer f.Close()
+
+	found := 0
+	err = scanEntries(f, func(entry string, count int) {
+		if entry == suffix && count >= d.minCount {
+			found = count
+		}
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "passcheck: failed to read range file %s", prefix)
+	}
+	return found, nil
+}
+
+// scanEntries calls fn with the upper case hash and count of every "HASH:COUNT" line.
+// A missing count (plain hash lists) counts as 1.
+func scanEntries(r io.Reader, fn func(hash string, count int)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, countText, hasCount := strings.Cut(line, ":")
+		count := 1
+		if hasCount {
+			n, err := strconv.Atoi(strings.TrimSpace(countText))
+			if err != nil {
+				return errors.Newf("invalid count in line %q", line)
+			}
+			count = n
+		}
+		fn(strings.ToUpper(hash), count)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,576 @@
+package passcheck
+
+import (
+	"bufio"
+	_ "embed"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The estimator follows zxcvbn (Wheeler, USENIX Security 2016): the password is split into the
+// sequence of known patterns (dictionary words, sequences, repeats, keyboard walks, years and
+// bruteforce runs) that needs the fewest guesses, and the guess count is mapped to a 0-4 score.
+const (
+	bruteforceCardinality           = 10
+	minSubmatchGuessesSingleChar    = 10
+	minSubmatchGuessesMultiChar     = 50
+	minGuessesBeforeGrowingSequence = 10000
+	minYearSpace                    = 20
+
+	// maxAnalyzedLength bounds the work on very long input; the rest only adds strength
+	maxAnalyzedLength = 100
+)
+
+// Patterns reported in Strength.Pattern
+const (
+	PatternDictionary = "dictionary"
+	PatternSequence   = "sequence"
+	PatternRepeat     = "repeat"
+	PatternSpatial    = "spatial"
+	PatternYear       = "year"
+	PatternBruteforce = "bruteforce"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords maps the bundled common passwords to their frequency rank (1 = most common)
+var commonPasswords = rankedDictionary(commonPasswordsFile)
+
+// Strength is the estimated resistance of a password to guessing
+type Strength struct {
+	// Score is 0 (too guessable) to 4 (very unguessable), like zxcvbn
+	Score int
+	// Guesses is the estimated number of guesses an attacker needs
+	Guesses float64
+	// Pattern names the most guessable part of the password, e.g. "dictionary".
+	// It is "bruteforce" when no known pattern was found.
+	Pattern string
+}
+
+// match is a part of the password, runes i to j inclusive, explained by a pattern
+type match struct {
+	i, j    int
+	pattern string
+	guesses float64
+}
+
+// Estimate estimates the strength of password. userInputs like the email address and name
+// of the player are treated as dictionary words, since attackers try them first.
+func Estimate(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes) > maxAnalyzedLength {
+		runes = runes[:maxAnalyzedLength]
+	}
+	if len(runes) == 0 {
+		return Strength{Score: 0, Guesses: 1, Pattern: PatternBruteforce}
+	}
+
+	guesses, sequence := mostGuessableSequence(runes, omnimatch(runes, userDictionary(userInputs)))
+	return Strength{
+		Score:   score(guesses),
+		Guesses: guesses,
+		Pattern: weakestPattern(sequence),
+	}
+}
+
+// score maps a guess count to 0-4 with the zxcvbn thresholds
+func score(guesses float64) int {
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return 0
+	case guesses < 1e6+delta:
+		return 1
+	case guesses < 1e8+delta:
+		return 2
+	case guesses < 1e10+delta:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func weakestPattern(sequence []match) string {
+	weakest := PatternBruteforce
+	lowest := math.Inf(1)
+	for _, m := range sequence {
+		if m.pattern != PatternBruteforce && m.guesses < lowest {
+			weakest, lowest = m.pattern, m.guesses
+		}
+	}
+	return weakest
+}
+
+func rankedDictionary(list string) map[string]int {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if _, seen := ranks[word]; word != "" && !seen {
+			ranks[word] = len(ranks) + 1
+		}
+	}
+	return ranks
+}
+
+// userDictionary ranks the user inputs and their parts, e.g. "taro" and "example" of taro@example.com
+func userDictionary(inputs []string) map[string]int {
+	ranks := make(map[string]int)
+	add := func(word string) {
+		if _, seen := ranks[word]; len([]rune(word)) >= 3 && !seen {
+			ranks[word] = len(ranks) + 1
+		}
+	}
+	for _, input := range inputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		add(input)
+		for _, part := range strings.FieldsFunc(input, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			add(part)
+		}
+	}
+	return ranks
+}
+
+func omnimatch(runes []rune, userDict map[string]int) []match {
+	var matches []match
+	for _, dict := range []map[string]int{commonPasswords, userDict} {
+		matches = append(matches, dictionaryMatches(runes, dict)...)
+		matches = append(matches, reverseDictionaryMatches(runes, dict)...)
+		matches = append(matches, l33tMatches(runes, dict)...)
+	}
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes, userDict)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, yearMatches(runes)...)
+	return matches
+}
+
+// dictionaryMatches finds words of at least three letters, case-insensitively
+func dictionaryMatches(runes []rune, dict map[string]int) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		return nil
+	}
+	var matches []match
+	for i := range lower {
+		for j := i + 2; j < len(lower); j++ {
+			if rank, ok := dict[string(lower[i:j+1])]; ok {
+				matches = append(matches, match{i: i, j: j, pattern: PatternDictionary, guesses: float64(rank) * uppercaseVariations(runes[i:j+1])})
+			}
+		}
+	}
+	return matches
+}
+
+// reverseDictionaryMatches finds words spelled backwards, which doubles their guesses
+func reverseDictionaryMatches(runes []rune, dict map[string]int) []match {
+	n := len(runes)
+	reversed := make([]rune, n)
+	for i, r := range runes {
+		reversed[n-1-i] = r
+	}
+	matches := dictionaryMatches(reversed, dict)
+	for k, m := range matches {
+		matches[k] = match{i: n - 1 - m.j, j: n - 1 - m.i, pattern: PatternDictionary, guesses: m.guesses * 2}
+	}
+	return matches
+}
+
+// l33tTables are the common character substitutions. "1" and "!" are ambiguous, so there are two.
+var l33tTables = []map[rune]rune{
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'l', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+}
+
+// l33tMatches finds words written with substitutions like "p@ssw0rd"
+func l33tMatches(runes []rune, dict map[string]int) []match {
+	var matches []match
+	for _, table := range l33tTables {
+		plain := make([]rune, len(runes))
+		for i, r := range runes {
+			if sub, ok := table[r]; ok {
+				plain[i] = sub
+			} else {
+				plain[i] = r
+			}
+		}
+		for _, m := range dictionaryMatches(plain, dict) {
+			subbed, unsubbed := 0, 0
+			for k := m.i; k <= m.j; k++ {
+				if _, ok := table[runes[k]]; ok {
+					subbed++
+				} else if strings.ContainsRune(string(plain[m.i:m.j+1]), runes[k]) && unicode.IsLetter(runes[k]) {
+					unsubbed++
+				}
+			}
+			if subbed == 0 {
+				continue
+			}
+			m.guesses *= variations(subbed, unsubbed)
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations is how many capitalizations an attacker tries for a word
+func uppercaseVariations(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	// 先頭だけ・末尾だけ・全部大文字はよくあるパターン
+	first, last := unicode.IsUpper(word[0]), unicode.IsUpper(word[len(word)-1])
+	if lower == 0 || (upper == 1 && (first || last)) {
+		return 2
+	}
+	return variations(upper, lower)
+}
+
+// variations is the number of ways to pick up to min(a, b) of a+b positions
+func variations(a, b int) float64 {
+	if a == 0 || b == 0 {
+		return 2
+	}
+	total := 0.0
+	for k := 1; k <= a && k <= b; k++ {
+		total += binomial(a+b, k)
+	}
+	return total
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	result := 1.0
+	for d := 1; d <= k; d++ {
+		result = result * float64(n-k+d) / float64(d)
+	}
+	return result
+}
+
+// sequenceMatches finds runs like "abcd", "9753" or "xyz" with a constant step of at most 5
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	emit := func(i, j int, delta rune) {
+		if j-i < 2 || delta == 0 || delta > 5 || delta < -5 {
+			return
+		}
+		for k := i; k <= j; k++ {
+			if charClass(runes[k]) != charClass(runes[i]) || charClass(runes[k]) == 0 {
+				return
+			}
+		}
+		var base float64
+		switch {
+		case strings.ContainsRune("aAzZ019", runes[i]):
+			base = 4
+		case unicode.IsDigit(runes[i]):
+			base = 10
+		default:
+			base = 26
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, match{i: i, j: j, pattern: PatternSequence, guesses: base * float64(j-i+1)})
+	}
+
+	if len(runes) < 3 {
+		return nil
+	}
+	i := 0
+	delta := runes[1] - runes[0]
+	for k := 2; k < len(runes); k++ {
+		if d := runes[k] - runes[k-1]; d != delta {
+			emit(i, k-1, delta)
+			i, delta = k-1, d
+		}
+	}
+	emit(i, len(runes)-1, delta)
+	return matches
+}
+
+// charClass groups runes for sequences: 1 lower case ASCII, 2 upper case ASCII, 3 digits, 0 other
+func charClass(r rune) int {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return 1
+	case r >= 'A' && r <= 'Z':
+		return 2
+	case r >= '0' && r <= '9':
+		return 3
+	default:
+		return 0
+	}
+}
+
+// repeatMatches finds a unit repeated back to back, like "aaa" or "abcabc".
+// The guesses are those of the unit times the repeat count.
+func repeatMatches(runes []rune, userDict map[string]int) []match {
+	var matches []match
+	n := len(runes)
+	for i := 0; i < n; i++ {
+		best := match{j: -1}
+		for unit := 1; i+2*unit <= n; unit++ {
+			count := 1
+			for i+(count+1)*unit <= n && string(runes[i+count*unit:i+(count+1)*unit]) == string(runes[i:i+unit]) {
+				count++
+			}
+			if count < 2 || (unit == 1 && count < 3) {
+				continue
+			}
+			j := i + count*unit - 1
+			if j <= best.j {
+				continue
+			}
+			base := runes[i : i+unit]
+			baseGuesses, _ := mostGuessableSequence(base, omnimatch(base, userDict))
+			best = match{i: i, j: j, pattern: PatternRepeat, guesses: baseGuesses * float64(count)}
+		}
+		if best.j >= 0 {
+			matches = append(matches, best)
+		}
+	}
+	return matches
+}
+
+// qwertyRows is the US keyboard, unshifted and shifted. Rows are staggered by qwertyOffsets.
+var (
+	qwertyRows = [][2]string{
+		{"`1234567890-=", "~!@#$%^&*()_+"},
+		{"qwertyuiop[]\\", "QWERTYUIOP{}|"},
+		{"asdfghjkl;'", "ASDFGHJKL:\""},
+		{"zxcvbnm,./", "ZXCVBNM<>?"},
+	}
+	qwertyOffsets = []float64{0, 1.5, 1.75, 2.25}
+)
+
+type keyPosition struct {
+	row     int
+	x       float64
+	shifted bool
+}
+
+var qwertyKeys, qwertyAverageDegree = buildKeyboard()
+
+func buildKeyboard() (map[rune]keyPosition, float64) {
+	keys := make(map[rune]keyPosition)
+	for row, chars := range qwertyRows {
+		for shift, line := range chars {
+			for col, r := range []rune(line) {
+				keys[r] = keyPosition{row: row, x: qwertyOffsets[row] + float64(col), shifted: shift == 1}
+			}
+		}
+	}
+
+	edges, count := 0, 0
+	for r, a := range keys {
+		if a.shifted || unicode.IsUpper(r) {
+			continue
+		}
+		count++
+		for s, b := range keys {
+			if !b.shifted && !unicode.IsUpper(s) && r != s && adjacent(a, b) {
+				edges++
+			}
+		}
+	}
+	return keys, float64(edges) / float64(count)
+}
+
+func adjacent(a, b keyPosition) bool {
+	dx := math.Abs(a.x - b.x)
+	switch a.row - b.row {
+	case 0:
+		return dx > 0 && dx <= 1
+	case 1, -1:
+		return dx < 1
+	default:
+		return false
+	}
+}
+
+// spatialMatches finds walks of at least three neighbouring keys, like "qwer" or "zaq1"
+func spatialMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes); i++ {
+		j, turns, shifted := i, 0, 0
+		var lastDir [2]int
+		if key, ok := qwertyKeys[runes[i]]; ok && key.shifted {
+			shifted++
+		}
+		for j+1 < len(runes) {
+			a, okA := qwertyKeys[runes[j]]
+			b, okB := qwertyKeys[runes[j+1]]
+			if !okA || !okB || !adjacent(a, b) {
+				break
+			}
+			dir := [2]int{b.row - a.row, int(math.Copysign(1, b.x-a.x))}
+			if j == i || dir != lastDir {
+				turns++
+			}
+			lastDir = dir
+			if b.shifted {
+				shifted++
+			}
+			j++
+		}
+		if j-i >= 2 {
+			matches = append(matches, match{i: i, j: j, pattern: PatternSpatial, guesses: spatialGuesses(j-i+1, turns, shifted)})
+		}
+	}
+	return matches
+}
+
+func spatialGuesses(length, turns, shifted int) float64 {
+	starts := float64(len(qwertyKeys)) / 2
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= turns && j <= i-1; j++ {
+			guesses += binomial(i-1, j-1) * starts * math.Pow(qwertyAverageDegree, float64(j))
+		}
+	}
+	if shifted > 0 {
+		guesses *= variations(shifted, length-shifted)
+	}
+	return guesses
+}
+
+// yearMatches finds years from 1900 to 2099; recent years are guessed first
+func yearMatches(runes []rune) []match {
+	var matches []match
+	reference := time.Now().Year()
+	for i := 0; i+4 <= len(runes); i++ {
+		year := 0
+		for _, r := range runes[i : i+4] {
+			if r < '0' || r > '9' {
+				year = -1
+				break
+			}
+			year = year*10 + int(r-'0')
+		}
+		if year < 1900 || year > 2099 {
+			continue
+		}
+		space := math.Max(math.Abs(float64(year-reference)), minYearSpace)
+		matches = append(matches, match{i: i, j: i + 3, pattern: PatternYear, guesses: space})
+	}
+	return matches
+}
+
+// optimalStep is the best way found to cover the password up to some rune with a given match count
+type optimalStep struct {
+	m       match
+	product float64
+	guesses float64
+}
+
+// mostGuessableSequence returns the guesses of the cheapest way to write runes as a sequence of
+// matches and bruteforce runs. Like zxcvbn, a sequence of l matches costs
+// l! * product(guesses) + minGuessesBeforeGrowingSequence^(l-1).
+func mostGuessableSequence(runes []rune, matches []match) (float64, []match) {
+	n := len(runes)
+	byEnd := make([][]match, n)
+	for _, m := range matches {
+		m.guesses = math.Max(m.guesses, minGuesses(m, n))
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	// optimal[k][l] is the cheapest cover of runes[0..k] with l matches
+	optimal := make([]map[int]optimalStep, n)
+	for k := range optimal {
+		optimal[k] = make(map[int]optimalStep)
+	}
+	update := func(m match, l int) {
+		k := m.j
+		product := m.guesses
+		if l > 1 {
+			product *= optimal[m.i-1][l-1].product
+		}
+		guesses := factorial(l)*product + math.Pow(minGuessesBeforeGrowingSequence, float64(l-1))
+		for other, step := range optimal[k] {
+			if other <= l && step.guesses <= guesses {
+				return
+			}
+		}
+		optimal[k][l] = optimalStep{m: m, product: product, guesses: guesses}
+	}
+	bruteforce := func(i, j int) match {
+		m := match{i: i, j: j, pattern: PatternBruteforce, guesses: math.Pow(bruteforceCardinality, float64(j-i+1))}
+		if math.IsInf(m.guesses, 1) {
+			m.guesses = math.MaxFloat64
+		}
+		m.guesses = math.Max(m.guesses, minGuesses(m, n))
+		return m
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.i == 0 {
+				update(m, 1)
+				continue
+			}
+			for l := range optimal[m.i-1] {
+				update(m, l+1)
+			}
+		}
+
+		update(bruteforce(0, k), 1)
+		for i := 1; i <= k; i++ {
+			for l, step := range optimal[i-1] {
+				// 連続するbruteforceは1つにまとめた方が常に安い
+				if step.m.pattern != PatternBruteforce {
+					update(bruteforce(i, k), l+1)
+				}
+			}
+		}
+	}
+
+	bestL, best := 0, optimalStep{guesses: math.Inf(1)}
+	for l, step := range optimal[n-1] {
+		if step.guesses < best.guesses {
+			bestL, best = l, step
+		}
+	}
+
+	sequence := make([]match, bestL)
+	for k, l := n-1, bestL; l > 0; l-- {
+		m := optimal[k][l].m
+		sequence[l-1] = m
+		k = m.i - 1
+	}
+	return best.guesses, sequence
+}
+
+// minGuesses keeps patterns covering only part of the password from looking implausibly cheap
+func minGuesses(m match, passwordLength int) float64 {
+	switch {
+	case m.j-m.i+1 == passwordLength:
+		return 1
+	case m.i == m.j:
+		return minSubmatchGuessesSingleChar + 1
+	default:
+		return minSubmatchGuessesMultiChar + 1
+	}
+}
+
+func factorial(n int) float64 {
+	result := 1.0
+	for i := 2; i <= n; i++ {
+		result *= float64(i)
+	}
+	return result
+}
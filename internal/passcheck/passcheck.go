@@ -0,0 +1,74 @@
+// Package passcheck screens new passwords offline: it rejects passwords known from data breaches
+// and passwords a zxcvbn-style estimator finds too easy to guess.
+//
+// Nothing leaves the server. Breached passwords are looked up in the bundled list of common
+// passwords and optionally in a local copy of the Have I Been Pwned Pwned Passwords dump
+// (see Open).
+package passcheck
+
+import "github.com/cockroachdb/errors"
+
+// Reason tells why a password was rejected. The values are the validation rules reported
+// to clients.
+type Reason string
+
+const (
+	// ReasonBreached means the password appears in a breached password list
+	ReasonBreached Reason = "breachedpassword"
+	// ReasonWeak means the estimated strength is below the minimum score
+	ReasonWeak Reason = "weakpassword"
+)
+
+// DefaultMinScore is the lowest accepted score by default. zxcvbn calls 2 "somewhat guessable",
+// enough against online guessing, which login lockout and Argon2id hashing already slow down.
+const DefaultMinScore = 2
+
+// Result is the outcome of a password check
+type Result struct {
+	// Reason is empty when the password is accepted
+	Reason   Reason
+	Strength Strength
+	// Occurrences is how many times the password was seen in breaches
+	Occurrences int
+}
+
+// OK reports whether the password is accepted
+func (r Result) OK() bool {
+	return r.Reason == ""
+}
+
+// Checker checks new passwords against breached password lists and a minimum strength score
+type Checker struct {
+	minScore int
+	lists    []BreachList
+}
+
+// NewChecker creates a Checker accepting passwords scored at least minScore (0-4)
+// that appear in none of the lists
+func NewChecker(minScore int, lists ...BreachList) *Checker {
+	return &Checker{minScore: minScore, lists: lists}
+}
+
+// Check checks password. userInputs like the email address and name of the player are
+// treated as easy to guess. Breaches take precedence over weakness as the reason.
+func (c *Checker) Check(password string, userInputs ...string) (Result, error) {
+	result := Result{Strength: Estimate(password, userInputs...)}
+
+	hash := HashPassword(password)
+	for _, list := range c.lists {
+		n, err := list.Occurrences(hash)
+		if err != nil {
+			return Result{}, errors.Wrap(err, "passcheck: breached password lookup failed")
+		}
+		if n > 0 {
+			result.Reason = ReasonBreached
+			result.Occurrences = n
+			return result, nil
+		}
+	}
+
+	if result.Strength.Score < c.minScore {
+		result.Reason = ReasonWeak
+	}
+	return result, nil
+}
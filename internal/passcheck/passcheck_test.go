@@ -0,0 +1,120 @@
+package passcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimatePatterns(t *testing.T) {
+	tests := []struct {
+		password string
+		pattern  string
+		maxScore int
+	}{
+		{"password", PatternDictionary, 0},
+		{"Password123!", PatternDictionary, 1},
+		{"p@ssw0rd", PatternDictionary, 0},
+		{"drowssap", PatternDictionary, 0},
+		{"abcdefghij", PatternSequence, 0},
+		{"aaaaaaaaaaaa", PatternRepeat, 0},
+		{"sdfghjkl;", PatternSpatial, 1},
+		{"!@#$%^&*", PatternSpatial, 1},
+		{"2024", PatternYear, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.password, func(t *testing.T) {
+			s := Estimate(tc.password)
+			assert.Equal(t, tc.pattern, s.Pattern)
+			assert.LessOrEqual(t, s.Score, tc.maxScore)
+		})
+	}
+}
+
+func TestEstimateStrongPasswords(t *testing.T) {
+	for _, password := range []string{"jA8#kq2!Lz0p", "Test1234!@#$", "correct horse battery staple"} {
+		assert.GreaterOrEqual(t, Estimate(password).Score, DefaultMinScore, password)
+	}
+}
+
+func TestEstimateUserInputs(t *testing.T) {
+	password := "Tanakataro!"
+	assert.Greater(t, Estimate(password).Score, Estimate(password, "tanakataro@example.com", "Taro Tanaka").Score)
+	assert.Equal(t, PatternDictionary, Estimate(password, "tanakataro@example.com").Pattern)
+}
+
+func TestEstimateEmpty(t *testing.T) {
+	s := Estimate("")
+	assert.Equal(t, 0, s.Score)
+	assert.Equal(t, float64(1), s.Guesses)
+}
+
+func TestCheckerReasons(t *testing.T) {
+	c := NewChecker(DefaultMinScore, Bundled())
+
+	res, err := c.Check("iloveyou")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonBreached, res.Reason)
+	assert.Equal(t, 1, res.Occurrences)
+
+	res, err = c.Check("Password123!")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonWeak, res.Reason)
+	assert.False(t, res.OK())
+
+	res, err = c.Check("jA8#kq2!Lz0p")
+	require.NoError(t, err)
+	assert.True(t, res.OK())
+}
+
+func TestOpenRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	hash := HashPassword("jA8#kq2!Lz0p")
+	rare := HashPassword("Zq9$mw3!Rt7x")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(hash[5:]+":42\r\n0000000000000000000000000000000000A:1\r\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, rare[:5]+".txt"), []byte(rare[5:]+":1\n"), 0o600))
+
+	list, err := Open(dir, 2)
+	require.NoError(t, err)
+
+	n, err := list.Occurrences(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 42, n)
+
+	// min_count未満の出現回数は無視する
+	n, err = list.Occurrences(rare)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// プレフィックスのファイルがなければ未掲載
+	n, err = list.Occurrences(HashPassword("unlisted password"))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	res, err := NewChecker(0, list).Check("jA8#kq2!Lz0p")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonBreached, res.Reason)
+	assert.Equal(t, 42, res.Occurrences)
+}
+
+func TestOpenHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	hash := HashPassword("jA8#kq2!Lz0p")
+	require.NoError(t, os.WriteFile(path, []byte("# curated\n"+hash+":7\n"), 0o600))
+
+	list, err := Open(path, 1)
+	require.NoError(t, err)
+	n, err := list.Occurrences(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+
+	require.NoError(t, os.WriteFile(path, []byte(hash+":many\n"), 0o600))
+	_, err = Open(path, 1)
+	assert.Error(t, err)
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing"), 1)
+	assert.Error(t, err)
+}
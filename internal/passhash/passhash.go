@@ -0,0 +1,145 @@
+// Package passhash hashes passwords into self-describing PHC strings.
+//
+// New hashes use Argon2id:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// Legacy bcrypt hashes ($2a$, $2b$, $2y$) are still verified and reported as outdated,
+// so callers can rehash them after a successful login.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const algArgon2id = "argon2id"
+
+// Params are the Argon2id cost parameters
+type Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the OWASP recommendation for Argon2id (19 MiB, 2 iterations, 1 lane)
+var DefaultParams = Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// b64 is the unpadded standard base64 used by the PHC string format
+var b64 = base64.RawStdEncoding
+
+// Hasher hashes new passwords with the configured parameters and verifies stored hashes
+type Hasher struct {
+	params Params
+	// dummy is a hash with the current parameters that belongs to no account, see VerifyDummy
+	dummy string
+}
+
+// NewHasher creates a Hasher. Zero salt and key lengths use the defaults.
+func NewHasher(params Params) *Hasher {
+	if params.SaltLength == 0 {
+		params.SaltLength = DefaultParams.SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultParams.KeyLength
+	}
+	h := &Hasher{params: params}
+	// 起動時に一度だけ計算する（ソルトは固定でよい。照合に成功させるためのものではない）
+	h.dummy = h.encode("", make([]byte, params.SaltLength))
+	return h
+}
+
+// Hash returns the encoded Argon2id hash of password with a random salt
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "failed to generate salt")
+	}
+	return h.encode(password, salt), nil
+}
+
+func (h *Hasher) encode(password string, salt []byte) string {
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		algArgon2id, argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		b64.EncodeToString(salt), b64.EncodeToString(key))
+}
+
+// VerifyDummy does the work of Verify against a hash that belongs to no account. Call it when
+// the account does not exist, so that the response time does not tell whether it does.
+func (h *Hasher) VerifyDummy(password string) {
+	h.Verify(h.dummy, password)
+}
+
+// Verify reports whether password matches the encoded hash, and whether the hash should be
+// replaced because it uses an old algorithm or parameters. Malformed and empty hashes never match.
+func (h *Hasher) Verify(encoded, password string) (ok, needsRehash bool) {
+	switch {
+	case strings.HasPrefix(encoded, "$"+algArgon2id+"$"):
+		stored, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false
+		}
+		computed := argon2.IDKey([]byte(password), salt, stored.Iterations, stored.Memory, stored.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false
+		}
+		return true, stored != h.params
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		// 旧形式（bcrypt）。一致したら新しい形式へ移行させる
+		if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) != nil {
+			return false, false
+		}
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// decodeArgon2id parses "$argon2id$v=19$m=...,t=...,p=...$salt$hash"
+func decodeArgon2id(encoded string) (Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Params{}, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	var p Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Params{}, nil, nil, errors.Wrap(err, "invalid argon2id parameters")
+	}
+	if p.Iterations == 0 || p.Parallelism == 0 {
+		return Params{}, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, errors.Wrap(err, "invalid argon2id salt")
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, errors.New("invalid argon2id key")
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,90 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keeps the tests fast
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestHashAndVerify(t *testing.T) {
+	h := NewHasher(testParams)
+
+	encoded, err := h.Hash("correct horse battery staple")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, rehash := h.Verify(encoded, "correct horse battery staple")
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _ = h.Verify(encoded, "wrong")
+	assert.False(t, ok)
+
+	// 同じパスワードでもソルトが異なる
+	again, err := h.Hash("correct horse battery staple")
+	require.NoError(t, err)
+	assert.NotEqual(t, encoded, again)
+}
+
+func TestLongPasswords(t *testing.T) {
+	h := NewHasher(testParams)
+	long := strings.Repeat("a", 100)
+
+	encoded, err := h.Hash(long)
+	require.NoError(t, err)
+	ok, _ := h.Verify(encoded, long)
+	assert.True(t, ok)
+	// bcryptと違い72バイト目以降も比較される
+	ok, _ = h.Verify(encoded, long[:72])
+	assert.False(t, ok)
+}
+
+func TestOutdatedHashesNeedRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Test1234!@#$"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	h := NewHasher(testParams)
+	ok, rehash := h.Verify(string(legacy), "Test1234!@#$")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, rehash = h.Verify(string(legacy), "wrong")
+	assert.False(t, ok)
+	assert.False(t, rehash)
+
+	// パラメータを上げると既存のArgon2idハッシュも再ハッシュ対象になる
+	old, err := h.Hash("Test1234!@#$")
+	require.NoError(t, err)
+	stronger := NewHasher(Params{Memory: 2048, Iterations: 1, Parallelism: 1})
+	ok, rehash = stronger.Verify(old, "Test1234!@#$")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestMalformedHashesNeverMatch(t *testing.T) {
+	h := NewHasher(testParams)
+	for _, encoded := range []string{
+		"",
+		"plain",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5",
+	} {
+		ok, _ := h.Verify(encoded, "")
+		assert.False(t, ok, encoded)
+	}
+}
+
+func TestDummyHashUsesCurrentParams(t *testing.T) {
+	h := NewHasher(testParams)
+	// ダミーの照合にも実際のハッシュと同じコストがかかる
+	params, _, _, err := decodeArgon2id(h.dummy)
+	require.NoError(t, err)
+	assert.Equal(t, h.params, params)
+}
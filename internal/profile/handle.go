@@ -0,0 +1,64 @@
+// Package profile holds the rules of public player handles.
+//
+// A handle is 3 to 20 ASCII letters, digits and underscores starting with a letter.
+// Handles are unique case-insensitively and looked up case-insensitively, but shown as chosen.
+// Names that could impersonate the service or its staff are reserved, as is the prefix of the
+// handles generated for players who did not choose one.
+package profile
+
+import (
+	"crypto/rand"
+	"regexp"
+	"strings"
+)
+
+// GeneratedPrefix starts the handles generated at signup. Players cannot choose it.
+const GeneratedPrefix = "player_"
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{2,19}$`)
+
+// reservedHandles may not be used as a handle, compared case-insensitively
+var reservedHandles = map[string]bool{
+	"about": true, "account": true, "admin": true, "administrator": true, "api": true,
+	"auth": true, "help": true, "login": true, "logout": true, "me": true, "mod": true,
+	"moderator": true, "mydeer": true, "null": true, "official": true, "player": true,
+	"players": true, "root": true, "settings": true, "signup": true, "staff": true,
+	"support": true, "system": true, "town": true, "towns": true, "undefined": true,
+}
+
+// reservedPrefixes may not start a handle, so that e.g. "admin_taro" cannot pose as staff
+var reservedPrefixes = []string{"admin", "mydeer", "official", "staff", "support", "moderator", GeneratedPrefix}
+
+// ValidHandle reports whether handle has the allowed length and characters
+func ValidHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+// Reserved reports whether handle is reserved
+func Reserved(handle string) bool {
+	lower := strings.ToLower(handle)
+	if reservedHandles[lower] {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAlphabet is used for generated handles; it avoids look-alike characters
+const handleAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GenerateHandle returns a random handle like "player_k7m2q9xd4a" for a player who did not choose one
+func GenerateHandle() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = handleAlphabet[int(b)%len(handleAlphabet)]
+	}
+	return GeneratedPrefix + string(buf), nil
+}
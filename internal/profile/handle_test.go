@@ -0,0 +1,38 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidHandle(t *testing.T) {
+	for _, handle := range []string{"taro", "Taro_99", "abc", "a2345678901234567890"} {
+		assert.True(t, ValidHandle(handle), handle)
+	}
+	for _, handle := range []string{"", "ab", "9lives", "_taro", "taro-san", "たろう", "a23456789012345678901"} {
+		assert.False(t, ValidHandle(handle), handle)
+	}
+}
+
+func TestReserved(t *testing.T) {
+	for _, handle := range []string{"admin", "Admin", "ME", "support_team", "MyDeerOfficial", "player_abc"} {
+		assert.True(t, Reserved(handle), handle)
+	}
+	for _, handle := range []string{"taro", "player1", "madmin"} {
+		assert.False(t, Reserved(handle), handle)
+	}
+}
+
+func TestGenerateHandle(t *testing.T) {
+	a, err := GenerateHandle()
+	require.NoError(t, err)
+	b, err := GenerateHandle()
+	require.NoError(t, err)
+
+	assert.NotEqual(t, a, b)
+	assert.True(t, ValidHandle(a), a)
+	// 生成されたハンドルはプレイヤーが選べない
+	assert.True(t, Reserved(a))
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+// MemoryStore keeps the rate limit counters in the process; each replica limits on its own.
+// Counters stay until DeleteExpiredRateLimits sweeps them after their expiry.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[memoryWindow]memoryCounter
+}
+
+var _ db.RateLimitRepository = (*MemoryStore)(nil)
+
+// memoryWindow identifies the counter of a key in the window starting at start (UnixNano)
+type memoryWindow struct {
+	key   string
+	start int64
+}
+
+type memoryCounter struct {
+	hits      int
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[memoryWindow]memoryCounter)}
+}
+
+// IncrementRateLimit counts a request for the key in the window starting at windowStart
+// and returns the number of requests in that window so far
+func (s *MemoryStore) IncrementRateLimit(ctx context.Context, key string, windowStart, expiresAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := memoryWindow{key: key, start: windowStart.UnixNano()}
+	counter, ok := s.counters[id]
+	if !ok {
+		counter = memoryCounter{expiresAt: expiresAt}
+	}
+	counter.hits++
+	s.counters[id] = counter
+	return counter.hits, nil
+}
+
+// GetRateLimitHits returns the number of requests for the key in the window starting at windowStart,
+// 0 when none was counted
+func (s *MemoryStore) GetRateLimitHits(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counters[memoryWindow{key: key, start: windowStart.UnixNano()}].hits, nil
+}
+
+// DeleteExpiredRateLimits deletes the counters that expired at now and returns how many were deleted
+func (s *MemoryStore) DeleteExpiredRateLimits(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, counter := range s.counters {
+		if !counter.expiresAt.After(now) {
+			delete(s.counters, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
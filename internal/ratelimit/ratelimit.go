@@ -0,0 +1,139 @@
+// Package ratelimit limits how often clients may call routes. Requests are counted per fixed window
+// in a db.RateLimitRepository and weighted with the previous window into a sliding window, so the
+// limits hold across replicas when the counters are kept in PostgreSQL.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+// Scope decides which requests are counted together
+type Scope string
+
+const (
+	// ScopeIP counts the requests of each client IP
+	ScopeIP Scope = "ip"
+	// ScopePlayer counts the requests of each authenticated player, and of each client IP before login
+	ScopePlayer Scope = "player"
+	// ScopeRoute counts the requests of all clients to each route together
+	ScopeRoute Scope = "route"
+)
+
+// Policy allows Limit requests per Window for each key of its scope.
+// Routes sharing a policy share its quota.
+type Policy struct {
+	// Name identifies the counters of the policy in the store
+	Name   string
+	Limit  int
+	Window time.Duration
+	By     Scope
+}
+
+// Result is the outcome of counting a request
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests still allowed right now
+	Remaining int
+	// Reset is how long until the current window ends
+	Reset time.Duration
+	// RetryAfter is how long a rejected client must wait; zero when the request is allowed
+	RetryAfter time.Duration
+}
+
+// Limiter counts requests against policies
+type Limiter struct {
+	store db.RateLimitRepository
+	now   func() time.Time
+}
+
+// NewLimiter creates a Limiter keeping its counters in store
+func NewLimiter(store db.RateLimitRepository) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow counts a request of subject (e.g. "ip:192.0.2.1") under the policy and reports whether it is
+// within the limit. Rejected requests are counted too, so clients ignoring Retry-After stay limited.
+func (l *Limiter) Allow(ctx context.Context, p Policy, subject string) (Result, error) {
+	now := l.now()
+	key := p.Name + ":" + subject
+	start := now.Truncate(p.Window)
+
+	// 現在のウィンドウは次のウィンドウで「直前のウィンドウ」として参照されるため2ウィンドウ分残す
+	current, err := l.store.IncrementRateLimit(ctx, key, start, start.Add(2*p.Window))
+	if err != nil {
+		return Result{}, err
+	}
+	previous, err := l.store.GetRateLimitHits(ctx, key, start.Add(-p.Window))
+	if err != nil {
+		return Result{}, err
+	}
+
+	elapsed := now.Sub(start)
+	// 直前のウィンドウの件数を、スライディングウィンドウに重なっている割合だけ数える
+	estimate := float64(previous)*(1-float64(elapsed)/float64(p.Window)) + float64(current)
+
+	res := Result{
+		Allowed:   estimate <= float64(p.Limit),
+		Limit:     p.Limit,
+		Remaining: max(p.Limit-int(math.Ceil(estimate)), 0),
+		Reset:     p.Window - elapsed,
+	}
+	if !res.Allowed {
+		res.RetryAfter = retryAfter(p, previous, current, elapsed)
+	}
+	return res, nil
+}
+
+// retryAfter returns how long until one more request fits in the sliding window
+func retryAfter(p Policy, previous, current int, elapsed time.Duration) time.Duration {
+	window := float64(p.Window)
+	if current < p.Limit && previous > 0 {
+		// 現在のウィンドウ内で、直前のウィンドウの重みが十分下がるのを待つ
+		wait := window*(1-float64(p.Limit-current-1)/float64(previous)) - float64(elapsed)
+		return max(time.Duration(wait), time.Nanosecond)
+	}
+	// 次のウィンドウで、現在のウィンドウの件数の重みが十分下がるのを待つ
+	wait := window * max(1-float64(p.Limit-1)/float64(current), 0)
+	return p.Window - elapsed + time.Duration(wait)
+}
+
+// Routes maps routes to the policies that limit them
+type Routes struct {
+	policies map[string][]Policy
+}
+
+// NewRoutes creates an empty route table
+func NewRoutes() *Routes {
+	return &Routes{policies: make(map[string][]Policy)}
+}
+
+// Add limits route with the policy. route is "*" for every route, a path such as "/login" for
+// every method, or a method and a path such as "POST /login". Paths are the registered gin
+// patterns, e.g. "/players/:handle".
+func (r *Routes) Add(route string, p Policy) {
+	route = normalizeRoute(route)
+	r.policies[route] = append(r.policies[route], p)
+}
+
+// Match returns the policies of the route registered as path, matched with method
+func (r *Routes) Match(method, path string) []Policy {
+	var policies []Policy
+	policies = append(policies, r.policies["*"]...)
+	policies = append(policies, r.policies[path]...)
+	policies = append(policies, r.policies[method+" "+path]...)
+	return policies
+}
+
+func normalizeRoute(route string) string {
+	route = strings.Join(strings.Fields(route), " ")
+	if method, path, ok := strings.Cut(route, " "); ok {
+		return strings.ToUpper(method) + " " + path
+	}
+	return route
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLimiter(now *time.Time) *Limiter {
+	l := NewLimiter(NewMemoryStore())
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestAllowWithinWindow(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+	ctx := context.Background()
+	p := Policy{Name: "login", Limit: 3, Window: time.Minute, By: ScopeIP}
+
+	for i := 2; i >= 0; i-- {
+		res, err := l.Allow(ctx, p, "ip:192.0.2.1")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+		assert.Equal(t, time.Minute, res.Reset)
+	}
+
+	res, err := l.Allow(ctx, p, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	// 4件が次のウィンドウで半分の重みになるまで待つ
+	assert.Equal(t, 90*time.Second, res.RetryAfter)
+
+	// キーごとに数える
+	res, err = l.Allow(ctx, p, "ip:192.0.2.2")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// ポリシーごとに数える
+	res, err = l.Allow(ctx, Policy{Name: "signup", Limit: 3, Window: time.Minute, By: ScopeIP}, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestAllowSlidingWindow(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 30, 0, time.UTC)
+	l := newTestLimiter(&now)
+	ctx := context.Background()
+	p := Policy{Name: "post", Limit: 4, Window: time.Minute, By: ScopePlayer}
+
+	for range 4 {
+		res, err := l.Allow(ctx, p, "player:1")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+
+	// 次のウィンドウの開始直後は直前のウィンドウの4件がほぼそのまま数えられる
+	now = time.Date(2025, 4, 1, 12, 1, 0, 0, time.UTC)
+	res, err := l.Allow(ctx, p, "player:1")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	// 4*(1-t/60s) + 1 + 1 <= 4 となる t = 30s
+	assert.Equal(t, 30*time.Second, res.RetryAfter)
+
+	now = now.Add(30 * time.Second)
+	res, err = l.Allow(ctx, p, "player:1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	// ウィンドウ2つ分経てば以前の件数は数えない
+	now = time.Date(2025, 4, 1, 12, 3, 0, 0, time.UTC)
+	res, err = l.Allow(ctx, p, "player:1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 3, res.Remaining)
+}
+
+func TestExpiredCountersAreDeleted(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	l := NewLimiter(store)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := l.Allow(ctx, Policy{Name: "login", Limit: 1, Window: time.Minute, By: ScopeIP}, "ip:192.0.2.1")
+	require.NoError(t, err)
+
+	deleted, err := store.DeleteExpiredRateLimits(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted, "still needed as the previous window")
+
+	deleted, err = store.DeleteExpiredRateLimits(ctx, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	hits, err := store.GetRateLimitHits(ctx, "login:ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, hits)
+}
+
+func TestRoutes(t *testing.T) {
+	global := Policy{Name: "global", Limit: 100, Window: time.Minute, By: ScopePlayer}
+	login := Policy{Name: "login", Limit: 10, Window: time.Minute, By: ScopeIP}
+	players := Policy{Name: "players", Limit: 30, Window: time.Minute, By: ScopeIP}
+
+	routes := NewRoutes()
+	routes.Add("*", global)
+	routes.Add("post  /login", login)
+	routes.Add("/players/:handle", players)
+
+	assert.Equal(t, []Policy{global, login}, routes.Match("POST", "/login"))
+	assert.Equal(t, []Policy{global}, routes.Match("GET", "/login"))
+	assert.Equal(t, []Policy{global, players}, routes.Match("GET", "/players/:handle"))
+	assert.Equal(t, []Policy{global}, routes.Match("GET", ""))
+
+}
@@ -0,0 +1,100 @@
+// Package rbac implements role-based access control.
+//
+// Roles and the permissions they grant are stored in the database and assigned to users;
+// every user also has the player role without an assignment. Permissions are looked up on
+// every check so that a role change applies to the next request. The roles carried by the
+// access token are informational only.
+package rbac
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+// Roles seeded by the migrations
+const (
+	RolePlayer    = auth.RolePlayer
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions checked by the admin API
+const (
+	PermUsersRead      = "users:read"
+	PermUsersSuspend   = "users:suspend"
+	PermSessionsRevoke = "sessions:revoke"
+	PermRolesAssign    = "roles:assign"
+)
+
+// Role is a role with the permissions it grants
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// Authorizer resolves the roles and permissions of users
+type Authorizer struct {
+	roles db.RoleRepository
+}
+
+// NewAuthorizer creates an Authorizer
+func NewAuthorizer(roles db.RoleRepository) *Authorizer {
+	return &Authorizer{roles: roles}
+}
+
+// UserRoles returns the roles of the user, the player role first
+func (a *Authorizer) UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	assigned, err := a.roles.ListUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{RolePlayer}, assigned...), nil
+}
+
+// HasPermission reports whether a role of the user grants the permission
+func (a *Authorizer) HasPermission(ctx context.Context, userID uuid.UUID, permission string) (bool, error) {
+	roles, err := a.UserRoles(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	granted, err := a.roles.ListRolePermissions(ctx, roles)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(granted, func(p db.RolePermission) bool {
+		return p.Permission == permission
+	}), nil
+}
+
+// Roles returns every role with its permissions, ordered by name
+func (a *Authorizer) Roles(ctx context.Context) ([]Role, error) {
+	rows, err := a.roles.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rows))
+	for _, r := range rows {
+		names = append(names, r.Name)
+	}
+	granted, err := a.roles.ListRolePermissions(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]Role, 0, len(rows))
+	for _, r := range rows {
+		role := Role{Name: r.Name, Description: r.Description, Permissions: []string{}}
+		for _, p := range granted {
+			if p.Role == r.Name {
+				role.Permissions = append(role.Permissions, p.Permission)
+			}
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
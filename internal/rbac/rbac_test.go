@@ -0,0 +1,60 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasPermission(t *testing.T) {
+	store := memory.New()
+	a := NewAuthorizer(store)
+	ctx := context.Background()
+
+	user, err := store.CreateUser(ctx, db.CreateUserParams{Email: "staff@example.com", Password: "x", Name: "Staff"})
+	require.NoError(t, err)
+
+	// ロールが無くても player として扱う
+	roles, err := a.UserRoles(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{RolePlayer}, roles)
+	ok, err := a.HasPermission(ctx, user.ID, PermUsersRead)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.SetUserRoles(ctx, user.ID, []string{RoleModerator}))
+	for perm, want := range map[string]bool{
+		PermUsersRead:      true,
+		PermUsersSuspend:   true,
+		PermSessionsRevoke: true,
+		PermRolesAssign:    false,
+	} {
+		ok, err := a.HasPermission(ctx, user.ID, perm)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, perm)
+	}
+
+	require.NoError(t, store.SetUserRoles(ctx, user.ID, []string{RoleModerator, RoleAdmin}))
+	roles, err = a.UserRoles(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{RolePlayer, RoleAdmin, RoleModerator}, roles)
+	ok, err = a.HasPermission(ctx, user.ID, PermRolesAssign)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestRoles(t *testing.T) {
+	a := NewAuthorizer(memory.New())
+
+	roles, err := a.Roles(context.Background())
+	require.NoError(t, err)
+	require.Len(t, roles, 3)
+	assert.Equal(t, RoleAdmin, roles[0].Name)
+	assert.Contains(t, roles[0].Permissions, PermRolesAssign)
+	assert.Equal(t, RolePlayer, roles[2].Name)
+	assert.Empty(t, roles[2].Permissions)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/my-deer/mydeer/handlers"
+	"github.com/my-deer/mydeer/internal/account"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/health"
+	"github.com/my-deer/mydeer/internal/i18n"
+	"github.com/my-deer/mydeer/internal/lockout"
+	"github.com/my-deer/mydeer/internal/mail"
+	"github.com/my-deer/mydeer/internal/metrics"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/oidc"
+	"github.com/my-deer/mydeer/internal/passcheck"
+	"github.com/my-deer/mydeer/internal/passhash"
+	"github.com/my-deer/mydeer/internal/ratelimit"
+	"github.com/my-deer/mydeer/internal/rbac"
+	"github.com/my-deer/mydeer/middleware"
+	"golang.org/x/exp/slog"
+)
+
+// Deps are the dependencies shared by the handlers and middleware
+type Deps struct {
+	Config     *config.Config
+	Users      db.UserRepository
+	Sessions   db.SessionRepository
+	Tokens     db.UserTokenRepository
+	Tx         db.TxRunner
+	Attempts   db.LoginAttemptRepository
+	Identities db.IdentityRepository
+	Profiles   db.ProfileRepository
+	Roles      db.RoleRepository
+	RateLimits db.RateLimitRepository
+	Mailer     mail.Mailer
+	MFA        *mfa.Service
+	Accounts   *account.Service
+	Keys       *auth.KeySet
+	Checker    *health.Checker
+	Passwords  *passcheck.Checker
+	// Metrics is served on metrics.path when set
+	Metrics *metrics.Metrics
+	// Logger is the base of the request loggers; nil uses slog.Default
+	Logger *slog.Logger
+}
+
+// NewRouter builds the gin engine with every middleware and route.
+// It is used by main and by the API tests so both run the same wiring.
+func NewRouter(d Deps) *gin.Engine {
+	r := gin.New()
+	// ハンドラーが c をコンテキストとして渡した場合も、リクエストのコンテキスト（トレースのスパン）を引き継ぐ
+	r.ContextWithFallback = true
+	// X-Forwarded-For は設定されたプロキシから来た場合だけ信用する（既定では接続元のアドレスをクライアントIPにする）。
+	// 信用すると任意のクライアントがIPを偽れるため、ロックアウトやレート制限を回避できてしまう
+	if err := r.SetTrustedProxies(d.Config.Server.TrustedProxies); err != nil {
+		// Config.Validate で確認済みのため通常は起きない。失敗した場合も何も信用しない
+		slog.Error("server: invalid trusted proxies, trusting none", "error", err.Error())
+		_ = r.SetTrustedProxies(nil)
+	}
+
+	// Register custom validators
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		handlers.RegisterValidators(v)
+	}
+
+	// プローブ（ログミドルウェアの対象外）
+	r.GET("/healthz", handlers.HealthzHandler)
+	r.GET("/readyz", handlers.ReadyzHandler(d.Checker))
+	if d.Metrics != nil {
+		r.GET(d.Config.Metrics.Path, gin.WrapH(d.Metrics.Handler()))
+	}
+
+	// ミドルウェア設定
+	r.Use(middleware.Tracing())
+	logger := d.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r.Use(middleware.RequestLogger(logger, d.Config.Logging.TrustRequestID))
+	r.Use(middleware.Metrics(d.Metrics))
+	r.Use(middleware.Locale(defaultLocale(d.Config)))
+	r.Use(middleware.ErrorHandler())
+	r.Use(gin.Recovery())
+
+	passwords := passhash.NewHasher(d.Config.Auth.PasswordHash.Params())
+	authz := rbac.NewAuthorizer(d.Roles)
+	verificationHandler := handlers.NewEmailVerificationHandler(d.Config, d.Users, d.Tokens, d.Tx, d.Mailer)
+	tracker := newLockoutTracker(d)
+	authHandler := handlers.NewAuthHandler(d.Config, d.Users, d.Sessions, d.Profiles, d.Tx, d.Keys, passwords, d.Passwords, verificationHandler, tracker, d.MFA, d.Accounts, authz, d.Metrics)
+	passwordHandler := handlers.NewPasswordHandler(d.Config, d.Users, d.Sessions, d.Tokens, d.Tx, d.Mailer, passwords, d.Passwords, tracker)
+	mfaHandler := handlers.NewMFAHandler(d.Users, passwords, d.MFA, tracker)
+	profileHandler := handlers.NewProfileHandler(d.Users, d.Profiles)
+	accountHandler := handlers.NewAccountHandler(d.Users, passwords, d.MFA, d.Accounts, tracker, authHandler)
+	adminHandler := handlers.NewAdminHandler(d.Users, d.Profiles, d.Attempts, authz, d.Accounts)
+	oidcHandler := handlers.NewOIDCHandler(d.Config, d.Users, d.Identities, d.Tx, newOIDCRegistry(d.Config), authHandler)
+
+	// Cookieで認証する状態変更リクエストのCSRF対策（リフレッシュトークンのCookieを使う /logout, /token/refresh にも適用）
+	csrf := csrfProtection(d.Config.Cookie)
+	// レート制限（プレイヤー単位で数えるため、認証が必要なエンドポイントでは認証の後に適用）
+	rateLimit := newRateLimit(d)
+
+	// エンドポイント設定
+	public := r.Group("/", rateLimit)
+	public.POST("/login", authHandler.Login)
+	public.POST("/login/mfa", authHandler.LoginMFA)
+	public.POST("/signup", authHandler.Signup)
+	public.POST("/logout", csrf, authHandler.Logout)
+	public.POST("/token/refresh", csrf, authHandler.RefreshToken)
+	public.GET("/verify-email", verificationHandler.VerifyEmail)
+	public.POST("/verify-email", verificationHandler.VerifyEmail)
+	public.POST("/verify-email/resend", verificationHandler.ResendVerification)
+	public.POST("/password/forgot", passwordHandler.ForgotPassword)
+	public.POST("/password/reset", passwordHandler.ResetPassword)
+	public.GET("/.well-known/jwks.json", authHandler.JWKS)
+	public.GET("/auth/oidc/:provider/login", oidcHandler.Login)
+	public.GET("/auth/oidc/:provider/callback", oidcHandler.Callback)
+	public.GET("/players/:handle", profileHandler.GetPlayer)
+
+	// 認証が必要なエンドポイント
+	authorized := r.Group("/")
+	authorized.Use(middleware.Auth(d.Keys, d.Sessions, d.Config.Cookie.Name(auth.AccessTokenCookie)), csrf, rateLimit)
+	authorized.GET("/auth", authHandler.Session)
+	authorized.GET("/auth/identities", oidcHandler.Identities)
+	authorized.GET("/me", profileHandler.GetMe)
+	authorized.PATCH("/me", profileHandler.UpdateMe)
+	authorized.DELETE("/me", accountHandler.DeleteMe)
+	authorized.GET("/me/export", accountHandler.ExportMe)
+	authorized.POST("/password/change", passwordHandler.ChangePassword)
+	authorized.GET("/mfa", mfaHandler.Status)
+	authorized.POST("/mfa/totp/setup", mfaHandler.SetupTOTP)
+	authorized.POST("/mfa/totp/confirm", mfaHandler.ConfirmTOTP)
+	authorized.POST("/mfa/disable", mfaHandler.Disable)
+	authorized.POST("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+
+	// 管理API（ロールの権限をエンドポイントごとに確認）
+	admin := authorized.Group("/admin")
+	admin.GET("/users", middleware.RequirePermission(authz, rbac.PermUsersRead), adminHandler.ListUsers)
+	admin.GET("/users/:id", middleware.RequirePermission(authz, rbac.PermUsersRead), adminHandler.GetUser)
+	admin.POST("/users/:id/suspend", middleware.RequirePermission(authz, rbac.PermUsersSuspend), adminHandler.SuspendUser)
+	admin.POST("/users/:id/unsuspend", middleware.RequirePermission(authz, rbac.PermUsersSuspend), adminHandler.UnsuspendUser)
+	admin.POST("/users/:id/logout", middleware.RequirePermission(authz, rbac.PermSessionsRevoke), adminHandler.LogoutUser)
+	admin.PUT("/users/:id/roles", middleware.RequirePermission(authz, rbac.PermRolesAssign), adminHandler.SetUserRoles)
+	admin.GET("/roles", middleware.RequirePermission(authz, rbac.PermUsersRead), adminHandler.ListRoles)
+
+	return r
+}
+
+// csrfProtection returns the CSRF middleware, or one that does nothing when cookie.csrf is disabled
+func csrfProtection(cfg config.CookieConfig) gin.HandlerFunc {
+	if !cfg.CSRF {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return middleware.CSRF(cfg.Name(auth.CSRFCookie), cfg.Name(auth.AccessTokenCookie), cfg.Name(auth.RefreshTokenCookie))
+}
+
+// newRateLimit returns the rate limit middleware of the configured rules, or one that does nothing
+// when rate_limit.enabled is false
+func newRateLimit(d Deps) gin.HandlerFunc {
+	cfg := d.Config.RateLimit
+	if !cfg.Enabled {
+		return func(c *gin.Context) { c.Next() }
+	}
+	routes := ratelimit.NewRoutes()
+	for _, rule := range cfg.Rules {
+		policy := ratelimit.Policy{
+			Name:   rule.Name,
+			Limit:  rule.Limit,
+			Window: time.Duration(rule.Window),
+			By:     ratelimit.Scope(rule.By),
+		}
+		for _, route := range rule.Routes {
+			routes.Add(route, policy)
+		}
+	}
+	return middleware.RateLimit(ratelimit.NewLimiter(d.RateLimits), routes)
+}
+
+func newLockoutTracker(d Deps) *lockout.Tracker {
+	cfg := d.Config.Auth.Lockout
+	if !cfg.Enabled {
+		return lockout.NewTracker(d.Attempts, lockout.Policy{}, lockout.Policy{})
+	}
+	return lockout.NewTracker(d.Attempts, lockoutPolicy(cfg.Account), lockoutPolicy(cfg.IP))
+}
+
+func newOIDCRegistry(cfg *config.Config) *oidc.Registry {
+	return oidc.NewRegistry(cfg.Auth.OIDC.Providers, func(name string) string {
+		return handlers.OIDCCallbackURL(cfg, name)
+	}, nil)
+}
+
+func lockoutPolicy(p config.LockoutPolicy) lockout.Policy {
+	return lockout.Policy{
+		Threshold:  p.Threshold,
+		BaseDelay:  time.Duration(p.BaseDelay),
+		MaxDelay:   time.Duration(p.MaxDelay),
+		ResetAfter: time.Duration(p.ResetAfter),
+	}
+}
+
+func defaultLocale(cfg *config.Config) i18n.Locale {
+	if loc, err := cfg.I18n.Locale(); err == nil {
+		return loc
+	}
+	return i18n.Default
+}
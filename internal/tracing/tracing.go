@@ -0,0 +1,67 @@
+// Package tracing configures OpenTelemetry tracing: the W3C trace context propagator and, when enabled,
+// a tracer provider that exports spans over OTLP/HTTP or to stdout.
+package tracing
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"github.com/my-deer/mydeer/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ShutdownFunc flushes the buffered spans and stops the exporter
+type ShutdownFunc func(ctx context.Context) error
+
+// Setup installs the global propagator and tracer provider. The propagator is installed even when
+// tracing is disabled, so an incoming traceparent still reaches the logs and outgoing requests.
+// The returned function must be called before the process exits.
+func Setup(ctx context.Context, cfg config.TracingConfig) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+		// 呼び出し元がサンプリング済みのトレースは割合に関係なく記録する
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case config.TracingExporterStdout:
+		exporter, err := stdouttrace.New()
+		if err != nil {
+			return nil, errors.Wrap(err, "tracing: failed to create stdout exporter")
+		}
+		return exporter, nil
+	case config.TracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "tracing: failed to create otlp exporter")
+		}
+		return exporter, nil
+	default:
+		return nil, errors.Newf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+}
@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetupDisabledStillPropagates(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{})
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	header := http.Header{"Traceparent": []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	sc := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.True(t, sc.IsRemote())
+}
+
+func TestSetupStdout(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Enabled: true, Exporter: config.TracingExporterStdout, SampleRatio: 1, ServiceName: "mydeer"})
+	require.NoError(t, err)
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	assert.True(t, span.SpanContext().IsSampled())
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), config.TracingConfig{Enabled: true, Exporter: "jaeger"})
+	assert.ErrorContains(t, err, "jaeger")
+}
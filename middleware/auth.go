@@ -1,55 +1,105 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/auth"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
 )
 
-const SECRET_KEY = "SECRET"
+// principalKey is the gin context key holding the authenticated auth.Principal
+const principalKey = "principal"
 
-func Auth(c *gin.Context) {
-	// Cookie "token" からJWTトークンを取得
-	tokenString, err := c.Cookie("token")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "token not found in cookie"})
-		c.Abort()
-		return
-	}
+// SessionChecker reports whether a session family is still active (not logged out or revoked)
+type SessionChecker interface {
+	IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error)
+}
+
+// Auth ミドルウェアはアクセストークンを検証し、認証済みのPrincipalをコンテキストにセットします。
+// トークンは "Authorization: Bearer" ヘッダー、無ければ accessCookie（通常は "token"）から取得します。
+// ルートグループに適用して使用します。
+func Auth(keys *auth.KeySet, sessions SessionChecker, accessCookie string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := extractToken(c, accessCookie)
+		if tokenString == "" {
+			abortWithError(c, apperrors.ErrUnauthenticated)
+			return
+		}
+
+		// JWTトークンの解析と署名方式・有効期限の検証（kidヘッダーで検証鍵を選択）
+		claims := &auth.Claims{}
+		token, err := keys.Parse(tokenString, auth.AudienceAccess, claims)
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			abortWithError(c, apperrors.ErrTokenExpired)
+			return
+		}
+		if err != nil || !token.Valid {
+			abortWithError(c, apperrors.Wrap(err, apperrors.ErrAuthInvalid, apperrors.ErrInvalidToken.Message, http.StatusUnauthorized))
+			return
+		}
 
-	// JWTトークンの解析と署名方式の検証
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// HMAC方式のみ許容
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		principal, err := claims.Principal()
+		if err != nil {
+			abortWithError(c, apperrors.Wrap(err, apperrors.ErrAuthInvalid, apperrors.ErrInvalidToken.Message, http.StatusUnauthorized))
+			return
+		}
+
+		// セッションが失効（ログアウト・再利用検知）していないかをDBで確認
+		active, err := sessions.IsSessionActive(c, principal.SessionID)
+		if err != nil {
+			abortWithError(c, apperrors.Wrap(err, apperrors.ErrInternal, "Failed to verify session", http.StatusInternalServerError))
+			return
+		}
+		if !active {
+			abortWithError(c, apperrors.ErrSessionRevoked)
+			return
 		}
-		return []byte(SECRET_KEY), nil
-	})
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token", "details": err.Error()})
-		c.Abort()
-		return
+
+		c.Set(principalKey, principal)
+		c.Next()
 	}
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
-		c.Abort()
-		return
+// GetPrincipal returns the principal set by the Auth middleware
+func GetPrincipal(c *gin.Context) (auth.Principal, bool) {
+	if v, exists := c.Get(principalKey); exists {
+		if principal, ok := v.(auth.Principal); ok {
+			return principal, true
+		}
 	}
+	return auth.Principal{}, false
+}
 
-	// トークンの有効期限チェック
-	exp, ok := claims["exp"].(float64)
-	if !ok || int64(exp) < time.Now().Unix() {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
-		c.Abort()
-		return
+// abortWithError stops the chain and leaves the error for ErrorHandler to render
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
+
+// extractToken returns the bearer token from the Authorization header, falling back to the cookie
+func extractToken(c *gin.Context, cookie string) string {
+	if token, ok := bearerToken(c); ok {
+		return token
+	}
+	if token, err := c.Cookie(cookie); err == nil {
+		return token
 	}
+	return ""
+}
 
-	// 必要に応じてclaimsをコンテキストにセット
-	c.Set("claims", claims)
-	c.Next()
+// bearerToken returns the token of a well-formed "Authorization: Bearer <token>" header.
+// Other schemes and empty tokens report false, and the request is authenticated by the cookie.
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
 }
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/my-deer/mydeer/internal/auth"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+)
+
+// CSRF ミドルウェアはCookieで認証する状態変更リクエスト（GET/HEAD/OPTIONS以外）に、
+// csrfCookie の値を X-CSRF-Token ヘッダーで送り返すことを要求します（ダブルサブミット方式）。
+// 他サイトのページはCookieを読めないため、ヘッダーを付けられません。
+// authCookies のどれも送られていないリクエストと、Bearer トークンで認証するリクエストは対象外です。
+// Bearer 以外の Authorization ヘッダーはCookieで認証されるため対象になります。
+func CSRF(csrfCookie string, authCookies ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !stateChanging(c.Request.Method) || !hasAnyCookie(c, authCookies) {
+			c.Next()
+			return
+		}
+		if _, ok := bearerToken(c); ok {
+			c.Next()
+			return
+		}
+
+		expected, err := c.Cookie(csrfCookie)
+		token := c.GetHeader(auth.CSRFHeader)
+		if err != nil || expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+			abortWithError(c, apperrors.ErrInvalidCSRFToken)
+			return
+		}
+		c.Next()
+	}
+}
+
+// stateChanging reports whether the method may change state, i.e. is not a safe method
+func stateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func hasAnyCookie(c *gin.Context, names []string) bool {
+	for _, name := range names {
+		if v, err := c.Cookie(name); err == nil && v != "" {
+			return true
+		}
+	}
+	return false
+}
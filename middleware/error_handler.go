@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/i18n"
 	"github.com/my-deer/mydeer/utils"
 )
 
@@ -27,11 +28,7 @@ func ErrorHandler() gin.HandlerFunc {
 
 				logger.Error("panic recovered", "error", err)
 				appErr := errors.FormatError(err)
-				c.AbortWithStatusJSON(appErr.HTTPStatus, gin.H{
-					"error":   appErr.Code,
-					"message": appErr.Message,
-					"details": appErr.Details,
-				})
+				c.AbortWithStatusJSON(appErr.HTTPStatus, errorResponse(appErr, GetLocale(c)))
 			}
 		}()
 
@@ -41,14 +38,36 @@ func ErrorHandler() gin.HandlerFunc {
 		// Handle errors that were set during request processing
 		if len(c.Errors) > 0 {
 			err := c.Errors.Last().Err
-			logger.Error("request error", "error", err, "path", c.Request.URL.Path)
-
 			appErr := errors.FormatError(err)
-			c.JSON(appErr.HTTPStatus, gin.H{
-				"error":   appErr.Code,
-				"message": appErr.Message,
-				"details": appErr.Details,
-			})
+
+			// クライアント起因のエラー(4xx)はWarn、サーバー起因のエラー(5xx)はError
+			if appErr.HTTPStatus >= http.StatusInternalServerError {
+				logger.Error("request error", "error", err, "path", c.Request.URL.Path)
+			} else {
+				logger.Warn("request error", "error", err, "path", c.Request.URL.Path)
+			}
+
+			c.JSON(appErr.HTTPStatus, errorResponse(appErr, GetLocale(c)))
+		}
+	}
+}
+
+// errorResponse renders appErr with the message in the given locale.
+// The "error" code stays the same in every locale.
+func errorResponse(appErr *errors.AppError, loc i18n.Locale) gin.H {
+	details := appErr.Details
+	if violations, ok := details.([]errors.FieldViolation); ok {
+		localized := make([]errors.FieldViolation, len(violations))
+		for i, v := range violations {
+			v.Message = i18n.ValidationMessage(loc, v.Rule, v.Param)
+			localized[i] = v
 		}
+		details = localized
+	}
+
+	return gin.H{
+		"error":   appErr.Code,
+		"message": i18n.Message(loc, appErr.Code, appErr.Message),
+		"details": details,
 	}
 }
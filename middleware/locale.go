@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/my-deer/mydeer/internal/i18n"
+)
+
+// localeKey is the gin context key holding the locale negotiated from Accept-Language
+const localeKey = "locale"
+
+// Locale ミドルウェアはAccept-Languageヘッダーからメッセージの言語を決定してコンテキストにセットします。
+// 対応言語が見つからない場合は fallback を使います。
+func Locale(fallback i18n.Locale) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(localeKey, i18n.Negotiate(c.GetHeader("Accept-Language"), fallback))
+		c.Next()
+	}
+}
+
+// GetLocale returns the locale of the response.
+// The preference of the authenticated player wins over Accept-Language.
+func GetLocale(c *gin.Context) i18n.Locale {
+	if principal, ok := GetPrincipal(c); ok && principal.Locale != "" {
+		if loc, ok := i18n.Parse(principal.Locale); ok {
+			return loc
+		}
+	}
+	if v, exists := c.Get(localeKey); exists {
+		if loc, ok := v.(i18n.Locale); ok {
+			return loc
+		}
+	}
+	return i18n.Default
+}
@@ -1,23 +1,65 @@
 package middleware
 
 import (
-	"os"
+	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 )
 
-// RequestLogger ミドルウェアはリクエストIDを設定し、リクエスト開始・終了時のログを出力します。
-func RequestLogger() gin.HandlerFunc {
+// RequestIDHeader carries the request ID from the client or proxy and back in the response
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern limits the accepted request IDs so a client cannot inject arbitrary text into the logs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestLogger ミドルウェアはリクエストIDを設定し、リクエストのロガーを用意して、終了時にアクセスログを出力します。
+// trustRequestID が true の場合は受け取った X-Request-ID を引き継ぎ、リクエストIDはレスポンスヘッダーでも返します。
+// Tracing の後に適用すると、ログにトレースID・スパンIDが付き、スパンにもリクエストIDが記録されます。
+func RequestLogger(base *slog.Logger, trustRequestID bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestID := uuid.New().String()
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !trustRequestID || !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
 		c.Set("request_id", requestID)
-		logger := slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request_id", requestID))
+
+		logger := utils.WithTrace(c.Request.Context(), base.With("request_id", requestID))
 		c.Set("logger", logger)
-		logger.Info("request_started", "method", c.Request.Method, "path", c.Request.URL.Path)
+		logger.Debug("request_started", "method", c.Request.Method, "path", c.Request.URL.Path)
+
 		c.Next()
+
 		status := c.Writer.Status()
-		logger.Info("request_completed", "status", status)
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if principal, ok := GetPrincipal(c); ok {
+			attrs = append(attrs, "user_id", principal.UserID)
+		}
+
+		// サーバー起因のエラーはサンプリングされないよう Error で出力する
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(c.Request.Context(), level, "request_completed", attrs...)
 	}
 }
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestObserver records handled requests
+type RequestObserver interface {
+	ObserveRequest(method, route string, status int, elapsed time.Duration)
+}
+
+// Metrics ミドルウェアはリクエストの件数と処理時間を、登録されたルートのパターンとステータスごとに記録します。
+// ErrorHandler より前に適用し、エラーレスポンスのステータスも記録します。
+func Metrics(observer RequestObserver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		observer.ObserveRequest(requestMethod(c.Request), c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
+
+// OtherMethod replaces a non-standard request method in metric labels and span names
+const OtherMethod = "other"
+
+// requestMethod returns the method of r, or OtherMethod when it is not a standard one.
+// net/http accepts any token as a method, so the raw value would let clients create any number of series.
+func requestMethod(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return r.Method
+	default:
+		return OtherMethod
+	}
+}
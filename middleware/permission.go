@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+)
+
+// PermissionChecker reports whether a role of the user grants a permission
+type PermissionChecker interface {
+	HasPermission(ctx context.Context, userID uuid.UUID, permission string) (bool, error)
+}
+
+// RequirePermission ミドルウェアは認証済みのプレイヤーが permission を持つ場合だけ処理を続けます。
+// Auth の後に適用します。権限はトークンのロールではなく、リクエストごとにDBで確認します。
+func RequirePermission(checker PermissionChecker, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			abortWithError(c, apperrors.ErrUnauthenticated)
+			return
+		}
+
+		allowed, err := checker.HasPermission(c, principal.UserID, permission)
+		if err != nil {
+			abortWithError(c, apperrors.Wrap(err, apperrors.ErrInternal, "Failed to check permissions", http.StatusInternalServerError))
+			return
+		}
+		if !allowed {
+			abortWithError(c, apperrors.ErrPermissionDenied)
+			return
+		}
+		c.Next()
+	}
+}
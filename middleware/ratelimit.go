@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apperrors "github.com/my-deer/mydeer/internal/errors"
+	"github.com/my-deer/mydeer/internal/ratelimit"
+	"github.com/my-deer/mydeer/utils"
+)
+
+// RateLimiter counts a request of subject under a policy
+type RateLimiter interface {
+	Allow(ctx context.Context, policy ratelimit.Policy, subject string) (ratelimit.Result, error)
+}
+
+// RateLimit ミドルウェアはルートに設定されたポリシーごとにリクエストを数え、超えた場合は 429 を返します。
+// プレイヤー単位で数えるため、認証が必要なルートでは Auth の後に適用します。
+// 残りの回数は RateLimit-Limit / RateLimit-Remaining / RateLimit-Reset ヘッダーで返します。
+func RateLimit(limiter RateLimiter, routes *ratelimit.Routes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies := routes.Match(c.Request.Method, c.FullPath())
+		if len(policies) == 0 {
+			c.Next()
+			return
+		}
+
+		// 複数のポリシーが当たる場合は残りが最も少ないものをヘッダーで返す
+		remaining := math.MaxInt
+		for _, policy := range policies {
+			res, err := limiter.Allow(c, policy, rateLimitSubject(c, policy.By))
+			if err != nil {
+				// カウンターのストアが使えない場合はリクエストを止めない
+				utils.GetLogger(c).Error("rate limit: failed to count request", "policy", policy.Name, "error", err.Error())
+				continue
+			}
+			if !res.Allowed {
+				utils.GetLogger(c).Warn("rate limit: limit exceeded", "policy", policy.Name, "client_ip", c.ClientIP())
+				setRateLimitHeaders(c, policy, res)
+				c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				abortWithError(c, apperrors.ErrTooManyRequests)
+				return
+			}
+			if res.Remaining < remaining {
+				setRateLimitHeaders(c, policy, res)
+				remaining = res.Remaining
+			}
+		}
+		c.Next()
+	}
+}
+
+// rateLimitSubject returns what the request is counted as under a policy of the scope
+func rateLimitSubject(c *gin.Context, by ratelimit.Scope) string {
+	switch by {
+	case ratelimit.ScopePlayer:
+		if principal, ok := GetPrincipal(c); ok {
+			return "player:" + principal.UserID.String()
+		}
+	case ratelimit.ScopeRoute:
+		return "route:" + c.Request.Method + " " + c.FullPath()
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// setRateLimitHeaders reports the quota of the policy in the RateLimit-* headers
+func setRateLimitHeaders(c *gin.Context, policy ratelimit.Policy, res ratelimit.Result) {
+	c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	c.Header("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(ceilSeconds(policy.Window)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
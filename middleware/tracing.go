@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of the spans created by the server
+const TracerName = "github.com/my-deer/mydeer"
+
+// Tracing ミドルウェアは受け取った traceparent ヘッダーを引き継ぎ、リクエストごとにサーバースパンを開始します。
+// スパンは c.Request のコンテキストに入るため、c をコンテキストとして渡したDBクエリもこのスパンの子になります。
+// 他のミドルウェアより先に適用します。
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		// スパン名には実際のパスではなくルートのパターンを使い、IDなどで名前が増えないようにする
+		// （メソッドも任意の値を受け付けるため、標準以外は OtherMethod にまとめる）
+		route := c.FullPath()
+		method := requestMethod(c.Request)
+		name := method
+		if route != "" {
+			name += " " + route
+		}
+
+		attributes := []attribute.KeyValue{
+			attribute.String("http.request.method", method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("client.address", c.ClientIP()),
+			attribute.String("user_agent.original", c.Request.UserAgent()),
+		}
+		if method != c.Request.Method {
+			attributes = append(attributes, attribute.String("http.request.method_original", c.Request.Method))
+		}
+		ctx, span := otel.Tracer(TracerName).Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attributes...),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/my-deer/mydeer/internal/config"
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+const migrateUsage = `usage: mydeer migrate [flags] <command>
+
+commands:
+  up             apply all pending migrations
+  down           roll back migrations (-steps, default 1, or -all)
+  status         show the schema version and pending migrations
+  create <name>  create an empty up/down migration pair in -dir
+
+flags:
+`
+
+// runMigrate implements the "migrate" subcommand and returns the process exit code
+func runMigrate(args []string) int {
+	fset := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := fset.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	steps := fset.Int("steps", 1, "number of migrations to roll back with down")
+	all := fset.Bool("all", false, "roll back every migration with down")
+	dir := fset.String("dir", "migrations", "migrations directory used by create")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), migrateUsage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return 2
+	}
+
+	command := fset.Arg(0)
+	if command == "create" {
+		if fset.NArg() != 2 {
+			fset.Usage()
+			return 2
+		}
+		files, err := db.CreateMigration(*dir, fset.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate create:", err)
+			return 1
+		}
+		for _, f := range files {
+			fmt.Println("created", f)
+		}
+		return 0
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+
+	migrator, err := db.NewMigrator(cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		if *all {
+			err = migrator.DownAll()
+		} else {
+			err = migrator.Down(*steps)
+		}
+	case "status":
+		err = printMigrationStatus(migrator)
+	default:
+		fset.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(migrator *db.Migrator) error {
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %d", status.Version)
+	if status.Dirty {
+		fmt.Print(" (dirty)")
+	}
+	fmt.Println()
+	for _, m := range status.Migrations {
+		state := "pending"
+		if m.Applied {
+			state = "applied"
+		}
+		fmt.Printf("  %04d  %-8s %s\n", m.Version, state, m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+-- プレイヤーが選んだメッセージの言語。空文字は未設定（Accept-Languageに従う）
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- ログイン失敗の記録（キーは "email:<アドレス>" または "ip:<IPアドレス>"）
+CREATE TABLE login_attempts (
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX login_attempts_locked_until_idx ON login_attempts (locked_until);
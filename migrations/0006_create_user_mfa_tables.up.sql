@@ -0,0 +1,20 @@
+-- TOTP二要素認証。totp_secret は暗号化して保存し、enabled_at が NULL の間は登録確認待ち
+CREATE TABLE user_mfa (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  totp_secret TEXT NOT NULL,
+  enabled_at TIMESTAMP WITH TIME ZONE,
+  -- 同じコードの再利用を防ぐため最後に使われたTOTPのタイムステップを記録する
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 使い捨てのリカバリーコード（ハッシュのみ保存）
+CREATE TABLE mfa_recovery_codes (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
@@ -0,0 +1,14 @@
+-- OpenID Connect（SSO）プロバイダーのアカウントとの紐付け
+CREATE TABLE user_identities (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider TEXT NOT NULL,
+  -- プロバイダー内で一意なユーザーID（IDトークンの sub）
+  subject TEXT NOT NULL,
+  -- 紐付け時にプロバイダーが返したメールアドレス（参考情報）
+  email TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,20 @@
+-- プレイヤーの公開プロフィール。handle は公開URL（/players/:handle）に使う
+CREATE TABLE player_profiles (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  handle TEXT NOT NULL,
+  display_name TEXT NOT NULL,
+  bio TEXT NOT NULL DEFAULT '',
+  -- アバター画像の参照（URLなど）。空文字は未設定
+  avatar_ref TEXT NOT NULL DEFAULT '',
+  -- 現在いる街。空文字はどの街にもいない
+  current_town TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- handle は大文字小文字を区別せず一意
+CREATE UNIQUE INDEX player_profiles_handle_key ON player_profiles (lower(handle));
+
+-- 既存ユーザーには生成したhandleでプロフィールを作る
+INSERT INTO player_profiles (user_id, handle, display_name, created_at)
+SELECT id, 'player_' || substr(replace(id::text, '-', ''), 1, 10), name, created_at FROM users;
@@ -0,0 +1,3 @@
+DROP TABLE audit_logs;
+DROP INDEX users_delete_after_idx;
+ALTER TABLE users DROP COLUMN delete_after;
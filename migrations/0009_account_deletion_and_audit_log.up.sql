@@ -0,0 +1,17 @@
+-- 退会（アカウント削除）の予定日時。NULL は申請なし。猶予期間中にログインすると取り消される
+ALTER TABLE users ADD COLUMN delete_after TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX users_delete_after_idx ON users (delete_after) WHERE delete_after IS NOT NULL;
+
+-- アカウントに関する操作の記録（退会申請・取り消し・削除・データのエクスポート）。
+-- アカウント削除後も残すため users への外部キーは張らず、削除時に ip_address と user_agent を消す
+CREATE TABLE audit_logs (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL,
+  action TEXT NOT NULL,
+  ip_address TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_logs_user_id_idx ON audit_logs (user_id, created_at);
@@ -0,0 +1,5 @@
+ALTER TABLE audit_logs DROP COLUMN actor_id;
+ALTER TABLE users DROP COLUMN suspended_at;
+DROP TABLE user_roles;
+DROP TABLE role_permissions;
+DROP TABLE roles;
@@ -0,0 +1,40 @@
+-- ロールと権限。player は全プレイヤーが暗黙に持つため user_roles には入れない
+CREATE TABLE roles (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+  role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+  permission TEXT NOT NULL,
+  PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE user_roles (
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, role)
+);
+
+CREATE INDEX user_roles_role_idx ON user_roles (role);
+
+INSERT INTO roles (name, description) VALUES
+  ('player', 'すべてのプレイヤー'),
+  ('moderator', 'プレイヤーの検索・利用停止・強制ログアウト'),
+  ('admin', 'モデレーターの権限に加えてロールの変更');
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('moderator', 'users:read'),
+  ('moderator', 'users:suspend'),
+  ('moderator', 'sessions:revoke'),
+  ('admin', 'users:read'),
+  ('admin', 'users:suspend'),
+  ('admin', 'sessions:revoke'),
+  ('admin', 'roles:assign');
+
+-- 利用停止の日時。NULL は停止されていない
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP WITH TIME ZONE;
+
+-- 操作したアカウント（本人・管理者）。NULL はシステム（削除ジョブ・管理コマンド）
+ALTER TABLE audit_logs ADD COLUMN actor_id UUID;
@@ -0,0 +1,11 @@
+-- レート制限のカウンター（固定ウィンドウごとのリクエスト数。直前のウィンドウと合わせてスライディングウィンドウで評価する）
+-- キーは "<ルール名>:<ip:... | player:... | route:...>"
+CREATE TABLE rate_limits (
+  key TEXT NOT NULL,
+  window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+  hits INTEGER NOT NULL DEFAULT 0,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX rate_limits_expires_at_idx ON rate_limits (expires_at);
@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL schema migrations into the binary.
+package migrations
+
+import "embed"
+
+// FS holds the *.up.sql / *.down.sql files of this directory
+//
+//go:embed *.sql
+var FS embed.FS
@@ -2,66 +2,150 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/binding"
-	"github.com/go-playground/validator/v10"
-	_ "github.com/lib/pq"
-	"github.com/my-deer/mydeer/handlers"
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/account"
+	"github.com/my-deer/mydeer/internal/auth"
+	"github.com/my-deer/mydeer/internal/config"
 	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/db/memory"
+	"github.com/my-deer/mydeer/internal/health"
+	"github.com/my-deer/mydeer/internal/logging"
+	"github.com/my-deer/mydeer/internal/mail"
+	"github.com/my-deer/mydeer/internal/metrics"
+	"github.com/my-deer/mydeer/internal/mfa"
+	"github.com/my-deer/mydeer/internal/oidc/oidctest"
+	"github.com/my-deer/mydeer/internal/passcheck"
+	"github.com/my-deer/mydeer/internal/server"
+	"github.com/my-deer/mydeer/internal/tracing"
 	"github.com/my-deer/mydeer/middleware"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/exp/slog"
 )
 
-var (
-	testDB     *db.DB
-	testRouter *gin.Engine
-)
+var testRouter *gin.Engine
+
+// testMail receives every email sent by the test server
+var testMail *bytes.Buffer
+
+// testUsers is the user repository of the test server, for tests that need to seed rows directly
+var testUsers db.UserRepository
+
+// testAccounts is the account service of the test server, for tests that run the purge job
+var testAccounts *account.Service
 
-var dsn = fmt.Sprintf("postgres://%s:%s@localhost:%s/%s?sslmode=disable", os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_PORT"), os.Getenv("POSTGRES_DB"))
+// usePostgres selects the PostgreSQL repositories instead of the in-memory ones.
+// Set TEST_DB=postgres (see `make apitest`) to run the suite against the docker-compose database.
+var usePostgres = os.Getenv("TEST_DB") == "postgres"
 
 // setupTestServer configures a test server with the same middleware and routes as production
 func setupTestServer(t *testing.T) {
+	setupTestServerWith(t, nil)
+}
+
+// setupTestServerWith is setupTestServer with a hook to adjust the configuration, e.g. to add OIDC providers
+func setupTestServerWith(t *testing.T, configure func(cfg *config.Config)) {
 	// Set Gin to test mode
 	gin.SetMode(gin.TestMode)
 
-	// Create a new router
-	r := gin.New()
-
-	// Register custom validators
-	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
-		handlers.RegisterValidators(v)
+	// Load configuration from the environment (.env.test)
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Failed to load test configuration: %v", err)
+	}
+	// ほとんどのテストはCSRFトークン無しでCookie認証するため無効にする（TestCSRFProtection で有効にして確認）
+	cfg.Cookie.CSRF = false
+	// 同じクライアントIPから大量に呼ぶテストがあるためレート制限も無効にする（TestRateLimit で確認）
+	cfg.RateLimit.Enabled = false
+	if configure != nil {
+		configure(cfg)
 	}
 
-	// Set up database connection
-	conn, err := sql.Open("postgres", dsn)
+	keys, err := auth.NewEphemeralKeySet()
 	if err != nil {
-		t.Fatalf("Failed to connect to test database: %v", err)
+		t.Fatalf("Failed to create jwt keys: %v", err)
+	}
+
+	testMail = &bytes.Buffer{}
+	deps := server.Deps{
+		Config:  cfg,
+		Mailer:  mail.NewWriterMailer("no-reply@example.com", testMail),
+		Keys:    keys,
+		Checker: health.NewChecker(time.Second),
 	}
 
-	testDB = db.New(conn)
+	var mfaRepo db.MFARepository
+	var audit db.AuditLogRepository
+	if usePostgres {
+		// Set up database connection
+		testDB, err := db.Open(cfg.Database)
+		if err != nil {
+			t.Fatalf("Failed to connect to test database: %v", err)
+		}
+		t.Cleanup(func() { testDB.Close() })
+		deps.Users, deps.Sessions, deps.Tokens, deps.Tx, deps.Attempts, deps.Identities, deps.Profiles = testDB, testDB, testDB, testDB, testDB, testDB, testDB
+		deps.Roles, deps.RateLimits = testDB, testDB
+		mfaRepo, audit = testDB, testDB
+	} else {
+		store := memory.New()
+		deps.Users, deps.Sessions, deps.Tokens, deps.Tx, deps.Attempts, deps.Identities, deps.Profiles = store, store, store, store, store, store, store
+		deps.Roles, deps.RateLimits = store, store
+		mfaRepo, audit = store, store
+	}
 
-	// Add middleware
-	r.Use(func(c *gin.Context) {
-		c.Set("mydb", testDB)
-		c.Next()
+	deps.MFA, err = mfa.NewService(mfaRepo, "mydeer", bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatalf("Failed to create mfa service: %v", err)
+	}
+	deps.Accounts = account.NewService(account.Deps{
+		Users:       deps.Users,
+		Sessions:    deps.Sessions,
+		Tokens:      deps.Tokens,
+		Attempts:    deps.Attempts,
+		Identities:  deps.Identities,
+		Profiles:    deps.Profiles,
+		Audit:       audit,
+		Roles:       deps.Roles,
+		Tx:          deps.Tx,
+		MFA:         deps.MFA,
+		GracePeriod: time.Duration(cfg.Account.DeletionGracePeriod),
 	})
-	r.Use(middleware.RequestLogger())
-	r.Use(middleware.ErrorHandler())
 
-	// Register routes
-	r.POST("/login", handlers.LoginHandler)
-	r.POST("/signup", handlers.SignupHandler)
+	lists := []passcheck.BreachList{passcheck.Bundled()}
+	if path := cfg.Auth.PasswordPolicy.BreachedList; path != "" {
+		list, err := passcheck.Open(path, cfg.Auth.PasswordPolicy.MinBreachCount)
+		if err != nil {
+			t.Fatalf("Failed to open breached password list: %v", err)
+		}
+		lists = append(lists, list)
+	}
+	deps.Passwords = passcheck.NewChecker(cfg.Auth.PasswordPolicy.MinScore, lists...)
+
+	deps.Metrics = metrics.New()
+	deps.Metrics.RegisterSessions(deps.Sessions, time.Second)
 
-	testRouter = r
+	testUsers = deps.Users
+	testAccounts = deps.Accounts
+	testRouter = server.NewRouter(deps)
 }
 
 // TestMain sets up the test environment
@@ -71,6 +155,14 @@ func TestMain(m *testing.M) {
 	logger := slog.New(handler)
 	slog.SetDefault(logger)
 
+	// Prepare the test database with the embedded migrations
+	if usePostgres {
+		if err := migrateTestDB(); err != nil {
+			slog.Error("failed to prepare test database", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// Run tests
 	exitVal := m.Run()
 
@@ -79,6 +171,19 @@ func TestMain(m *testing.M) {
 	os.Exit(exitVal)
 }
 
+func migrateTestDB() error {
+	cfg, err := config.Load("")
+	if err != nil {
+		return err
+	}
+	migrator, err := db.NewMigrator(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	return migrator.Up()
+}
+
 func TestSignupEndpoint(t *testing.T) {
 	setupTestServer(t)
 
@@ -134,7 +239,7 @@ func TestSignupEndpoint(t *testing.T) {
 				"password": "Test1234!@#$",
 				"name":     "Duplicate User",
 			},
-			expectedStatus: http.StatusBadRequest, // Second attempt should fail
+			expectedStatus: http.StatusConflict, // Second attempt should fail
 			expectedKey:    "error",
 		},
 	}
@@ -175,6 +280,127 @@ func TestSignupEndpoint(t *testing.T) {
 	}
 }
 
+func TestErrorResponseFormat(t *testing.T) {
+	setupTestServer(t)
+
+	post := func(body map[string]interface{}) (int, map[string]interface{}) {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	// Validation errors list each failing field with its rule
+	status, response := post(map[string]interface{}{
+		"email":    "not-an-email",
+		"password": "password",
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "VALIDATION_ERROR", response["error"])
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"field": "email", "rule": "email", "message": "must be a valid email address"},
+		map[string]interface{}{"field": "password", "rule": "min", "param": "12", "message": "must be at least 12 characters"},
+		map[string]interface{}{"field": "name", "rule": "required", "message": "is required"},
+	}, response["details"])
+
+	// Duplicates are reported with the conflicting field
+	valid := map[string]interface{}{
+		"email":    "format_test@example.com",
+		"password": "Test1234!@#$",
+		"name":     "Format Test User",
+	}
+	status, _ = post(valid)
+	assert.Equal(t, http.StatusOK, status)
+	status, response = post(valid)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "DB_DUPLICATE", response["error"])
+	assert.Equal(t, "email", response["details"].(map[string]interface{})["field"])
+}
+
+func TestLocalizedErrorMessages(t *testing.T) {
+	setupTestServer(t)
+
+	request := func(acceptLanguage string, body map[string]interface{}) map[string]interface{} {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	invalid := map[string]interface{}{
+		"email":    "localized@example.com",
+		"password": "lowercaseonly1",
+		"name":     "Localized",
+	}
+
+	// 既定はja、コードは言語によらず同じ
+	for _, lang := range []string{"", "ja-JP", "fr"} {
+		response := request(lang, invalid)
+		assert.Equal(t, "VALIDATION_ERROR", response["error"])
+		assert.Equal(t, "入力内容に誤りがあります", response["message"])
+		details := response["details"].([]interface{})
+		assert.Equal(t, "大文字・小文字・数字・記号をそれぞれ1文字以上含めてください", details[0].(map[string]interface{})["message"])
+	}
+
+	response := request("en", invalid)
+	assert.Equal(t, "VALIDATION_ERROR", response["error"])
+	assert.Equal(t, "Invalid input parameters", response["message"])
+
+	// 不正なロケール設定は弾く
+	invalid["password"] = "Test1234!@#$"
+	invalid["locale"] = "fr"
+	response = request("en", invalid)
+	assert.Equal(t, "VALIDATION_ERROR", response["error"])
+	assert.Equal(t, "locale", response["details"].([]interface{})[0].(map[string]interface{})["field"])
+}
+
+func TestUserLocalePreference(t *testing.T) {
+	setupTestServer(t)
+
+	email := "locale_pref@example.com"
+	body, _ := json.Marshal(map[string]interface{}{
+		"email":    email,
+		"password": "Test1234!@#$",
+		"name":     "Locale Pref",
+		"locale":   "en",
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	verifyEmail(t, email)
+
+	cookies := login(t, email)
+
+	// ログイン済みプレイヤーの設定がAccept-Languageより優先される
+	req, _ = http.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("Accept-Language", "ja")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var principal map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &principal))
+	assert.Equal(t, "en", principal["locale"])
+}
+
 func TestLoginEndpoint(t *testing.T) {
 	setupTestServer(t)
 
@@ -270,6 +496,98 @@ func TestLoginEndpoint(t *testing.T) {
 	}
 }
 
+func TestPasswordHashUpgrade(t *testing.T) {
+	setupTestServer(t)
+	ctx := context.Background()
+
+	// bcrypt時代に登録されたユーザー
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Test1234!@#$"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	user, err := testUsers.CreateUser(ctx, db.CreateUserParams{Email: "legacy_hash@example.com", Password: string(legacy), Name: "Legacy"})
+	assert.NoError(t, err)
+	assert.NoError(t, testUsers.MarkEmailVerified(ctx, user.ID))
+
+	login(t, "legacy_hash@example.com")
+
+	// ログイン成功時にArgon2idへ移行し、新しいハッシュでもログインできる
+	stored, err := testUsers.GetUserByEmail(ctx, "legacy_hash@example.com")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"), stored.Password)
+	login(t, "legacy_hash@example.com")
+
+	// bcryptの72バイト制限はなくなった
+	long := "Tq8!mZ2#wL5$rX9^kP3&vN7*bH4(jD6)sF1_gC0+yU8=eR2?aQ5<oI9>lK3;cV7:nB1~hM4%xW6"
+	w := postJSON(t, "/signup", map[string]interface{}{"email": "long_password@example.com", "password": long, "name": "Long"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	verifyEmail(t, "long_password@example.com")
+	w = postJSON(t, "/login", map[string]interface{}{"email": "long_password@example.com", "password": long})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = postJSON(t, "/login", map[string]interface{}{"email": "long_password@example.com", "password": long[:72]})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLoginLockout(t *testing.T) {
+	setupTestServer(t)
+
+	email := "lockout_test@example.com"
+	createTestUserWithEmail(t, email)
+
+	attempt := func(email, password string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(map[string]interface{}{"email": email, "password": password})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	// 存在するアカウントもしないアカウントも、閾値までは同じ汎用エラー、その後は同じロック応答
+	for _, address := range []string{email, "ghost@example.com"} {
+		for i := 0; i < 5; i++ {
+			w := attempt(address, "WrongPassword123!")
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+
+		w := attempt(address, "Test1234!@#$")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "AUTH_LOCKED", response["error"])
+	}
+
+	// ロックが明ければ正しいパスワードでログインできる
+	time.Sleep(1100 * time.Millisecond)
+	w := attempt(email, "Test1234!@#$")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoginLockoutIgnoresSpoofedForwardedFor(t *testing.T) {
+	setupTestServerWith(t, func(cfg *config.Config) {
+		cfg.Auth.Lockout.IP.Threshold = 3
+	})
+
+	attempt := func(i int) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(map[string]interface{}{"email": fmt.Sprintf("spoof_%d@example.com", i), "password": "WrongPassword123!"})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "203.0.113.20:41234"
+		// 信用していない接続元からの X-Forwarded-For は毎回変えても同じIPとして数える
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, attempt(i).Code)
+	}
+	w := attempt(3)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"AUTH_LOCKED"`)
+}
+
 // Helper function to create a test user for login tests
 func createTestUser(t *testing.T) {
 	jsonBody, _ := json.Marshal(map[string]interface{}{
@@ -286,4 +604,1404 @@ func createTestUser(t *testing.T) {
 
 	// Ensure user was created
 	assert.Equal(t, http.StatusOK, w.Code)
+	verifyEmail(t, "login_test@example.com")
+}
+
+func TestEmailVerification(t *testing.T) {
+	setupTestServer(t)
+
+	post := func(path string, body map[string]interface{}) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	errorCode := func(w *httptest.ResponseRecorder) string {
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		code, _ := response["error"].(string)
+		return code
+	}
+
+	email := "verify_test@example.com"
+	credentials := map[string]interface{}{"email": email, "password": "Test1234!@#$"}
+	w := post("/signup", map[string]interface{}{"email": email, "password": "Test1234!@#$", "name": "Verify Test"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	token := verificationToken(t, email)
+
+	// 未確認のアカウントはログインできない
+	w = post("/login", credentials)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "AUTH_UNVERIFIED", errorCode(w))
+
+	// 直後の再送はメールを送らないが、アカウントの有無が分からないよう同じ応答を返す
+	sent := testMail.Len()
+	w = post("/verify-email/resend", map[string]interface{}{"email": email})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, sent, testMail.Len())
+
+	// 不正なトークン
+	w = post("/verify-email", map[string]interface{}{"token": "not-a-token"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "AUTH_INVALID", errorCode(w))
+
+	// POSTでも確認できる
+	w = post("/verify-email", map[string]interface{}{"token": token})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// トークンは一度しか使えない
+	w = post("/verify-email", map[string]interface{}{"token": token})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = post("/login", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 確認済み・存在しないアドレスへの再送は同じ応答でメールは送らない
+	sent = testMail.Len()
+	for _, address := range []string{email, "nobody@example.com"} {
+		w = post("/verify-email/resend", map[string]interface{}{"email": address})
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+	assert.Equal(t, sent, testMail.Len())
+}
+
+func TestPasswordReset(t *testing.T) {
+	setupTestServer(t)
+
+	email := "reset_test@example.com"
+	createTestUserWithEmail(t, email)
+	cookies := login(t, email)
+
+	post := func(path string, body map[string]interface{}) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	// 存在しないアドレスでも同じ応答
+	for _, address := range []string{email, "nobody@example.com"} {
+		w := post("/password/forgot", map[string]interface{}{"email": address})
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.JSONEq(t, `{"message":"password_reset_email_sent"}`, w.Body.String())
+	}
+	token := mailedToken(t, email, "/password/reset")
+
+	// 弱いパスワードは拒否
+	w := post("/password/reset", map[string]interface{}{"token": token, "password": "weakpassword"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = post("/password/reset", map[string]interface{}{"token": token, "password": "NewPass5678!@#$"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// トークンは一度しか使えない
+	w = post("/password/reset", map[string]interface{}{"token": token, "password": "Other5678!@#$"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 既存のセッションは失効し、新しいパスワードでログインできる
+	w = postWithCookies(t, "/token/refresh", findCookie(cookies, "refresh_token"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = post("/login", map[string]interface{}{"email": email, "password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = post("/login", map[string]interface{}{"email": email, "password": "NewPass5678!@#$"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	setupTestServer(t)
+
+	email := "change_test@example.com"
+	createTestUserWithEmail(t, email)
+	otherSession := login(t, email)
+	currentSession := login(t, email)
+
+	change := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, "/password/change", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range currentSession {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	w := change(map[string]interface{}{"current_password": "Wrong1234!@#$", "new_password": "NewPass5678!@#$"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = change(map[string]interface{}{"current_password": "Test1234!@#$", "new_password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = change(map[string]interface{}{"current_password": "Test1234!@#$", "new_password": "NewPass5678!@#$"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 他のセッションは失効し、現在のセッションは有効なまま
+	w = postWithCookies(t, "/token/refresh", findCookie(otherSession, "refresh_token"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postWithCookies(t, "/token/refresh", findCookie(currentSession, "refresh_token"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 未認証では使えない
+	req, _ := http.NewRequest(http.MethodPost, "/password/change", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	setupTestServer(t)
+
+	email := "change_guess_test@example.com"
+	createTestUserWithEmail(t, email)
+	session := login(t, email)
+
+	// セッションを奪われても、現在のパスワードの総当たりはログインと同じくロックされる
+	for i := 0; i < 5; i++ {
+		w := postJSONWithCookies(t, "/password/change", map[string]interface{}{"current_password": "Wrong1234!@#$", "new_password": "NewPass5678!@#$"}, session)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	w := postJSONWithCookies(t, "/password/change", map[string]interface{}{"current_password": "Test1234!@#$", "new_password": "NewPass5678!@#$"}, session)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"AUTH_LOCKED"`)
+
+	time.Sleep(1100 * time.Millisecond)
+	w = postJSONWithCookies(t, "/password/change", map[string]interface{}{"current_password": "Test1234!@#$", "new_password": "NewPass5678!@#$"}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPasswordScreening(t *testing.T) {
+	// HIBPの範囲ファイル形式の流出リスト
+	breached := "Breached5678!@#$"
+	hash := passcheck.HashPassword(breached)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(hash[5:]+":12\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setupTestServerWith(t, func(cfg *config.Config) {
+		cfg.Auth.PasswordPolicy.BreachedList = dir
+	})
+
+	violation := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var response struct {
+			Error   string                   `json:"error"`
+			Details []map[string]interface{} `json:"details"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "VALIDATION_ERROR", response.Error)
+		if !assert.Len(t, response.Details, 1) {
+			return nil
+		}
+		return response.Details[0]
+	}
+
+	// 文字種の条件は満たすが推測されやすい
+	w := postJSON(t, "/signup", map[string]interface{}{"email": "screening@example.com", "password": "Password123!", "name": "Screening"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	detail := violation(w)
+	assert.Equal(t, "password", detail["field"])
+	assert.Equal(t, "weakpassword", detail["rule"])
+	assert.Equal(t, "dictionary", detail["param"])
+
+	// メールアドレスや名前を含むパスワードは弱い
+	w = postJSON(t, "/signup", map[string]interface{}{"email": "screening@example.com", "password": "Screening1!1!", "name": "Screening"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "weakpassword", violation(w)["rule"])
+
+	w = postJSON(t, "/signup", map[string]interface{}{"email": "screening@example.com", "password": breached, "name": "Screening"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "breachedpassword", violation(w)["rule"])
+
+	// 再設定と変更にも同じ審査を適用する
+	email := "screening_change@example.com"
+	createTestUserWithEmail(t, email)
+	cookies := login(t, email)
+	w = postJSONWithCookies(t, "/password/change", map[string]interface{}{"current_password": "Test1234!@#$", "new_password": breached}, cookies)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	detail = violation(w)
+	assert.Equal(t, "new_password", detail["field"])
+	assert.Equal(t, "breachedpassword", detail["rule"])
+
+	postJSON(t, "/password/forgot", map[string]interface{}{"email": email})
+	token := mailedToken(t, email, "/password/reset")
+	w = postJSON(t, "/password/reset", map[string]interface{}{"token": token, "password": "Password123!"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "weakpassword", violation(w)["rule"])
+
+	// 拒否されてもリンクは消費されない
+	w = postJSON(t, "/password/reset", map[string]interface{}{"token": token, "password": "NewPass5678!@#$"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMFALogin(t *testing.T) {
+	setupTestServer(t)
+
+	email := "mfa_test@example.com"
+	createTestUserWithEmail(t, email)
+	session := login(t, email)
+
+	// 設定開始：シークレットとotpauth URIが返る
+	w := postJSONWithCookies(t, "/mfa/totp/setup", nil, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/mydeer:"))
+
+	now := time.Now()
+	code := func(at time.Time) string {
+		c, err := mfa.CurrentCode(enrollment.Secret, at)
+		assert.NoError(t, err)
+		return c
+	}
+
+	// 誤ったコードでは有効にならない
+	wrong := "000000"
+	if code(now) == wrong {
+		wrong = "111111"
+	}
+	w = postJSONWithCookies(t, "/mfa/totp/confirm", map[string]interface{}{"code": wrong}, session)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postJSONWithCookies(t, "/mfa/totp/confirm", map[string]interface{}{"code": code(now)}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &confirmed))
+	assert.Len(t, confirmed.RecoveryCodes, 10)
+
+	w = postJSONWithCookies(t, "/mfa/totp/setup", nil, session)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// パスワードだけではセッションは発行されず、チャレンジが返る
+	challenge := func() string {
+		w := postJSON(t, "/login", map[string]interface{}{"email": email, "password": "Test1234!@#$"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, findCookie(w.Result().Cookies(), "token"))
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "mfa_required", body["message"])
+		token, _ := body["mfa_token"].(string)
+		return token
+	}
+	mfaToken := challenge()
+
+	// チャレンジはアクセストークンとしては使えない
+	req, _ := http.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("Authorization", "Bearer "+mfaToken)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": mfaToken, "code": "not-a-code"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": "invalid", "code": code(now.Add(30 * time.Second))})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	// アクセストークンもチャレンジとしては使えない
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": findCookie(session, "token").Value, "code": code(now.Add(30 * time.Second))})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	next := code(now.Add(30 * time.Second))
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": mfaToken, "code": next})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, findCookie(w.Result().Cookies(), "token"))
+
+	// 使用済みのコードは再利用できない
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": challenge(), "code": next})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// リカバリーコードは1回だけ使える
+	recovery := strings.ToUpper(confirmed.RecoveryCodes[0])
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": challenge(), "code": recovery})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": challenge(), "code": recovery})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/mfa", nil)
+	for _, cookie := range session {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"enabled":true,"recovery_codes_remaining":9}`, w.Body.String())
+
+	// 再発行・無効化にはパスワードと2要素目が必要
+	w = postJSONWithCookies(t, "/mfa/recovery-codes", map[string]interface{}{"password": "Wrong1234!@#$", "code": confirmed.RecoveryCodes[1]}, session)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = postJSONWithCookies(t, "/mfa/recovery-codes", map[string]interface{}{"password": "Test1234!@#$", "code": confirmed.RecoveryCodes[1]}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var regenerated struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &regenerated))
+	assert.Len(t, regenerated.RecoveryCodes, 10)
+
+	// 古いリカバリーコードは無効になっている
+	w = postJSONWithCookies(t, "/mfa/disable", map[string]interface{}{"password": "Test1234!@#$", "code": confirmed.RecoveryCodes[2]}, session)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSONWithCookies(t, "/mfa/disable", map[string]interface{}{"password": "Test1234!@#$", "code": regenerated.RecoveryCodes[0]}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 無効化後は通常のログインに戻る
+	cookies := login(t, email)
+	assert.NotNil(t, findCookie(cookies, "token"))
+}
+
+func TestMFAReauthenticationLockout(t *testing.T) {
+	setupTestServer(t)
+
+	email := "mfa_guess_test@example.com"
+	createTestUserWithEmail(t, email)
+	session := login(t, email)
+
+	w := postJSONWithCookies(t, "/mfa/totp/setup", nil, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	code, err := mfa.CurrentCode(enrollment.Secret, time.Now())
+	assert.NoError(t, err)
+	w = postJSONWithCookies(t, "/mfa/totp/confirm", map[string]interface{}{"code": code}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &confirmed))
+
+	// セッションを奪われても、パスワードや2要素目の総当たりはログインと同じくロックされる
+	for i := 0; i < 3; i++ {
+		w = postJSONWithCookies(t, "/mfa/disable", map[string]interface{}{"password": "Wrong1234!@#$", "code": confirmed.RecoveryCodes[0]}, session)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		w = postJSONWithCookies(t, "/mfa/recovery-codes", map[string]interface{}{"password": "Test1234!@#$", "code": "00000000"}, session)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w = postJSONWithCookies(t, "/mfa/disable", map[string]interface{}{"password": "Test1234!@#$", "code": confirmed.RecoveryCodes[0]}, session)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"AUTH_LOCKED"`)
+
+	// ログインも同じカウンターでロックされる
+	w = postJSON(t, "/login", map[string]interface{}{"email": email, "password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	time.Sleep(1100 * time.Millisecond)
+	w = postJSONWithCookies(t, "/mfa/disable", map[string]interface{}{"password": "Test1234!@#$", "code": confirmed.RecoveryCodes[0]}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := oidctest.NewServer("mydeer", "s3cret")
+	defer provider.Close()
+	setupTestServerWith(t, func(cfg *config.Config) {
+		cfg.Auth.OIDC.Providers = append(cfg.Auth.OIDC.Providers, provider.Config("mock"))
+	})
+
+	// ssoLogin runs the redirect flow through the mock provider and returns the callback response
+	ssoLogin := func(user oidctest.User) *httptest.ResponseRecorder {
+		provider.SetUser(user)
+
+		req, _ := http.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		if !assert.Equal(t, http.StatusFound, w.Code) {
+			return w
+		}
+		flowCookie := findCookie(w.Result().Cookies(), "oidc_flow")
+		assert.NotNil(t, flowCookie)
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(w.Header().Get("Location"))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "/auth/oidc/mock/callback", callback.Path)
+
+		req, _ = http.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+		req.AddCookie(flowCookie)
+		w = httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	identities := func(cookies []*http.Cookie) []interface{} {
+		req, _ := http.NewRequest(http.MethodGet, "/auth/identities", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Identities []interface{} `json:"identities"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Identities
+	}
+
+	// SSOでの新規登録
+	w := ssoLogin(oidctest.User{Subject: "sub-new", Email: "sso_new@example.com", EmailVerified: true, Name: "SSO Player"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	session := w.Result().Cookies()
+	assert.NotNil(t, findCookie(session, "token"))
+	assert.Len(t, identities(session), 1)
+
+	// 2回目は紐付け済みのアカウントでログイン（メールアドレスが変わっても sub で識別）
+	w = ssoLogin(oidctest.User{Subject: "sub-new", Email: "changed@example.com", EmailVerified: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, identities(w.Result().Cookies()), 1)
+
+	// SSOで作られたアカウントにはパスワードでログインできない
+	w = postJSON(t, "/login", map[string]interface{}{"email": "sso_new@example.com", "password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 既存アカウントへの紐付けはプロバイダーが確認済みのメールアドレスの場合のみ
+	createTestUserWithEmail(t, "sso_existing@example.com")
+	w = ssoLogin(oidctest.User{Subject: "sub-existing", Email: "sso_existing@example.com", EmailVerified: false})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = ssoLogin(oidctest.User{Subject: "sub-existing", Email: "sso_existing@example.com", EmailVerified: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, identities(w.Result().Cookies()), 1)
+	// パスワードでのログインも引き続き使える
+	login(t, "sso_existing@example.com")
+
+	// メールアドレス未確認のアカウント（第三者が先に登録したもの）は、登録時のパスワードを無効にしてから紐付ける
+	w = postJSON(t, "/signup", map[string]interface{}{"email": "sso_victim@example.com", "password": "Attacker1234!@#$", "name": "Attacker"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = ssoLogin(oidctest.User{Subject: "sub-victim", Email: "sso_victim@example.com", EmailVerified: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, identities(w.Result().Cookies()), 1)
+	w = postJSON(t, "/login", map[string]interface{}{"email": "sso_victim@example.com", "password": "Attacker1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// stateが一致しないコールバックは拒否する
+	req, _ := http.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?code=x&state=forged", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 未設定のプロバイダー
+	req, _ = http.NewRequest(http.MethodGet, "/auth/oidc/unknown/login", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRefreshAndLogoutEndpoints(t *testing.T) {
+	setupTestServer(t)
+
+	createTestUserWithEmail(t, "refresh_test@example.com")
+	loginCookies := login(t, "refresh_test@example.com")
+	refreshCookie := findCookie(loginCookies, "refresh_token")
+	if !assert.NotNil(t, refreshCookie, "Refresh token cookie not set") {
+		return
+	}
+
+	// Rotate the refresh token
+	w := postWithCookies(t, "/token/refresh", refreshCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+	rotated := findCookie(w.Result().Cookies(), "refresh_token")
+	if !assert.NotNil(t, rotated, "Rotated refresh token cookie not set") {
+		return
+	}
+	assert.NotEqual(t, refreshCookie.Value, rotated.Value)
+
+	// Reusing the old refresh token revokes the whole session family
+	w = postWithCookies(t, "/token/refresh", refreshCookie)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postWithCookies(t, "/token/refresh", rotated)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Logout revokes a fresh session
+	loginCookies = login(t, "refresh_test@example.com")
+	w = postWithCookies(t, "/logout", findCookie(loginCookies, "refresh_token"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = postWithCookies(t, "/token/refresh", findCookie(loginCookies, "refresh_token"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	setupTestServer(t)
+
+	createTestUserWithEmail(t, "auth_test@example.com")
+	cookies := login(t, "auth_test@example.com")
+	accessCookie := findCookie(cookies, "token")
+	if !assert.NotNil(t, accessCookie, "Token cookie not set") {
+		return
+	}
+
+	// Cookie authentication
+	req, _ := http.NewRequest(http.MethodGet, "/auth", nil)
+	req.AddCookie(accessCookie)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var principal map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &principal))
+	assert.NotEmpty(t, principal["user_id"])
+	assert.Equal(t, "Session Test User", principal["player_name"])
+
+	// Bearer header authentication
+	req, _ = http.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("Authorization", "Bearer "+accessCookie.Value)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// No credentials
+	req, _ = http.NewRequest(http.MethodGet, "/auth", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCSRFProtection(t *testing.T) {
+	setupTestServerWith(t, func(cfg *config.Config) {
+		cfg.Cookie.CSRF = true
+		cfg.Auth.EchoTokenHeader = false
+	})
+
+	createTestUserWithEmail(t, "csrf_test@example.com")
+	jsonBody, _ := json.Marshal(map[string]interface{}{"email": "csrf_test@example.com", "password": "Test1234!@#$"})
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Authorization"), "token must not be echoed when echo_token_header is disabled")
+
+	cookies := w.Result().Cookies()
+	accessCookie := findCookie(cookies, "token")
+	csrfCookie := findCookie(cookies, "csrf_token")
+	if !assert.NotNil(t, accessCookie) || !assert.NotNil(t, csrfCookie) {
+		return
+	}
+	assert.True(t, accessCookie.HttpOnly)
+	assert.False(t, csrfCookie.HttpOnly, "the client must be able to read the csrf token")
+	assert.NotEmpty(t, csrfCookie.Value)
+
+	// GET はCSRFトークン無しでも通る
+	req, _ = http.NewRequest(http.MethodGet, "/auth", nil)
+	req.AddCookie(accessCookie)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Cookie認証の状態変更リクエストはヘッダーが無い・一致しないと拒否される
+	w = postWithCookies(t, "/mfa/totp/setup", accessCookie, csrfCookie)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"CSRF_INVALID"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/mfa/totp/setup", nil)
+	req.AddCookie(accessCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", "forged")
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/mfa/totp/setup", nil)
+	req.AddCookie(accessCookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Bearer認証はブラウザが自動で送らないのでCSRF対策の対象外
+	req, _ = http.NewRequest(http.MethodPost, "/mfa/totp/setup", nil)
+	req.Header.Set("Authorization", "Bearer "+accessCookie.Value)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Bearer 以外の Authorization ヘッダーではCookieで認証されるので対象のまま
+	for _, header := range []string{"Basic eDp5", "Bearer ", "Bearer"} {
+		req, _ = http.NewRequest(http.MethodPost, "/mfa/totp/setup", nil)
+		req.Header.Set("Authorization", header)
+		req.AddCookie(accessCookie)
+		req.AddCookie(csrfCookie)
+		w = httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, header)
+	}
+
+	// リフレッシュトークンのCookieを使うエンドポイントも対象
+	w = postWithCookies(t, "/token/refresh", findCookie(cookies, "refresh_token"), csrfCookie)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/token/refresh", nil)
+	req.AddCookie(findCookie(cookies, "refresh_token"))
+	req.AddCookie(csrfCookie)
+	req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	rotated := findCookie(w.Result().Cookies(), "csrf_token")
+	if assert.NotNil(t, rotated) {
+		assert.NotEqual(t, csrfCookie.Value, rotated.Value)
+	}
+}
+
+func TestHostPrefixedCookies(t *testing.T) {
+	setupTestServerWith(t, func(cfg *config.Config) {
+		cfg.Cookie.Secure = true
+		cfg.Cookie.Domain = ""
+		cfg.Cookie.HostPrefix = true
+	})
+
+	createTestUserWithEmail(t, "host_prefix@example.com")
+	cookies := login(t, "host_prefix@example.com")
+	assert.Nil(t, findCookie(cookies, "token"))
+	for _, name := range []string{"__Host-token", "__Host-refresh_token", "__Host-csrf_token"} {
+		cookie := findCookie(cookies, name)
+		if assert.NotNil(t, cookie, name) {
+			assert.True(t, cookie.Secure)
+			assert.Equal(t, "/", cookie.Path)
+			assert.Empty(t, cookie.Domain)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/auth", nil)
+	req.AddCookie(findCookie(cookies, "__Host-token"))
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = postWithCookies(t, "/token/refresh", findCookie(cookies, "__Host-refresh_token"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	setupTestServer(t)
+
+	createTestUserWithEmail(t, "metrics_test@example.com")
+	login(t, "metrics_test@example.com")
+	w := postJSON(t, "/login", map[string]interface{}{"email": "metrics_test@example.com", "password": "Wrong1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 標準以外のメソッドはひとつのラベルにまとめる
+	for _, method := range []string{"FOO", "BAR"} {
+		req, _ := http.NewRequest(method, "/nowhere", nil)
+		testRouter.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, `mydeer_http_request_duration_seconds_count{method="other",route="unmatched",status="404"} 2`)
+	assert.NotContains(t, body, `method="FOO"`)
+	assert.Contains(t, body, `mydeer_signups_total{method="password"} 1`)
+	assert.Contains(t, body, `mydeer_login_success_total{method="password"} 1`)
+	assert.Contains(t, body, `mydeer_login_failures_total{method="password",reason="invalid_credentials"} 1`)
+	assert.Contains(t, body, `mydeer_http_request_duration_seconds_count{method="POST",route="/login",status="200"} 1`)
+	assert.Contains(t, body, `mydeer_http_request_duration_seconds_count{method="POST",route="/login",status="401"} 1`)
+	assert.Contains(t, body, "mydeer_active_sessions ")
+	assert.Contains(t, body, "go_goroutines ")
+}
+
+func TestRequestLogging(t *testing.T) {
+	// サーバーのロガーをバッファに差し替える（Deps.Logger が無い場合は slog.Default を使う）
+	var logs bytes.Buffer
+	logger, err := logging.New(config.LoggingConfig{Level: "info", Format: config.LogFormatJSON}, &logs)
+	assert.NoError(t, err)
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	setupTestServer(t)
+
+	createTestUserWithEmail(t, "request_log@example.com")
+	token := findCookie(login(t, "request_log@example.com"), "token")
+	w := postJSON(t, "/login", map[string]interface{}{"email": "request_log@example.com", "password": "Wrong1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 受け取ったリクエストIDを引き継いで返す
+	req, _ := http.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("X-Request-ID", "edge-1234")
+	req.RemoteAddr = "203.0.113.7:41234"
+	req.AddCookie(token)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "edge-1234", w.Header().Get("X-Request-ID"))
+
+	// ログに書けない文字を含むIDは採用せず新しく発行する
+	req, _ = http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	req.Header.Set("X-Request-ID", "bad id\n")
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.NotEqual(t, "bad id\n", w.Header().Get("X-Request-ID"))
+	_, err = uuid.Parse(w.Header().Get("X-Request-ID"))
+	assert.NoError(t, err)
+
+	var access map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if assert.NoError(t, json.Unmarshal([]byte(line), &entry)) && entry["msg"] == "request_completed" && entry["request_id"] == "edge-1234" {
+			access = entry
+		}
+	}
+	if assert.NotNil(t, access, "no access log for the request") {
+		assert.Equal(t, "/auth", access["route"])
+		assert.Equal(t, float64(http.StatusOK), access["status"])
+		assert.NotEmpty(t, access["user_id"])
+		assert.Equal(t, "203.0.113.7", access["client_ip"])
+		assert.Contains(t, access, "latency_ms")
+		assert.Positive(t, access["bytes"])
+	}
+
+	// ログインに使ったメールアドレスはマスクされる
+	assert.NotContains(t, logs.String(), "request_log@example.com")
+	assert.Contains(t, logs.String(), `"email":"r***@example.com"`)
+}
+
+func TestTracing(t *testing.T) {
+	setupTestServer(t)
+
+	// 記録用のトレーサーに差し替える（伝播の設定は本番と同じ tracing.Setup で行う）
+	_, err := tracing.Setup(context.Background(), config.TracingConfig{})
+	assert.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	createTestUserWithEmail(t, "tracing_test@example.com")
+	token := findCookie(login(t, "tracing_test@example.com"), "token")
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, _ := http.NewRequest(http.MethodGet, "/auth/identities", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	req.AddCookie(token)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var server sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID && span.SpanKind() == trace.SpanKindServer {
+			server = span
+		}
+	}
+	if !assert.NotNil(t, server, "no server span joined the incoming trace") {
+		return
+	}
+	assert.Equal(t, "GET /auth/identities", server.Name())
+	assert.Equal(t, parentID, server.Parent().SpanID().String())
+	assert.Contains(t, server.Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+
+	req, _ = http.NewRequest("FOO", "/auth/identities", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	testRouter.ServeHTTP(httptest.NewRecorder(), req)
+	ended := recorder.Ended()
+	assert.Equal(t, middleware.OtherMethod, ended[len(ended)-1].Name())
+
+	// PostgreSQLではクエリのスパンがリクエストのスパンの子になる
+	if usePostgres {
+		var queries int
+		for _, span := range recorder.Ended() {
+			if span.SpanKind() == trace.SpanKindClient && span.Parent().SpanID() == server.SpanContext().SpanID() {
+				queries++
+			}
+		}
+		assert.Positive(t, queries)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// PostgreSQLのカウンターは実行をまたいで残るため、ルール名を実行ごとに変える
+	suffix := uuid.NewString()[:8]
+	setupTestServerWith(t, func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.Rules = []config.RateLimitRule{
+			{Name: "login-" + suffix, Routes: []string{"POST /login"}, Limit: 2, Window: config.Duration(time.Minute), By: "ip"},
+			{Name: "session-" + suffix, Routes: []string{"/auth"}, Limit: 2, Window: config.Duration(time.Minute), By: "player"},
+			{Name: "forgot-" + suffix, Routes: []string{"POST /password/forgot"}, Limit: 1, Window: config.Duration(time.Minute), By: "ip"},
+		}
+	})
+
+	createTestUserWithEmail(t, "rate_limit_a@example.com")
+	createTestUserWithEmail(t, "rate_limit_b@example.com")
+	playerA := findCookie(login(t, "rate_limit_a@example.com"), "token")
+	playerB := findCookie(login(t, "rate_limit_b@example.com"), "token")
+
+	// クライアントIPごとの制限（上のログイン2回で使い切っている）
+	w := postJSON(t, "/login", map[string]interface{}{"email": "rate_limit_a@example.com", "password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"RATE_LIMITED"`)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+	assert.NotEmpty(t, w.Header().Get("RateLimit-Reset"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// 信用していない接続元が X-Forwarded-For を変えても同じIPとして数える
+	forgot := func(forwardedFor string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(map[string]interface{}{"email": "rate_limit_a@example.com"})
+		req, _ := http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.RemoteAddr = "203.0.113.21:41234"
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusAccepted, forgot("198.51.100.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, forgot("198.51.100.2").Code)
+
+	// プレイヤーごとの制限
+	session := func(token *http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/auth", nil)
+		req.AddCookie(token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	w = session(playerA)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	w = session(playerA)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	w = session(playerA)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	w = session(playerB)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+	// ルールの無いエンドポイントは制限しない
+	req, _ := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestPlayerProfile(t *testing.T) {
+	setupTestServer(t)
+
+	get := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	fieldRule := func(w *httptest.ResponseRecorder) (string, string) {
+		var response struct {
+			Details []map[string]interface{} `json:"details"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		if !assert.Len(t, response.Details, 1) {
+			return "", ""
+		}
+		rule, _ := response.Details[0]["rule"].(string)
+		field, _ := response.Details[0]["field"].(string)
+		return field, rule
+	}
+
+	email := "profile_test@example.com"
+	w := postJSON(t, "/signup", map[string]interface{}{"email": email, "password": "Test1234!@#$", "name": "Profile Tester", "handle": "Deer_Walker"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	verifyEmail(t, email)
+	cookies := login(t, email)
+
+	// 公開プロフィールは大文字小文字を区別せずに引ける
+	w = get("/players/deer_walker", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var player map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &player))
+	assert.Equal(t, "Deer_Walker", player["handle"])
+	assert.Equal(t, "Profile Tester", player["display_name"])
+	assert.NotContains(t, player, "email")
+
+	w = get("/players/nobody_here", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// /me にはパスワード（ハッシュ）を含めない
+	w = get("/me", cookies)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, strings.ToLower(w.Body.String()), "password")
+	var me map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, email, me["email"])
+	assert.Equal(t, true, me["email_verified"])
+
+	w = requestJSON(t, http.MethodPatch, "/me", map[string]interface{}{"handle": "Forest_Deer", "bio": "Hello!", "avatar_ref": "https://cdn.example.com/a.png"}, cookies)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, "Forest_Deer", me["handle"])
+	assert.Equal(t, "Hello!", me["bio"])
+	assert.Equal(t, "Profile Tester", me["display_name"])
+	assert.Equal(t, http.StatusNotFound, get("/players/Deer_Walker", nil).Code)
+	assert.Equal(t, http.StatusOK, get("/players/Forest_Deer", nil).Code)
+
+	// 形式・予約語・重複
+	w = requestJSON(t, http.MethodPatch, "/me", map[string]interface{}{"handle": "1st"}, cookies)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	field, rule := fieldRule(w)
+	assert.Equal(t, "handle", field)
+	assert.Equal(t, "handle", rule)
+
+	w = requestJSON(t, http.MethodPatch, "/me", map[string]interface{}{"handle": "Admin"}, cookies)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, rule = fieldRule(w)
+	assert.Equal(t, "reservedhandle", rule)
+
+	w = postJSON(t, "/signup", map[string]interface{}{"email": "profile_dup@example.com", "password": "Test1234!@#$", "name": "Dup", "handle": "forest_deer"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"handle"`)
+
+	// 省略すると自動生成される
+	createTestUserWithEmail(t, "profile_generated@example.com")
+	w = get("/me", login(t, "profile_generated@example.com"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Regexp(t, `^player_[a-z0-9]{10}$`, me["handle"])
+
+	// 自動生成された handle はそのまま送り返せるが、他の予約済みの handle には変えられない
+	generated := login(t, "profile_generated@example.com")
+	handle, _ := me["handle"].(string)
+	for _, same := range []string{handle, strings.ToUpper(handle)} {
+		w = requestJSON(t, http.MethodPatch, "/me", map[string]interface{}{"handle": same, "bio": "Still me"}, generated)
+		assert.Equal(t, http.StatusOK, w.Code, same)
+	}
+	w = requestJSON(t, http.MethodPatch, "/me", map[string]interface{}{"handle": "player_0000000000"}, generated)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, rule = fieldRule(w)
+	assert.Equal(t, "reservedhandle", rule)
+
+	// 未認証では使えない
+	assert.Equal(t, http.StatusUnauthorized, get("/me", nil).Code)
+}
+
+func TestAccountDeletion(t *testing.T) {
+	setupTestServer(t)
+
+	email := "leaving_test@example.com"
+	w := postJSON(t, "/signup", map[string]interface{}{"email": email, "password": "Test1234!@#$", "name": "Leaving", "handle": "Leaving_Deer"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	verifyEmail(t, email)
+	otherSession := login(t, email)
+	session := login(t, email)
+
+	// 2段階認証を有効にする
+	w = postJSONWithCookies(t, "/mfa/totp/setup", nil, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	code, err := mfa.CurrentCode(enrollment.Secret, time.Now())
+	assert.NoError(t, err)
+	w = postJSONWithCookies(t, "/mfa/totp/confirm", map[string]interface{}{"code": code}, session)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &confirmed))
+
+	// パスワードと2要素目で本人確認する
+	w = requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Wrong1234!@#$"}, session)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Test1234!@#$"}, session)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code"`)
+
+	w = requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Test1234!@#$", "code": confirmed.RecoveryCodes[0]}, session)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var scheduled struct {
+		Message     string    `json:"message"`
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &scheduled))
+	assert.Equal(t, "deletion_scheduled", scheduled.Message)
+	assert.True(t, scheduled.DeleteAfter.After(time.Now().Add(24*time.Hour)))
+
+	// すべてのセッションが失効し、プロフィールは非公開になる
+	w = postWithCookies(t, "/token/refresh", findCookie(otherSession, "refresh_token"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postWithCookies(t, "/token/refresh", findCookie(session, "refresh_token"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	req, _ := http.NewRequest(http.MethodGet, "/players/Leaving_Deer", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 猶予期間中にログインすると退会は取り消される
+	w = postJSON(t, "/login", map[string]interface{}{"email": email, "password": "Test1234!@#$"})
+	var challenge map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+	w = postJSON(t, "/login/mfa", map[string]interface{}{"mfa_token": challenge["mfa_token"], "code": confirmed.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusOK, w.Code)
+	session = w.Result().Cookies()
+	req, _ = http.NewRequest(http.MethodGet, "/players/Leaving_Deer", nil)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	purged, err := testAccounts.PurgeDue(context.Background(), time.Now().Add(365*24*time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, purged)
+
+	// 再度退会し、猶予期間が過ぎると削除される
+	w = requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Test1234!@#$", "code": confirmed.RecoveryCodes[2]}, session)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	purged, err = testAccounts.PurgeDue(context.Background(), time.Now().Add(365*24*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, purged, 1)
+
+	w = postJSON(t, "/login", map[string]interface{}{"email": email, "password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// handle とメールアドレスは再び使える
+	w = postJSON(t, "/signup", map[string]interface{}{"email": email, "password": "Test1234!@#$", "name": "Back", "handle": "Leaving_Deer"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAccountDeletionLockout(t *testing.T) {
+	setupTestServer(t)
+
+	email := "rage_quit_test@example.com"
+	createTestUserWithEmail(t, email)
+	session := login(t, email)
+
+	// 退会時のパスワード確認もログインと同じくロックされる
+	for i := 0; i < 5; i++ {
+		w := requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Wrong1234!@#$"}, session)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	w := requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Test1234!@#$"}, session)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"AUTH_LOCKED"`)
+
+	time.Sleep(1100 * time.Millisecond)
+	w = requestJSON(t, http.MethodDelete, "/me", map[string]interface{}{"password": "Test1234!@#$"}, session)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestAccountExport(t *testing.T) {
+	setupTestServer(t)
+
+	email := "export_test@example.com"
+	createTestUserWithEmail(t, email)
+	cookies := login(t, email)
+
+	req, _ := http.NewRequest(http.MethodGet, "/me/export", nil)
+	req.Header.Set("User-Agent", "export-test")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `^attachment; filename="mydeer-export-\d{8}\.json"$`, w.Header().Get("Content-Disposition"))
+
+	var archive struct {
+		Account struct {
+			Email       string `json:"email"`
+			HasPassword bool   `json:"has_password"`
+		} `json:"account"`
+		Profile struct {
+			DisplayName string `json:"display_name"`
+		} `json:"profile"`
+		Sessions     []map[string]interface{} `json:"sessions"`
+		EmailedLinks []map[string]interface{} `json:"emailed_links"`
+		AuditLog     []struct {
+			Action    string `json:"action"`
+			UserAgent string `json:"user_agent"`
+		} `json:"audit_log"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &archive))
+	assert.Equal(t, email, archive.Account.Email)
+	assert.True(t, archive.Account.HasPassword)
+	assert.Equal(t, "Session Test User", archive.Profile.DisplayName)
+	assert.Len(t, archive.Sessions, 1)
+	if assert.Len(t, archive.EmailedLinks, 1) {
+		assert.Equal(t, db.TokenPurposeEmailVerification, archive.EmailedLinks[0]["purpose"])
+	}
+	if assert.Len(t, archive.AuditLog, 1) {
+		assert.Equal(t, account.ActionExported, archive.AuditLog[0].Action)
+		assert.Equal(t, "export-test", archive.AuditLog[0].UserAgent)
+	}
+
+	// ハッシュや秘密情報は含めない
+	body := w.Body.String()
+	assert.NotContains(t, body, "argon2id")
+	assert.NotContains(t, body, "token_hash")
+	assert.NotContains(t, body, "secret")
+}
+
+func TestAdminAPI(t *testing.T) {
+	setupTestServer(t)
+	ctx := context.Background()
+
+	// テスト用のアカウントを作ってロールを割り当て、IDを返す
+	newUser := func(email string, roles ...string) uuid.UUID {
+		createTestUserWithEmail(t, email)
+		user, err := testUsers.GetUserByEmail(ctx, email)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if len(roles) > 0 {
+			_, err = testAccounts.SetRoles(ctx, user.ID, roles, account.Client{})
+			assert.NoError(t, err)
+		}
+		return user.ID
+	}
+	adminID := newUser("admin_api_admin@example.com", "admin")
+	moderatorID := newUser("admin_api_moderator@example.com", "moderator")
+	playerID := newUser("admin_api_player@example.com")
+
+	adminSession := login(t, "admin_api_admin@example.com")
+	moderatorSession := login(t, "admin_api_moderator@example.com")
+	playerSession := login(t, "admin_api_player@example.com")
+
+	// アクセストークンにはロールが入る
+	w := requestJSON(t, http.MethodGet, "/auth", nil, adminSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"roles":["player","admin"]`)
+
+	// 一般のプレイヤーは管理APIを使えない
+	w = requestJSON(t, http.MethodGet, "/admin/users", nil, playerSession)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"AUTH_FORBIDDEN"`)
+	w = requestJSON(t, http.MethodGet, "/admin/users", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 検索
+	w = requestJSON(t, http.MethodGet, "/admin/users?q=ADMIN_API_&limit=2", nil, moderatorSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Users []struct {
+			ID    uuid.UUID `json:"id"`
+			Email string    `json:"email"`
+		} `json:"users"`
+		Total int `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Total)
+	assert.Len(t, page.Users, 2)
+	w = requestJSON(t, http.MethodGet, "/admin/users?limit=1000", nil, moderatorSession)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = requestJSON(t, http.MethodGet, "/admin/users/not-a-uuid", nil, moderatorSession)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 利用停止するとログアウトされ、ログインできなくなる
+	w = requestJSON(t, http.MethodPost, "/admin/users/"+playerID.String()+"/suspend", nil, moderatorSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"roles":["player"]`)
+	assert.NotContains(t, w.Body.String(), `"suspended_at":null`)
+	w = requestJSON(t, http.MethodGet, "/auth", nil, playerSession)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(t, "/login", map[string]interface{}{"email": "admin_api_player@example.com", "password": "Test1234!@#$"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"AUTH_SUSPENDED"`)
+	w = postJSON(t, "/login", map[string]interface{}{"email": "admin_api_player@example.com", "password": "Wrong1234!@#$"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = requestJSON(t, http.MethodPost, "/admin/users/"+playerID.String()+"/unsuspend", nil, moderatorSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"suspended_at":null`)
+	playerSession = login(t, "admin_api_player@example.com")
+
+	// 強制ログアウト
+	w = requestJSON(t, http.MethodPost, "/admin/users/"+playerID.String()+"/logout", nil, moderatorSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = postWithCookies(t, "/token/refresh", findCookie(playerSession, "refresh_token"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// モデレーターは管理者・自分自身を操作できず、ロールも変更できない
+	w = requestJSON(t, http.MethodPost, "/admin/users/"+adminID.String()+"/suspend", nil, moderatorSession)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = requestJSON(t, http.MethodPost, "/admin/users/"+moderatorID.String()+"/logout", nil, moderatorSession)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = requestJSON(t, http.MethodPut, "/admin/users/"+playerID.String()+"/roles", map[string]interface{}{"roles": []string{"moderator"}}, moderatorSession)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// 管理者によるロール変更
+	w = requestJSON(t, http.MethodPut, "/admin/users/"+playerID.String()+"/roles", map[string]interface{}{"roles": []string{"owner"}}, adminSession)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"roles"`)
+	w = requestJSON(t, http.MethodPut, "/admin/users/"+adminID.String()+"/roles", map[string]interface{}{"roles": []string{}}, adminSession)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = requestJSON(t, http.MethodPut, "/admin/users/"+playerID.String()+"/roles", map[string]interface{}{"roles": []string{"moderator"}}, adminSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"roles":["player","moderator"]`)
+
+	// 権限はリクエストごとにDBで確認されるので、ロールを外すとトークンに残っていても使えなくなる
+	playerSession = login(t, "admin_api_player@example.com")
+	w = requestJSON(t, http.MethodPut, "/admin/users/"+playerID.String()+"/roles", map[string]interface{}{"roles": []string{}}, adminSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = requestJSON(t, http.MethodGet, "/admin/users/"+moderatorID.String(), nil, playerSession)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = requestJSON(t, http.MethodGet, "/admin/roles", nil, adminSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"roles:assign"`)
+
+	// アカウント単位のログインのロックも確認できる
+	w = requestJSON(t, http.MethodGet, "/admin/users/"+playerID.String(), nil, moderatorSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"lockout":null`)
+	for i := 0; i < 5; i++ {
+		w = postJSON(t, "/login", map[string]interface{}{"email": "admin_api_player@example.com", "password": "Wrong1234!@#$"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w = requestJSON(t, http.MethodGet, "/admin/users/"+playerID.String(), nil, moderatorSession)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var detail struct {
+		Lockout *struct {
+			Failures    int        `json:"failures"`
+			LockedUntil *time.Time `json:"locked_until"`
+		} `json:"lockout"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	if assert.NotNil(t, detail.Lockout) {
+		assert.Equal(t, 5, detail.Lockout.Failures)
+		if assert.NotNil(t, detail.Lockout.LockedUntil) {
+			assert.True(t, detail.Lockout.LockedUntil.After(time.Now()))
+		}
+	}
+
+	// 操作は管理者のIDと共に監査ログに残る
+	entries, err := testAccounts.Export(ctx, playerID, account.Client{})
+	assert.NoError(t, err)
+	var actions []string
+	for _, entry := range entries.AuditLogs {
+		actions = append(actions, entry.Action)
+		if entry.Action == account.ActionSuspended {
+			assert.Equal(t, moderatorID, entry.ActorID.UUID)
+		}
+	}
+	assert.Equal(t, []string{account.ActionSuspended, account.ActionUnsuspended, account.ActionSessionsRevoked, account.ActionRolesChanged, account.ActionRolesChanged, account.ActionExported}, actions)
+}
+
+func createTestUserWithEmail(t *testing.T, email string) {
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"email":    email,
+		"password": "Test1234!@#$",
+		"name":     "Session Test User",
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	verifyEmail(t, email)
+}
+
+// verificationToken returns the token of the last verification email sent to the address
+func verificationToken(t *testing.T, email string) string {
+	return mailedToken(t, email, "/verify-email")
+}
+
+// mailedToken returns the token of the last link to path emailed to the address
+func mailedToken(t *testing.T, email, path string) string {
+	pattern := regexp.MustCompile(regexp.QuoteMeta(path) + `\?token=([A-Za-z0-9_-]+)`)
+	messages := strings.Split(testMail.String(), "\n----\n")
+	for i := len(messages) - 1; i >= 0; i-- {
+		if !strings.Contains(messages[i], "To: "+email+"\r\n") {
+			continue
+		}
+		if m := pattern.FindStringSubmatch(messages[i]); m != nil {
+			return m[1]
+		}
+	}
+	t.Fatalf("no email with a %s link sent to %s", path, email)
+	return ""
+}
+
+// verifyEmail confirms the address with the token from the last verification email
+func verifyEmail(t *testing.T, email string) {
+	req, _ := http.NewRequest(http.MethodGet, "/verify-email?token="+verificationToken(t, email), nil)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func login(t *testing.T, email string) []*http.Cookie {
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"email":    email,
+		"password": "Test1234!@#$",
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	return w.Result().Cookies()
+}
+
+func postWithCookies(t *testing.T, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPost, path, nil)
+	assert.NoError(t, err)
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+func postJSON(t *testing.T, path string, body interface{}) *httptest.ResponseRecorder {
+	return postJSONWithCookies(t, path, body, nil)
+}
+
+func postJSONWithCookies(t *testing.T, path string, body interface{}, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	return requestJSON(t, http.MethodPost, path, body, cookies)
+}
+
+func requestJSON(t *testing.T, method, path string, body interface{}, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	jsonBody, err := json.Marshal(body)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
 }
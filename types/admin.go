@@ -0,0 +1,72 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/db"
+	"github.com/my-deer/mydeer/internal/rbac"
+)
+
+// AdminUser is an account as shown to moderators, returned by GET /admin/users
+type AdminUser struct {
+	ID            uuid.UUID  `json:"id"`
+	Email         string     `json:"email"`
+	Name          string     `json:"name"`
+	Handle        string     `json:"handle"`
+	EmailVerified bool       `json:"email_verified"`
+	SuspendedAt   *time.Time `json:"suspended_at"`
+	DeleteAfter   *time.Time `json:"delete_after"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// AdminUserDetail is an account with its roles, returned by GET /admin/users/:id and the operations on it
+type AdminUserDetail struct {
+	AdminUser
+	Roles []string `json:"roles"`
+	// Lockout is the failed login counter of the email address, nil when there is none
+	Lockout *AdminLockout `json:"lockout"`
+}
+
+// AdminLockout is the failed login counter of an account. LockedUntil is set while logins are refused.
+type AdminLockout struct {
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// AdminRole is a role with the permissions it grants, returned by GET /admin/roles
+type AdminRole struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// NewAdminUser builds the moderator view of an account
+func NewAdminUser(u db.User, handle string) AdminUser {
+	return AdminUser{
+		ID:            u.ID,
+		Email:         u.Email,
+		Name:          u.Name,
+		Handle:        handle,
+		EmailVerified: u.EmailVerifiedAt.Valid,
+		SuspendedAt:   timePtr(u.SuspendedAt),
+		DeleteAfter:   timePtr(u.DeleteAfter),
+		CreatedAt:     u.CreatedAt.Time,
+	}
+}
+
+// NewAdminRole builds the API representation of a role
+func NewAdminRole(r rbac.Role) AdminRole {
+	return AdminRole{Name: r.Name, Description: r.Description, Permissions: r.Permissions}
+}
+
+// NewAdminLockout builds the moderator view of a failed login counter. A lockout that ended
+// before now is not reported.
+func NewAdminLockout(a db.LoginAttempt, now time.Time) *AdminLockout {
+	lockout := &AdminLockout{Failures: a.Failures, LastFailureAt: a.LastFailureAt}
+	if a.LockedUntil.Valid && a.LockedUntil.Time.After(now) {
+		lockout.LockedUntil = &a.LockedUntil.Time
+	}
+	return lockout
+}
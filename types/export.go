@@ -0,0 +1,165 @@
+package types
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/my-deer/mydeer/internal/account"
+)
+
+// Export is the archive of everything stored about a player, returned by GET /me/export.
+// Hashes of passwords, tokens and recovery codes and the TOTP secret are left out.
+type Export struct {
+	ExportedAt     time.Time             `json:"exported_at"`
+	Account        ExportAccount         `json:"account"`
+	Profile        Player                `json:"profile"`
+	Sessions       []ExportSession       `json:"sessions"`
+	LinkedAccounts []ExportLinkedAccount `json:"linked_accounts"`
+	TwoFactor      ExportTwoFactor       `json:"two_factor"`
+	EmailedLinks   []ExportEmailedLink   `json:"emailed_links"`
+	LoginFailures  *ExportLoginFailures  `json:"login_failures"`
+	AuditLog       []ExportAuditEntry    `json:"audit_log"`
+}
+
+// ExportAccount is the account row of the player
+type ExportAccount struct {
+	ID              uuid.UUID  `json:"id"`
+	Email           string     `json:"email"`
+	Name            string     `json:"name"`
+	Locale          string     `json:"locale"`
+	HasPassword     bool       `json:"has_password"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	DeleteAfter     *time.Time `json:"delete_after"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// ExportSession is a refresh token issued to the player
+type ExportSession struct {
+	ID        uuid.UUID  `json:"id"`
+	SessionID uuid.UUID  `json:"session_id"`
+	UserAgent string     `json:"user_agent"`
+	IPAddress string     `json:"ip_address"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// ExportLinkedAccount is an OpenID Connect account linked to the player
+type ExportLinkedAccount struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportTwoFactor is the 2FA state of the player
+type ExportTwoFactor struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// ExportEmailedLink is a single-use link emailed to the player, e.g. for email verification
+type ExportEmailedLink struct {
+	Purpose   string     `json:"purpose"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// ExportLoginFailures is the failed login counter of the player's email address
+type ExportLoginFailures struct {
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// ExportAuditEntry is an entry of the audit log of the player's account
+type ExportAuditEntry struct {
+	Action    string    `json:"action"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewExport builds the archive from the data collected by account.Service.Export
+func NewExport(d account.Data, exportedAt time.Time) Export {
+	e := Export{
+		ExportedAt: exportedAt,
+		Account: ExportAccount{
+			ID:              d.User.ID,
+			Email:           d.User.Email,
+			Name:            d.User.Name,
+			Locale:          d.User.Locale,
+			HasPassword:     d.User.Password != "",
+			EmailVerifiedAt: timePtr(d.User.EmailVerifiedAt),
+			DeleteAfter:     timePtr(d.User.DeleteAfter),
+			CreatedAt:       d.User.CreatedAt.Time,
+			UpdatedAt:       d.User.UpdatedAt.Time,
+		},
+		Profile: NewPlayer(d.Profile),
+		TwoFactor: ExportTwoFactor{
+			Enabled:                d.MFA.Enabled,
+			RecoveryCodesRemaining: d.MFA.RecoveryCodesRemaining,
+		},
+		Sessions:       []ExportSession{},
+		LinkedAccounts: []ExportLinkedAccount{},
+		EmailedLinks:   []ExportEmailedLink{},
+		AuditLog:       []ExportAuditEntry{},
+	}
+
+	for _, s := range d.Sessions {
+		e.Sessions = append(e.Sessions, ExportSession{
+			ID:        s.ID,
+			SessionID: s.FamilyID,
+			UserAgent: s.UserAgent,
+			IPAddress: s.IPAddress,
+			CreatedAt: s.CreatedAt.Time,
+			ExpiresAt: s.ExpiresAt,
+			RotatedAt: timePtr(s.RotatedAt),
+			RevokedAt: timePtr(s.RevokedAt),
+		})
+	}
+	for _, i := range d.Identities {
+		e.LinkedAccounts = append(e.LinkedAccounts, ExportLinkedAccount{
+			Provider:  i.Provider,
+			Subject:   i.Subject,
+			Email:     i.Email,
+			CreatedAt: i.CreatedAt.Time,
+		})
+	}
+	for _, t := range d.Tokens {
+		e.EmailedLinks = append(e.EmailedLinks, ExportEmailedLink{
+			Purpose:   t.Purpose,
+			CreatedAt: t.CreatedAt.Time,
+			ExpiresAt: t.ExpiresAt,
+			UsedAt:    timePtr(t.UsedAt),
+		})
+	}
+	if a := d.LoginAttempt; a != nil {
+		e.LoginFailures = &ExportLoginFailures{
+			Failures:      a.Failures,
+			LastFailureAt: a.LastFailureAt,
+			LockedUntil:   timePtr(a.LockedUntil),
+		}
+	}
+	for _, l := range d.AuditLogs {
+		e.AuditLog = append(e.AuditLog, ExportAuditEntry{
+			Action:    l.Action,
+			IPAddress: l.IPAddress,
+			UserAgent: l.UserAgent,
+			CreatedAt: l.CreatedAt.Time,
+		})
+	}
+	return e
+}
+
+// timePtr converts a nullable time to a pointer, nil when NULL
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
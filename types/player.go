@@ -0,0 +1,50 @@
+// Package types holds the API representations of stored data.
+// They are built from the db models field by field, so secrets such as password hashes
+// can never be serialized by accident.
+package types
+
+import (
+	"time"
+
+	"github.com/my-deer/mydeer/internal/db"
+)
+
+// Player is the public profile of a player, returned by GET /players/:handle
+type Player struct {
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarRef   string    `json:"avatar_ref"`
+	CurrentTown string    `json:"current_town"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Me is the profile of the authenticated player with the account details only they may see
+type Me struct {
+	Player
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Locale        string `json:"locale"`
+}
+
+// NewPlayer builds the public representation of a profile
+func NewPlayer(p db.PlayerProfile) Player {
+	return Player{
+		Handle:      p.Handle,
+		DisplayName: p.DisplayName,
+		Bio:         p.Bio,
+		AvatarRef:   p.AvatarRef,
+		CurrentTown: p.CurrentTown,
+		CreatedAt:   p.CreatedAt.Time,
+	}
+}
+
+// NewMe builds the representation of the authenticated player's own profile
+func NewMe(u db.User, p db.PlayerProfile) Me {
+	return Me{
+		Player:        NewPlayer(p),
+		Email:         u.Email,
+		EmailVerified: u.EmailVerifiedAt.Valid,
+		Locale:        u.Locale,
+	}
+}
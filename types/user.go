@@ -1,7 +0,0 @@
-package types
-
-type User struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
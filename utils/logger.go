@@ -1,17 +1,32 @@
 package utils
 
 import (
-	"os"
+	"context"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 )
 
 // GetLogger retrieves the logger from the gin context
-// If no logger exists in the context, it returns a default JSON logger
+// If no logger exists in the context, it returns the default logger carrying the trace ids of the request
 func GetLogger(c *gin.Context) *slog.Logger {
 	if logger, exists := c.Get("logger"); exists {
 		return logger.(*slog.Logger)
 	}
-	return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger := slog.Default()
+	if c.Request != nil {
+		logger = WithTrace(c.Request.Context(), logger)
+	}
+	return logger
+}
+
+// WithTrace adds the trace_id and span_id of the span in ctx to the logger, so log lines can be
+// joined with the trace. The logger is returned unchanged when ctx carries no span.
+func WithTrace(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return logger
+	}
+	return logger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
 }